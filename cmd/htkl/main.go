@@ -0,0 +1,138 @@
+// Command htkl renders helmtk templates to standard output.
+//
+// Usage:
+//
+//	htkl [flags] pattern...
+//
+// Every file matching the patterns is rendered together, as with
+// htkl.RenderFiles. Values are read from a YAML or JSON file given with
+// -values, and validated against the JSON Schema given with -schema. When
+// no patterns are given, the values are only validated, and printed with
+// the schema's defaults filled in.
+//
+// Rendered documents are checked against Kubernetes schemas loaded with
+// -kube, which takes an OpenAPI v2 or v3 document, or CustomResourceDefinition
+// YAML, and can be repeated.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"go.yaml.in/yaml/v3"
+
+	"helmtk.dev/code/htkl"
+	"helmtk.dev/code/htkl/kube"
+	"helmtk.dev/code/htkl/runtime"
+	"helmtk.dev/code/htkl/schema"
+)
+
+func main() {
+	valuesFile := flag.String("values", "", "read Values from a YAML or JSON `file`")
+	schemaFile := flag.String("schema", "", "validate Values against a JSON Schema `file`")
+	format := flag.String("o", "yaml", "output `format`, yaml or json")
+	check := flag.Bool("check", false, "check templates for type errors before rendering")
+	var kubeFiles []string
+	flag.Func("kube", "validate documents against Kubernetes schemas in an OpenAPI or CRD `file`", func(name string) error {
+		kubeFiles = append(kubeFiles, name)
+		return nil
+	})
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: htkl [flags] pattern...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(flag.Args(), *valuesFile, *schemaFile, kubeFiles, *format, *check); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(patterns []string, valuesFile, schemaFile string, kubeFiles []string, format string, check bool) error {
+	var opts []htkl.Option
+
+	values := runtime.Value(runtime.NewObject())
+	if valuesFile != "" {
+		data, err := os.ReadFile(valuesFile)
+		if err != nil {
+			return err
+		}
+		var native any
+		if err := yaml.Unmarshal(data, &native); err != nil {
+			return fmt.Errorf("%s: %w", valuesFile, err)
+		}
+		values = runtime.NewValue(native)
+		opts = append(opts, htkl.WithValues(values))
+	}
+
+	if schemaFile != "" {
+		data, err := os.ReadFile(schemaFile)
+		if err != nil {
+			return err
+		}
+		s, err := schema.Parse(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", schemaFile, err)
+		}
+		if len(patterns) == 0 {
+			return printValues(s, values)
+		}
+		opts = append(opts, htkl.WithValuesSchema(s))
+	}
+	if len(patterns) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	switch format {
+	case "yaml":
+		opts = append(opts, htkl.WithFormat(htkl.FormatYAML))
+	case "json":
+		opts = append(opts, htkl.WithFormat(htkl.FormatJSON))
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+	if check {
+		opts = append(opts, htkl.WithTypeCheck(nil))
+	}
+	if len(kubeFiles) > 0 {
+		v := kube.NewValidator()
+		for _, name := range kubeFiles {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			if err := v.Load(data); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		opts = append(opts, htkl.WithKubernetesValidation(v))
+	}
+
+	res, err := htkl.RenderFiles(os.DirFS("."), patterns, opts...)
+	for _, d := range res.Diagnostics {
+		fmt.Fprintln(os.Stderr, d)
+	}
+	if err != nil {
+		return fmt.Errorf("rendering failed")
+	}
+	_, err = os.Stdout.Write(res.Bytes())
+	return err
+}
+
+// printValues validates values against a schema, and prints them with the
+// schema's defaults filled in
+func printValues(s *schema.Schema, values runtime.Value) error {
+	values, err := s.Apply(values)
+	if err != nil {
+		return fmt.Errorf("invalid values:\n%w", err)
+	}
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(runtime.ToNative(values)); err != nil {
+		return err
+	}
+	return enc.Close()
+}
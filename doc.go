@@ -1 +1,14 @@
+// Package htkl renders helmtk templates into YAML or JSON documents.
+//
+// Render and RenderFiles parse, evaluate and encode templates in one call,
+// configured with options such as WithValues and WithFunction:
+//
+//	res, err := htkl.Render(ctx, src, htkl.WithValues(values))
+//	if err != nil {
+//		return err
+//	}
+//	os.Stdout.Write(res.Bytes())
+//
+// The parser, runtime and eval packages can be used directly for finer
+// control over each step.
 package htkl
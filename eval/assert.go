@@ -0,0 +1,63 @@
+package eval
+
+import (
+	"helmtk.dev/code/htkl/parser"
+	"helmtk.dev/code/htkl/runtime"
+)
+
+// evalAssertStatement fails with the statement's message when its condition is falsy
+func (e *evaluator) evalAssertStatement(n *parser.AssertStatement) error {
+	cond, err := e.evalExpression(n.Condition)
+	if err != nil {
+		return err
+	}
+	if cond.IsTruthy() {
+		return nil
+	}
+
+	msg := "assertion failed"
+	if n.Message != nil {
+		if msg, err = e.evalMessage(n.Message); err != nil {
+			return err
+		}
+	}
+	return e.failure(n.Pos, msg)
+}
+
+// evalFailStatement unconditionally fails with the statement's message
+func (e *evaluator) evalFailStatement(n *parser.FailStatement) error {
+	msg, err := e.evalMessage(n.Message)
+	if err != nil {
+		return err
+	}
+	return e.failure(n.Pos, msg)
+}
+
+func (e *evaluator) evalMessage(node parser.Expression) (string, error) {
+	val, err := e.evalExpression(node)
+	if err != nil {
+		return "", err
+	}
+	msg, err := runtime.ToString(val)
+	if err != nil {
+		return "", wraperr(node.GetPos(), err)
+	}
+	return msg, nil
+}
+
+// failure reports a failed assertion. When failures are being collected the
+// error is recorded and evaluation continues, unless a try expression is
+// there to recover from it.
+func (e *evaluator) failure(pos parser.Pos, msg string) error {
+	err := &EvalError{
+		Message:  msg,
+		Filename: pos.Filename,
+		Line:     pos.Line,
+		Col:      pos.Col,
+	}
+	if e.state.options.collectFailures && e.state.tryDepth == 0 {
+		e.state.failures = append(e.state.failures, err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,29 @@
+package eval
+
+import (
+	"fmt"
+
+	"helmtk.dev/code/htkl/runtime"
+)
+
+// builtin is a function that needs access to the evaluator, such as
+// its scope, so it can't be registered as a plain runtime.Func
+type builtin func(e *evaluator, args []runtime.Value) (runtime.Value, error)
+
+var builtins = map[string]builtin{
+	"templateExists": builtinTemplateExists,
+}
+
+// builtinTemplateExists reports whether a template with the given name is defined
+func builtinTemplateExists(e *evaluator, args []runtime.Value) (runtime.Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("templateExists expects 1 argument, got %d", len(args))
+	}
+	name, ok := args[0].(*runtime.StringValue)
+	if !ok {
+		return nil, fmt.Errorf("templateExists expects a string, got %s", args[0].Type())
+	}
+
+	_, err := e.scope.GetTemplate(name.Value)
+	return runtime.NewBool(err == nil), nil
+}
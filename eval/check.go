@@ -0,0 +1,864 @@
+package eval
+
+import (
+	"fmt"
+
+	"helmtk.dev/code/htkl/parser"
+	"helmtk.dev/code/htkl/runtime"
+	"helmtk.dev/code/htkl/types"
+)
+
+// CheckOption configures Check
+type CheckOption func(*checker)
+
+// ValuesType describes the Values the document will be evaluated with,
+// replacing the type of any Values in scope
+func ValuesType(t *types.Type) CheckOption {
+	return func(c *checker) {
+		c.values = t
+	}
+}
+
+// Signatures declares the parameters and results of functions, so calls
+// to them are checked and their results have a known type
+func Signatures(sigs map[string]types.Signature) CheckOption {
+	return func(c *checker) {
+		for name, sig := range sigs {
+			c.sigs[name] = sig
+		}
+	}
+}
+
+// builtinSignatures are the signatures of the builtin functions
+var builtinSignatures = map[string]types.Signature{
+	"templateExists": {Params: []*types.Type{types.String}, Result: types.Bool},
+}
+
+// Check infers the types of a document's expressions without evaluating
+// it, and reports operations that would fail on the inferred types, like
+// adding an object to a number or indexing a string.
+//
+// Types are inferred from literals, variables, loops and the templates a
+// document includes. Variables in scope have the type of their value, and
+// functions return the result of their signature, if they have one. Where
+// a type can't be known, such as the fields of a template's context, any
+// value is assumed and nothing is reported. Errors inside a try
+// expression are recovered from at runtime, so they aren't reported.
+func Check(doc *parser.Document, scope *runtime.Scope, opts ...CheckOption) []Diagnostic {
+	c := &checker{
+		sigs:      make(map[string]types.Signature),
+		defs:      make(map[string]*parser.Definition),
+		own:       make(map[string]bool),
+		templates: make(map[string]*types.Type),
+		decls:     make(map[string]*parser.TypeDecl),
+		annotated: make(map[parser.TypeExpr]*types.Type),
+		scope:     scope,
+	}
+	for _, decl := range doc.Types {
+		c.decls[decl.Name] = decl
+	}
+	for name, sig := range builtinSignatures {
+		c.sigs[name] = sig
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	c.root = &checkScope{}
+	for _, name := range scope.Names() {
+		if val, err := scope.Get(name); err == nil {
+			c.root.declare(name, types.Of(val))
+		}
+	}
+	if c.values != nil {
+		c.root.declare("Values", c.values)
+	}
+
+	for _, tmpl := range scope.Templates() {
+		c.defs[tmpl.Name] = &parser.Definition{Name: tmpl.Name, Params: tmpl.Params, Body: tmpl.Body, Pos: tmpl.Pos}
+	}
+	for _, def := range doc.Definitions {
+		c.defs[def.Name] = def
+		c.own[def.Name] = true
+	}
+
+	for _, def := range doc.Definitions {
+		c.templateType(def.Name)
+	}
+	body, out := c.root.child(), &shape{kind: shapeDocuments}
+	for _, stmt := range doc.Body {
+		c.checkNode(stmt, body, out)
+	}
+
+	sortDiagnostics(c.diags)
+	return c.diags
+}
+
+type checker struct {
+	values    *types.Type
+	sigs      map[string]types.Signature
+	root      *checkScope
+	defs      map[string]*parser.Definition
+	own       map[string]bool        // Templates defined by the document, rather than the scope
+	templates map[string]*types.Type // Result types of templates, once inferred
+	quiet     int                    // Set while inferring library templates, which aren't reported on
+	decls     map[string]*parser.TypeDecl
+	annotated map[parser.TypeExpr]*types.Type // Type annotations converted so far
+	scope     *runtime.Scope
+	diags     []Diagnostic
+}
+
+// checkScope tracks the types of the variables declared in a block,
+// mirroring the scopes the evaluator creates
+type checkScope struct {
+	parent   *checkScope
+	vars     map[string]*types.Type
+	declared map[string]bool // Variables with a type annotation, which assignments must match
+	lenient  bool            // Errors are recovered from by a try expression
+}
+
+func (s *checkScope) child() *checkScope {
+	return &checkScope{parent: s, lenient: s.lenient}
+}
+
+func (s *checkScope) declare(name string, t *types.Type) {
+	if s.vars == nil {
+		s.vars = make(map[string]*types.Type)
+	}
+	s.vars[name] = t
+}
+
+// annotate declares a variable with a type annotation
+func (s *checkScope) annotate(name string, t *types.Type) {
+	s.declare(name, t)
+	if s.declared == nil {
+		s.declared = make(map[string]bool)
+	}
+	s.declared[name] = true
+}
+
+// annotation returns the annotated type of a variable, if it has one
+func (s *checkScope) annotation(name string) (*types.Type, bool) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if t, ok := scope.vars[name]; ok {
+			return t, scope.declared[name]
+		}
+	}
+	return nil, false
+}
+
+// lookup returns the type of a variable, or Any if it isn't declared
+func (s *checkScope) lookup(name string) *types.Type {
+	for scope := s; scope != nil; scope = scope.parent {
+		if t, ok := scope.vars[name]; ok {
+			return t
+		}
+	}
+	return types.Any
+}
+
+// update widens the type of a variable that's assigned a new value, in
+// the scope where it was declared
+func (s *checkScope) update(name string, t *types.Type) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if prev, ok := scope.vars[name]; ok {
+			scope.vars[name] = types.Join(prev, t)
+			return
+		}
+	}
+}
+
+type shapeKind int
+
+const (
+	shapeDocuments shapeKind = iota
+	shapeArray
+	shapeObject
+	shapeValue
+)
+
+// shape collects the types of what a block produces: the elements of an
+// array, the fields of an object or a single value
+type shape struct {
+	kind    shapeKind
+	val     *types.Type // Joined type of the values or elements
+	fields  map[string]*types.Type
+	unknown bool // Set when something the checker can't see is added, like an include
+}
+
+func (s *shape) add(t *types.Type) {
+	if s.val == nil {
+		s.val = t
+	} else {
+		s.val = types.Join(s.val, t)
+	}
+}
+
+func (s *shape) set(key string, t *types.Type) {
+	if s.fields == nil {
+		s.fields = make(map[string]*types.Type)
+	}
+	if prev, ok := s.fields[key]; ok {
+		t = types.Join(prev, t)
+	}
+	s.fields[key] = t
+}
+
+// typ returns the type of what was collected
+func (s *shape) typ() *types.Type {
+	switch s.kind {
+	case shapeArray:
+		if s.unknown {
+			return types.ArrayOf(nil)
+		}
+		return types.ArrayOf(s.val)
+	case shapeObject:
+		if s.unknown {
+			return types.ObjectOf(nil)
+		}
+		return types.ObjectOf(s.fields)
+	default:
+		if s.unknown || s.val == nil {
+			return types.Any
+		}
+		return s.val
+	}
+}
+
+func (c *checker) report(scope *checkScope, pos parser.Pos, format string, args ...any) {
+	if scope.lenient || c.quiet > 0 {
+		return
+	}
+	c.diags = append(c.diags, Diagnostic{Message: fmt.Sprintf(format, args...), Pos: pos})
+}
+
+// templateType returns the type of the value a template renders, checking
+// its body the first time it's asked for
+func (c *checker) templateType(name string) *types.Type {
+	if t, ok := c.templates[name]; ok {
+		return t
+	}
+	def, ok := c.defs[name]
+	if !ok {
+		return types.Any
+	}
+
+	// Recursive templates are assumed to render anything while their own
+	// body is checked
+	c.templates[name] = types.Any
+	// Templates from the scope were checked along with their own document
+	if !c.own[name] {
+		c.quiet++
+		defer func() { c.quiet-- }()
+	}
+
+	// Templates only see the scope the evaluation was started with, and
+	// the types of their parameters depend on the caller. Templates
+	// without parameters take their variables from the context, which may
+	// replace any name.
+	scope := c.root.child()
+	if def.Params == nil {
+		scope = &checkScope{}
+	}
+	for _, param := range def.Params {
+		var value *types.Type
+		if param.Default != nil {
+			value = c.exprType(param.Default, scope)
+		}
+		if param.Type == nil {
+			scope.declare(param.Name, types.Any)
+			continue
+		}
+		declared := c.typeOf(param.Type)
+		if value != nil && !conforms(value, declared) {
+			c.report(scope, param.Pos, "%s: expected %s, got %s", param.Name, declared, value)
+		}
+		scope.annotate(param.Name, declared)
+	}
+	out := &shape{kind: shapeValue}
+	c.checkList(def.Body, scope, out)
+
+	c.templates[name] = out.typ()
+	return c.templates[name]
+}
+
+func (c *checker) checkList(nodes []parser.Node, scope *checkScope, out *shape) {
+	for _, node := range nodes {
+		c.checkNode(node, scope, out)
+	}
+}
+
+func (c *checker) checkNode(node parser.Node, scope *checkScope, out *shape) {
+	switch n := node.(type) {
+	case *parser.KeyValueStatement:
+		out.set(n.Key, c.valueType(n.Value, scope))
+	case *parser.LetStatement:
+		value := c.valueType(n.Value, scope)
+		if n.Type == nil {
+			scope.declare(n.Name, value)
+			break
+		}
+		declared := c.typeOf(n.Type)
+		if !conforms(value, declared) {
+			c.report(scope, n.Pos, "%s: expected %s, got %s", n.Name, declared, value)
+		}
+		scope.annotate(n.Name, declared)
+	case *parser.AssignmentStatement:
+		c.checkAssignment(n, scope)
+	case *parser.IfStatement:
+		c.exprType(n.Condition, scope)
+		c.checkList(n.Body, scope, out)
+		c.checkList(n.Else, scope, out)
+	case *parser.ForStatement:
+		c.checkFor(n, scope, out)
+	case *parser.WithStatement:
+		context := c.exprType(n.Context, scope)
+		body := scope.child()
+		if n.VarName != "" {
+			body.declare(n.VarName, context)
+		}
+		c.checkList(n.Body, body, out)
+		c.checkList(n.Else, scope, out)
+	case *parser.MatchStatement:
+		subject := c.exprType(n.Subject, scope)
+		for _, cs := range n.Cases {
+			caseScope := scope.child()
+			for _, pattern := range cs.Patterns {
+				c.bindPattern(pattern, subject, caseScope)
+			}
+			if cs.Guard != nil {
+				c.exprType(cs.Guard, caseScope)
+			}
+			c.checkList(cs.Body, caseScope, out)
+		}
+	case *parser.SpreadStatement:
+		c.checkSpread(n, scope, out)
+	case *parser.AssertStatement:
+		c.exprType(n.Condition, scope)
+		if n.Message != nil {
+			c.exprType(n.Message, scope)
+		}
+	case *parser.FailStatement:
+		c.exprType(n.Message, scope)
+	case *parser.DocumentBlock:
+		if n.Name != nil {
+			c.exprType(n.Name, scope)
+		}
+		if n.Weight != nil {
+			c.exprType(n.Weight, scope)
+		}
+		c.checkList(n.Body, scope.child(), &shape{kind: shapeDocuments})
+	case *parser.IncludeExpression:
+		if out.kind == shapeValue {
+			out.add(c.includeType(n, scope))
+		} else {
+			c.includeType(n, scope)
+			out.unknown = true
+		}
+	case *parser.SuperExpression, *parser.YieldStatement:
+		if n, ok := n.(*parser.SuperExpression); ok && n.Context != nil {
+			c.exprType(n.Context, scope)
+		}
+		out.unknown = true
+	case parser.Expression:
+		out.add(c.exprType(n, scope))
+	}
+}
+
+// valueType returns the type of a value statement, which may be a block
+// producing a single value
+func (c *checker) valueType(val parser.ValueStatement, scope *checkScope) *types.Type {
+	switch n := val.(type) {
+	case *parser.IfStatement, *parser.WithStatement, *parser.MatchStatement:
+		out := &shape{kind: shapeValue}
+		c.checkNode(n, scope, out)
+		return out.typ()
+	case parser.Expression:
+		return c.exprType(n, scope)
+	default:
+		return types.Any
+	}
+}
+
+func (c *checker) checkAssignment(n *parser.AssignmentStatement, scope *checkScope) {
+	val := c.valueType(n.Value, scope)
+	switch n.Operator {
+	case "+=", "-=":
+		current := c.exprType(n.Target, scope)
+		op := "+"
+		if n.Operator == "-=" {
+			op = "-"
+		}
+		val = c.binaryType(n.Pos, op, current, val, scope)
+	case "||=":
+		val = types.Join(c.exprType(n.Target, scope), val)
+	}
+
+	// Assigning into an object or array leaves what's known about the
+	// fields or elements of the variable out of date
+	root := n.Target
+	for {
+		switch t := root.(type) {
+		case *parser.MemberExpression:
+			root, val = t.Object, nil
+			continue
+		case *parser.IndexExpression:
+			root, val = t.Object, nil
+			continue
+		}
+		break
+	}
+	id, ok := root.(*parser.Identifier)
+	if !ok {
+		return
+	}
+
+	// Annotated variables keep their type, and must be assigned values of it
+	if declared, ok := scope.annotation(id.Name); ok {
+		if val != nil && !conforms(val, declared) {
+			c.report(scope, n.Pos, "%s: expected %s, got %s", id.Name, declared, val)
+		}
+		return
+	}
+	if val == nil {
+		val = widen(scope.lookup(id.Name))
+	}
+	scope.update(id.Name, val)
+}
+
+// widen forgets the fields and elements of a type, keeping only its kind.
+// Assigning a member of null creates an object.
+func widen(t *types.Type) *types.Type {
+	switch t.Kind {
+	case types.KindArray:
+		return types.ArrayOf(nil)
+	case types.KindObject, types.KindNull:
+		return types.ObjectOf(nil)
+	default:
+		return types.Any
+	}
+}
+
+func (c *checker) checkFor(n *parser.ForStatement, scope *checkScope, out *shape) {
+	iterable := c.exprType(n.Iterable, scope)
+
+	key, value := types.Any, types.Any
+	switch iterable.Kind {
+	case types.KindArray:
+		key, value = types.Number, iterable.Element()
+	case types.KindObject:
+		key = types.String
+	case types.KindString:
+		key, value = types.Number, types.String
+	case types.KindNumber:
+		key, value = types.Number, types.Number
+	case types.KindBool:
+		c.report(scope, n.Iterable.GetPos(), "cannot iterate over %s", iterable)
+	}
+
+	loop := scope.child()
+	if n.KeyVar != "" {
+		loop.declare(n.KeyVar, key)
+	}
+	loop.declare(n.ValueVar, value)
+	loop.declare("loop", types.ObjectOf(map[string]*types.Type{
+		"index":  types.Number,
+		"first":  types.Bool,
+		"last":   types.Bool,
+		"length": types.Number,
+	}))
+
+	if n.Sort != nil && n.Sort.By != nil {
+		sort := loop.child()
+		sort.declare("key", key)
+		sort.declare("value", value)
+		c.exprType(n.Sort.By, sort)
+	}
+	if n.Filter != nil {
+		c.exprType(n.Filter, loop)
+	}
+	c.checkList(n.Body, loop, out)
+	c.checkList(n.Else, scope, out)
+}
+
+func (c *checker) checkSpread(n *parser.SpreadStatement, scope *checkScope, out *shape) {
+	t := c.valueType(n.Operand, scope)
+	if t.IsAny() {
+		out.unknown = true
+		return
+	}
+
+	switch out.kind {
+	case shapeArray:
+		if t.Kind != types.KindArray {
+			c.report(scope, n.Pos, "cannot spread %s into array", t)
+			return
+		}
+		out.add(t.Element())
+	case shapeObject:
+		if t.Kind != types.KindObject {
+			c.report(scope, n.Pos, "cannot spread %s into object", t)
+			return
+		}
+		// The operand may have more fields than are known, which replace
+		// the ones set before
+		out.fields = make(map[string]*types.Type, len(t.Fields))
+		for name, field := range t.Fields {
+			out.fields[name] = field
+		}
+	}
+}
+
+// bindPattern declares the variables a match pattern binds, with the type
+// of the part of the subject they match
+func (c *checker) bindPattern(pattern parser.Pattern, subject *types.Type, scope *checkScope) {
+	switch p := pattern.(type) {
+	case *parser.LiteralPattern:
+		c.exprType(p.Value, scope)
+	case *parser.BindingPattern:
+		if !p.IsWildcard() {
+			scope.declare(p.Name, subject)
+		}
+	case *parser.ObjectPattern:
+		for _, field := range p.Fields {
+			c.bindPattern(field.Pattern, subject.Field(field.Key), scope)
+		}
+	}
+}
+
+// exprType infers the type of an expression, reporting the operations in
+// it that can't succeed
+func (c *checker) exprType(expr parser.Expression, scope *checkScope) *types.Type {
+	switch n := expr.(type) {
+	case *parser.StringLiteral:
+		return types.String
+	case *parser.NumberLiteral:
+		return types.Number
+	case *parser.BooleanLiteral:
+		return types.Bool
+	case *parser.NullLiteral:
+		return types.Null
+	case *parser.InterpolatedString:
+		for _, part := range n.Parts {
+			if t := c.exprType(part, scope); isComposite(t) {
+				c.report(scope, part.GetPos(), "cannot convert %s to string", t.Kind)
+			}
+		}
+		return types.String
+	case *parser.Identifier:
+		return scope.lookup(n.Name)
+	case *parser.CurrentContext:
+		return types.ObjectOf(nil)
+	case *parser.MemberExpression:
+		obj := c.exprType(n.Object, scope)
+		switch obj.Kind {
+		case types.KindAny:
+			return types.Any
+		case types.KindNull:
+			return types.Null
+		case types.KindObject:
+			return obj.Field(n.Member)
+		}
+		c.report(scope, n.Pos, "cannot access member of %s", obj.Kind)
+		return types.Any
+	case *parser.IndexExpression:
+		return c.indexType(n, scope)
+	case *parser.BinaryOp:
+		left := c.exprType(n.Left, scope)
+		if n.Operator == "|" {
+			return c.pipeType(n, left, scope)
+		}
+		right := c.exprType(n.Right, scope)
+		return c.binaryType(n.Pos, n.Operator, left, right, scope)
+	case *parser.UnaryOp:
+		operand := c.exprType(n.Operand, scope)
+		if n.Operator == "-" {
+			if isComposite(operand) {
+				c.report(scope, n.Pos, "cannot negate %s", operand.Kind)
+			}
+			return types.Number
+		}
+		return types.Bool
+	case *parser.CallExpression:
+		args := make([]*types.Type, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = c.exprType(arg, scope)
+		}
+		if fn, ok := n.Function.(*parser.Identifier); ok {
+			return c.callType(n.Pos, fn.Name, args, scope)
+		}
+		return types.Any
+	case *parser.Array:
+		out := &shape{kind: shapeArray}
+		c.checkList(n.Body, scope, out)
+		return out.typ()
+	case *parser.Object:
+		// Object bodies are evaluated in the enclosing scope
+		out := &shape{kind: shapeObject}
+		c.checkList(n.Body, scope, out)
+		return out.typ()
+	case *parser.IncludeExpression:
+		return c.includeType(n, scope)
+	case *parser.SuperExpression:
+		if n.Context != nil {
+			c.exprType(n.Context, scope)
+		}
+		return types.Any
+	case *parser.IsExpression:
+		c.exprType(n.Value, scope)
+		return types.Bool
+	case *parser.TryExpression:
+		body := scope.child()
+		body.lenient = true
+		t := c.exprType(n.Body, body)
+
+		fallback := scope.child()
+		if n.ErrorVar != "" {
+			fallback.declare(n.ErrorVar, types.ObjectOf(map[string]*types.Type{
+				"message":  types.String,
+				"filename": types.String,
+				"line":     types.Number,
+				"col":      types.Number,
+			}))
+		}
+		return types.Join(t, c.exprType(n.Fallback, fallback))
+	default:
+		return types.Any
+	}
+}
+
+func (c *checker) indexType(n *parser.IndexExpression, scope *checkScope) *types.Type {
+	obj := c.exprType(n.Object, scope)
+	index := c.exprType(n.Index, scope)
+	if obj.IsAny() || index.IsAny() {
+		if obj.Kind == types.KindArray {
+			return obj.Element()
+		}
+		return types.Any
+	}
+
+	switch obj.Kind {
+	case types.KindArray:
+		if index.Kind == types.KindNumber {
+			return obj.Element()
+		}
+	case types.KindObject:
+		if !isComposite(index) {
+			if key, ok := n.Index.(*parser.StringLiteral); ok {
+				return obj.Field(key.Value)
+			}
+			return types.Any
+		}
+	}
+	c.report(scope, n.Pos, "cannot index %s with %s", obj.Kind, index.Kind)
+	return types.Any
+}
+
+// binaryType checks the operands of a binary operator and returns the
+// type of its result. Strings are converted to numbers when they're
+// used in arithmetic, so only arrays and objects are certain to fail.
+func (c *checker) binaryType(pos parser.Pos, op string, left, right *types.Type, scope *checkScope) *types.Type {
+	composite := isComposite(left) || isComposite(right)
+	switch op {
+	case "+":
+		if composite {
+			c.report(scope, pos, "cannot add %s and %s", left.Kind, right.Kind)
+			return types.Any
+		}
+		if left.Kind == types.KindString || right.Kind == types.KindString {
+			return types.String
+		}
+		if left.IsAny() || right.IsAny() {
+			return types.Any
+		}
+		return types.Number
+	case "-", "*", "/":
+		if composite {
+			c.report(scope, pos, "cannot %s %s", arithmetic[op], operands(op, left, right))
+		}
+		return types.Number
+	case "<", "<=", ">", ">=":
+		if composite {
+			c.report(scope, pos, "cannot compare %s and %s", left.Kind, right.Kind)
+		}
+		return types.Bool
+	default:
+		return types.Bool
+	}
+}
+
+// arithmetic names the operations of arithmetic operators in messages
+var arithmetic = map[string]string{
+	"-": "subtract",
+	"*": "multiply",
+	"/": "divide",
+}
+
+// operands describes the operands of an arithmetic operator in the order
+// its message puts them in, matching the evaluator's errors
+func operands(op string, left, right *types.Type) string {
+	switch op {
+	case "-":
+		return fmt.Sprintf("%s from %s", right.Kind, left.Kind)
+	case "/":
+		return fmt.Sprintf("%s by %s", left.Kind, right.Kind)
+	default:
+		return fmt.Sprintf("%s and %s", left.Kind, right.Kind)
+	}
+}
+
+// pipeType checks a pipe, which passes the left side as the last argument
+// of the function on the right
+func (c *checker) pipeType(n *parser.BinaryOp, left *types.Type, scope *checkScope) *types.Type {
+	switch right := n.Right.(type) {
+	case *parser.Identifier:
+		return c.callType(right.Pos, right.Name, []*types.Type{left}, scope)
+	case *parser.CallExpression:
+		var args []*types.Type
+		for _, arg := range right.Args {
+			args = append(args, c.exprType(arg, scope))
+		}
+		if fn, ok := right.Function.(*parser.Identifier); ok {
+			return c.callType(fn.Pos, fn.Name, append(args, left), scope)
+		}
+	}
+	return types.Any
+}
+
+// callType checks the arguments of a call against the function's
+// signature and returns its result type
+func (c *checker) callType(pos parser.Pos, name string, args []*types.Type, scope *checkScope) *types.Type {
+	sig, ok := c.sigs[name]
+	if !ok {
+		return types.Any
+	}
+
+	params := sig.Params
+	switch {
+	case sig.Variadic && len(args) < len(params)-1:
+		c.report(scope, pos, "%s expects at least %d arguments, got %d", name, len(params)-1, len(args))
+	case !sig.Variadic && len(args) != len(params):
+		c.report(scope, pos, "%s expects %d arguments, got %d", name, len(params), len(args))
+	default:
+		for i, arg := range args {
+			param := params[min(i, len(params)-1)]
+			if !assignable(arg, param) {
+				c.report(scope, pos, "argument %d of %s must be %s, got %s", i+1, name, param, arg)
+			}
+		}
+	}
+
+	if sig.Result == nil {
+		return types.Any
+	}
+	return sig.Result
+}
+
+// assignable reports whether a value of type t may be passed where param
+// is expected. Null is accepted anywhere, as missing values are null.
+func assignable(t, param *types.Type) bool {
+	if t.IsAny() || param.IsAny() || t.Kind == types.KindNull {
+		return true
+	}
+	return t.Kind == param.Kind
+}
+
+// conforms reports whether values of an inferred type may match a declared
+// type. Only kinds are compared, through union members, array elements and
+// the fields both types know about, since literals and whole numbers can't
+// be told apart from the inferred type alone.
+func conforms(t, declared *types.Type) bool {
+	if declared.Union != nil {
+		for _, member := range declared.Union {
+			if conforms(t, member) {
+				return true
+			}
+		}
+		return false
+	}
+	if !assignable(t, declared) {
+		return false
+	}
+	switch t.Kind {
+	case types.KindArray:
+		return t.Elem == nil || declared.Elem == nil || conforms(t.Elem, declared.Elem)
+	case types.KindObject:
+		for name, field := range declared.Fields {
+			if known, ok := t.Fields[name]; ok && !conforms(known, field) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// includeType checks an include's context and returns the type of the
+// value the template renders
+func (c *checker) includeType(n *parser.IncludeExpression, scope *checkScope) *types.Type {
+	if n.NameExpr != nil {
+		if t := c.exprType(n.NameExpr, scope); !t.IsAny() && t.Kind != types.KindString {
+			c.report(scope, n.NameExpr.GetPos(), "template name must be a string, got %s", t.Kind)
+		}
+	}
+	if n.Context != nil {
+		t := c.exprType(n.Context, scope)
+		if !t.IsAny() && t.Kind != types.KindObject {
+			c.report(scope, n.Context.GetPos(), "template context must be an object, got %s", t.Kind)
+		}
+		if def := c.defs[n.Name]; def != nil && n.NameExpr == nil {
+			c.checkArgs(n, def, t, scope)
+		}
+	}
+
+	// Content blocks are rendered in the including scope, into the
+	// template's output
+	discard := &shape{kind: shapeValue}
+	c.checkList(n.Block, scope.child(), discard)
+	for _, slot := range n.Slots {
+		c.checkList(slot.Body, scope.child(), discard)
+	}
+
+	if n.NameExpr != nil {
+		return types.Any
+	}
+	return c.templateType(n.Name)
+}
+
+// checkArgs checks the fields of an include's context against the types
+// of the template's parameters
+func (c *checker) checkArgs(n *parser.IncludeExpression, def *parser.Definition, ctx *types.Type, scope *checkScope) {
+	for _, param := range def.Params {
+		arg, ok := ctx.Fields[param.Name]
+		if !ok || param.Type == nil {
+			continue
+		}
+		if declared := c.typeOf(param.Type); !conforms(arg, declared) {
+			c.report(scope, n.Context.GetPos(), "include %q: %s: expected %s, got %s", n.Name, param.Name, declared, arg)
+		}
+	}
+}
+
+// typeOf converts a type annotation into a type. Types that can't be
+// converted are reported by Resolve, and allow any value here.
+func (c *checker) typeOf(expr parser.TypeExpr) *types.Type {
+	if t, ok := c.annotated[expr]; ok {
+		return t
+	}
+	t, err := types.FromExpr(expr, func(name string) (*parser.TypeDecl, bool) {
+		if decl, ok := c.decls[name]; ok {
+			return decl, true
+		}
+		return c.scope.GetType(name)
+	})
+	if err != nil {
+		t = types.Any
+	}
+	c.annotated[expr] = t
+	return t
+}
+
+// isComposite reports whether a type is an array or object, which can't
+// be converted to a string or number
+func isComposite(t *types.Type) bool {
+	return t.Kind == types.KindArray || t.Kind == types.KindObject
+}
@@ -0,0 +1,93 @@
+package eval
+
+import (
+	"cmp"
+	"slices"
+
+	"helmtk.dev/code/htkl/parser"
+	"helmtk.dev/code/htkl/runtime"
+)
+
+// Program is a document prepared once to be evaluated many times, with
+// different values. Compiling works on a copy of the document: constant
+// expressions are folded into literals, variables are resolved to slots,
+// and the functions and templates it can call are bound up front, so runs
+// don't look them up by name.
+//
+// A Program is safe for concurrent use by multiple goroutines.
+type Program struct {
+	body      []parser.Statement
+	frame     *parser.Frame // Variables of the top-level body
+	env       *runtime.Scope
+	funcs     map[string]runtime.Func
+	templates map[string]*runtime.Template
+	opts      []Option
+}
+
+// Compile prepares a document for repeated evaluation. The globals,
+// functions and templates of env are visible to every run, and env must
+// not be changed while the program is in use. It may be nil. The document
+// itself isn't changed.
+func Compile(doc *parser.Document, env *runtime.Scope, opts ...Option) (*Program, error) {
+	if env == nil {
+		env = runtime.NewScope(nil)
+	}
+
+	doc = parser.CopyDocument(doc)
+	foldConstants(doc)
+	assignSlots(doc)
+
+	p := &Program{
+		body:  doc.Body,
+		frame: doc.Frame,
+		env:   env.Isolated(),
+		opts:  opts,
+	}
+	if err := defineTypes(p.env, doc.Types); err != nil {
+		return nil, err
+	}
+	if err := defineTemplates(p.env, doc.Definitions); err != nil {
+		return nil, err
+	}
+
+	p.funcs = make(map[string]runtime.Func)
+	for _, name := range p.env.Env().FunctionNames() {
+		p.funcs[name], _ = p.env.GetFunction(name)
+	}
+	p.templates = make(map[string]*runtime.Template)
+	for _, tmpl := range p.env.Templates() {
+		p.templates[tmpl.Name] = tmpl
+	}
+	return p, nil
+}
+
+// Run evaluates the program with values bound to the Values global, and
+// returns its documents ordered by weight like EvalDocuments. Options
+// given here apply to this run only, after those the program was compiled
+// with (e.g., Context).
+func (p *Program) Run(values runtime.Value, opts ...Option) ([]*Document, error) {
+	var docs []*Document
+	err := p.RunFunc(values, func(d *Document) error {
+		docs = append(docs, d)
+		return nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(docs, func(a, b *Document) int {
+		return cmp.Compare(a.Weight, b.Weight)
+	})
+	return docs, nil
+}
+
+// RunFunc evaluates the program with values bound to the Values global,
+// calling fn with each document as soon as it's complete, like
+// EvalDocumentsFunc. Options apply to this run only, like for Run.
+func (p *Program) RunFunc(values runtime.Value, fn func(*Document) error, opts ...Option) error {
+	env := p.env.Isolated()
+	if values != nil {
+		env.SetGlobal("Values", values)
+	}
+	return evalBody(p.body, env, p, fn, slices.Concat(p.opts, opts))
+}
@@ -0,0 +1,134 @@
+package eval
+
+import (
+	"fmt"
+	"strings"
+
+	"helmtk.dev/code/htkl/parser"
+	"helmtk.dev/code/htkl/runtime"
+)
+
+// checkIncludeCycles reports templates that include themselves on every
+// path, directly or through other templates. Such templates can never
+// finish rendering, so they are rejected before evaluation starts.
+// Includes behind a condition, a loop or a computed name are left to the
+// include depth limit.
+func checkIncludeCycles(scope *runtime.Scope, defs []*parser.Definition) error {
+	for _, def := range defs {
+		tmpl, err := scope.GetTemplate(def.Name)
+		if err != nil {
+			continue
+		}
+		if path := findIncludeCycle(scope, tmpl, []string{tmpl.Name}, map[*runtime.Template]bool{}); path != nil {
+			return errorf(def.Pos, "template %q always includes itself: %s", def.Name, strings.Join(path, " -> "))
+		}
+	}
+	return nil
+}
+
+// findIncludeCycle follows the unconditional includes of tmpl, returning
+// the include path back to the first template in path, if there is one
+func findIncludeCycle(scope *runtime.Scope, tmpl *runtime.Template, path []string, seen map[*runtime.Template]bool) []string {
+	if seen[tmpl] {
+		return nil
+	}
+	seen[tmpl] = true
+
+	for _, name := range unconditionalIncludes(tmpl.Body) {
+		if name == path[0] {
+			return append(path, name)
+		}
+		next, err := scope.GetTemplate(name)
+		if err != nil {
+			continue
+		}
+		if cycle := findIncludeCycle(scope, next, append(path, name), seen); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// unconditionalIncludes lists the literal template names that are always
+// included when nodes are evaluated
+func unconditionalIncludes(nodes []parser.Node) []string {
+	var names []string
+
+	var visit func(node parser.Node)
+	visit = func(node parser.Node) {
+		switch n := node.(type) {
+		case *parser.IncludeExpression:
+			if n.NameExpr == nil {
+				names = append(names, n.Name)
+			} else {
+				visit(n.NameExpr)
+			}
+			if n.Context != nil {
+				visit(n.Context)
+			}
+		case *parser.KeyValueStatement:
+			visit(n.Value)
+		case *parser.LetStatement:
+			visit(n.Value)
+		case *parser.AssignmentStatement:
+			visit(n.Value)
+		case *parser.SpreadStatement:
+			visit(n.Operand)
+		case *parser.Object:
+			for _, item := range n.Body {
+				visit(item)
+			}
+		case *parser.Array:
+			for _, item := range n.Body {
+				visit(item)
+			}
+		case *parser.InterpolatedString:
+			for _, part := range n.Parts {
+				visit(part)
+			}
+		case *parser.BinaryOp:
+			visit(n.Left)
+			// The right side of && and || short-circuits
+			if n.Operator != "&&" && n.Operator != "||" {
+				visit(n.Right)
+			}
+		case *parser.UnaryOp:
+			visit(n.Operand)
+		case *parser.MemberExpression:
+			visit(n.Object)
+		case *parser.IndexExpression:
+			visit(n.Object)
+			visit(n.Index)
+		case *parser.CallExpression:
+			for _, arg := range n.Args {
+				visit(arg)
+			}
+
+		// Only the parts of control flow that always run
+		case *parser.IfStatement:
+			visit(n.Condition)
+		case *parser.ForStatement:
+			visit(n.Iterable)
+		case *parser.WithStatement:
+			visit(n.Context)
+		case *parser.MatchStatement:
+			visit(n.Subject)
+		}
+	}
+
+	for _, node := range nodes {
+		visit(node)
+	}
+	return names
+}
+
+// includeCycle describes the include trail that led back to name, for
+// errors when the include depth limit is reached
+func includeCycle(trail []string, name string) string {
+	for i := len(trail) - 1; i >= 0; i-- {
+		if trail[i] == name {
+			return fmt.Sprintf("include cycle: %s -> %s", strings.Join(trail[i:], " -> "), name)
+		}
+	}
+	return fmt.Sprintf("include trail: %s -> %s", strings.Join(trail, " -> "), name)
+}
@@ -0,0 +1,97 @@
+package eval
+
+import (
+	"helmtk.dev/code/htkl/parser"
+	"helmtk.dev/code/htkl/runtime"
+)
+
+// Document is a single root-level document produced by evaluation, along
+// with its metadata
+type Document struct {
+	Value    runtime.Value
+	Filename string // Output filename, set by a document block
+	Source   string // Source file the document was produced from
+	Weight   int    // Ordering weight, documents with lower weights come first
+}
+
+// evalDocumentBlock evaluates an explicit document block into a new
+// document. Loose keys that follow it start another document.
+func (e *evaluator) evalDocumentBlock(n *parser.DocumentBlock) error {
+	docColl, ok := e.coll.(*documentCollector)
+	if !ok {
+		return errorf(n.Pos, "document blocks are only allowed at the top level")
+	}
+
+	var filename string
+	if n.Name != nil {
+		val, err := e.evalExpression(n.Name)
+		if err != nil {
+			return err
+		}
+		str, ok := val.(*runtime.StringValue)
+		if !ok {
+			return errorf(n.Name.GetPos(), "document name must be a string, got %s", val.Type())
+		}
+		filename = str.Value
+	}
+
+	var weight int
+	if n.Weight != nil {
+		val, err := e.evalExpression(n.Weight)
+		if err != nil {
+			return err
+		}
+		num, ok := val.(*runtime.NumberValue)
+		if !ok || num.Value != float64(int(num.Value)) {
+			return errorf(n.Weight.GetPos(), "document weight must be an integer, got %s", val)
+		}
+		weight = int(num.Value)
+	}
+
+	// The body is evaluated like the top level of a file, and must
+	// produce exactly one document
+	var values []runtime.Value
+	body := &documentCollector{emit: func(d *Document) error {
+		values = append(values, d.Value)
+		return nil
+	}}
+	sub := &evaluator{scope: runtime.NewFrame(e.scope, n.Frame), coll: body, state: e.state}
+	for _, node := range n.Body {
+		if err := sub.collectNode(node); err != nil {
+			return err
+		}
+	}
+	if err := body.flush(); err != nil {
+		return err
+	}
+
+	var val runtime.Value
+	switch len(values) {
+	case 0:
+		val = runtime.NewObject()
+	case 1:
+		val = values[0]
+	default:
+		return errorf(n.Pos, "document block must produce a single value, got %d", len(values))
+	}
+
+	doc, err := docColl.addDocument(val, n.Pos)
+	if err != nil {
+		return err
+	}
+	doc.Filename = filename
+	doc.Weight = weight
+
+	// Nothing can be added to an explicit document, so it's complete
+	return docColl.flush()
+}
+
+// evalDocumentSeparator ends the current document, so that loose keys
+// after a "---" separator start a new one
+func (e *evaluator) evalDocumentSeparator(n *parser.DocumentSeparator) error {
+	docColl, ok := e.coll.(*documentCollector)
+	if !ok {
+		return errorf(n.Pos, "document separators are only allowed at the top level")
+	}
+	return docColl.flush()
+}
@@ -1,8 +1,10 @@
 package eval
 
 import (
+	"errors"
 	"fmt"
 	"path/filepath"
+	"strings"
 
 	"helmtk.dev/code/htkl/parser"
 )
@@ -54,3 +56,53 @@ func errorf(pos parser.Pos, format string, args ...interface{}) error {
 	// No position info available
 	return fmt.Errorf("%s", msg)
 }
+
+// AssertionErrors holds every failed assertion collected during evaluation
+// (see CollectFailures)
+type AssertionErrors []*EvalError
+
+func (e AssertionErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e AssertionErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// depthError reports that includes nested deeper than the configured limit
+type depthError struct {
+	error
+}
+
+// ErrStop can be returned by the callback of EvalDocumentsFunc to stop
+// evaluation early, without an error
+var ErrStop = errors.New("stop evaluation")
+
+// emitError is an error returned by the callback that documents are
+// passed to. It stops evaluation as a whole, so it's passed up as is.
+type emitError struct {
+	error
+}
+
+func (e *emitError) Unwrap() error { return e.error }
+
+// stopped turns the error of a callback that asked to stop into nil, and
+// unwraps other callback errors
+func stopped(err error) error {
+	var emitErr *emitError
+	if errors.As(err, &emitErr) {
+		if errors.Is(emitErr.error, ErrStop) {
+			return nil
+		}
+		return emitErr.error
+	}
+	return err
+}
@@ -1,25 +1,89 @@
 package eval
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
+	"iter"
+	"maps"
+	"slices"
+	"strings"
 
 	"helmtk.dev/code/htkl/parser"
 	"helmtk.dev/code/htkl/runtime"
+	"helmtk.dev/code/htkl/types"
 )
 
 // EvalDocument evaluates a complete helmtk document
 // Returns an ArrayValue containing all root-level documents
-func EvalDocument(doc *parser.Document, root *runtime.Scope) (runtime.Value, error) {
+func EvalDocument(doc *parser.Document, root *runtime.Scope, opts ...Option) (runtime.Value, error) {
+	docs, err := EvalDocuments(doc, root, opts...)
+	if err != nil {
+		return nil, err
+	}
 
-	docColl := &documentCollector{}
-	e := evaluator{
-		scope: root,
-		coll:  docColl,
+	arr := &runtime.ArrayValue{Elements: make([]runtime.Value, len(docs))}
+	for i, d := range docs {
+		arr.Elements[i] = d.Value
+	}
+	return arr, nil
+}
+
+// EvalDocuments evaluates a complete helmtk document like EvalDocument, but
+// returns each root-level document along with its metadata, ordered by weight
+func EvalDocuments(doc *parser.Document, root *runtime.Scope, opts ...Option) ([]*Document, error) {
+	var docs []*Document
+	err := EvalDocumentsFunc(doc, root, func(d *Document) error {
+		docs = append(docs, d)
+		return nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(docs, func(a, b *Document) int {
+		return cmp.Compare(a.Weight, b.Weight)
+	})
+	return docs, nil
+}
+
+// EvalDocumentsFunc evaluates a complete helmtk document, calling fn with
+// each root-level document as soon as it's complete, so documents don't
+// have to be held in memory until the end. Documents are passed in source
+// order, their weights are not applied.
+//
+// If fn returns ErrStop, evaluation stops early without an error. Any
+// other error stops evaluation and is returned.
+//
+// The root scope isn't changed by evaluation, so several documents can be
+// evaluated with the same root scope at once.
+func EvalDocumentsFunc(doc *parser.Document, root *runtime.Scope, fn func(*Document) error, opts ...Option) error {
+	// The document's templates and types are only visible to this evaluation
+	env := root.Isolated()
+	if err := defineTypes(env, doc.Types); err != nil {
+		return err
+	}
+	if err := defineTemplates(env, doc.Definitions); err != nil {
+		return err
+	}
+	return evalBody(doc.Body, env, nil, fn, opts)
+}
+
+// DefineTemplates registers the templates of a document's "define" blocks
+// in scope, along with its type declarations, so that documents evaluated
+// with it can include and refer to them. This is part of setting up a
+// scope, and mustn't happen while documents are being evaluated with it.
+func DefineTemplates(doc *parser.Document, scope *runtime.Scope) error {
+	if err := defineTypes(scope, doc.Types); err != nil {
+		return err
 	}
+	return defineTemplates(scope, doc.Definitions)
+}
 
-	// process all "define" blocks to register templates
-	for _, def := range doc.Definitions {
+// defineTemplates registers the templates of "define" blocks in scope, and
+// checks that none of them always includes itself
+func defineTemplates(scope *runtime.Scope, defs []*parser.Definition) error {
+	for _, def := range defs {
 		// Get filename from the body nodes
 		filename := ""
 		if len(def.Body) > 0 {
@@ -28,29 +92,108 @@ func EvalDocument(doc *parser.Document, root *runtime.Scope) (runtime.Value, err
 
 		// Create template with filename for better error messages
 		tmpl := runtime.NewTemplate(def.Name, def.Body, filename)
+		tmpl.Params = def.Params
+		tmpl.Frame = def.Frame
+		tmpl.Pos = def.Pos
+		tmpl.Override = def.Override
 
 		// Register it in the scope
-		e.scope.DefineTemplate(def.Name, tmpl)
+		if err := scope.DefineTemplate(def.Name, tmpl); err != nil {
+			return wraperr(def.Pos, err)
+		}
+	}
+
+	return checkIncludeCycles(scope, defs)
+}
+
+// evalBody evaluates the top-level statements of a document in a scope of
+// their own, passing each root-level document to fn once it's complete.
+// Prog is the compiled program the statements belong to, if any.
+func evalBody(body []parser.Statement, env *runtime.Scope, prog *Program, fn func(*Document) error, opts []Option) error {
+	state := &evalState{options: defaultOptions(), env: env, program: prog}
+	for _, opt := range opts {
+		opt(&state.options)
+	}
+
+	var frame *parser.Frame
+	if prog != nil {
+		frame = prog.frame
+	}
+
+	docColl := &documentCollector{emit: fn}
+	e := evaluator{
+		scope: env.IsolatedFrame(frame),
+		coll:  docColl,
+		state: state,
 	}
 
 	// evaluate all statements in the document context
-	for _, stmt := range doc.Body {
+	for _, stmt := range body {
 		if err := e.evalStatement(stmt); err != nil {
-			return nil, err
+			// Errors caused by cancellation may have been wrapped on the
+			// way up, so the context's own error is returned instead
+			if cerr := state.cancelled(); cerr != nil {
+				return cerr
+			}
+			return stopped(err)
 		}
 	}
 
-	// Return array of documents
-	arr := &runtime.ArrayValue{
-		Elements: docColl.documents,
+	// The last document is complete once everything has been evaluated
+	if err := docColl.flush(); err != nil {
+		return stopped(err)
+	}
+
+	if len(state.failures) > 0 {
+		return state.failures
+	}
+	return nil
+}
+
+// StreamDocuments returns an iterator over the root-level documents of a
+// helmtk document, evaluating them as the loop asks for them. Breaking out
+// of the loop stops evaluation. An evaluation error is yielded last.
+func StreamDocuments(doc *parser.Document, root *runtime.Scope, opts ...Option) iter.Seq2[runtime.Value, error] {
+	return func(yield func(runtime.Value, error) bool) {
+		err := EvalDocumentsFunc(doc, root, func(d *Document) error {
+			if !yield(d.Value, nil) {
+				return ErrStop
+			}
+			return nil
+		}, opts...)
+		if err != nil {
+			yield(nil, err)
+		}
 	}
-	return arr, nil
 }
 
 // evaluator evaluates AST nodes into runtime values
 type evaluator struct {
 	scope *runtime.Scope
 	coll  any
+	state *evalState
+}
+
+// evalState is shared by all evaluators of a single EvalDocument call
+type evalState struct {
+	options   options
+	failures  AssertionErrors
+	tryDepth  int                             // Number of enclosing try expressions
+	templates []*templateFrame                // Templates being rendered, innermost last
+	includes  []string                        // Names of the templates being rendered, for the depth limit
+	selves    []*selfObject                   // Object literals with self references, innermost last
+	env       *runtime.Scope                  // Scope the evaluation was started in, with its templates defined
+	program   *Program                        // Compiled program being run, nil for documents evaluated directly
+	types     map[parser.TypeExpr]*types.Type // Type annotations converted so far
+}
+
+// cancelled returns the error of the context given with the Context
+// option once it's done
+func (s *evalState) cancelled() error {
+	if s.options.ctx == nil {
+		return nil
+	}
+	return s.options.ctx.Err()
 }
 
 // Eval evaluates an AST value node and returns a runtime value
@@ -87,6 +230,14 @@ func (e *evaluator) evalExpression(node parser.Expression) (runtime.Value, error
 		return e.collectSingleValue(node, func(sub *evaluator) error {
 			return sub.evalIncludeStatement(n)
 		})
+	case *parser.SuperExpression:
+		return e.collectSingleValue(node, func(sub *evaluator) error {
+			return sub.evalSuperStatement(n)
+		})
+	case *parser.TryExpression:
+		return e.evalTryExpression(n)
+	case *parser.IsExpression:
+		return e.evalIsExpression(n)
 	case *parser.CurrentContext:
 		return e.evalCurrentContext(n)
 	default:
@@ -110,8 +261,26 @@ func (e *evaluator) evalStatement(node parser.Statement) error {
 		return e.evalSpreadStatement(n)
 	case *parser.IfStatement:
 		return e.evalIfStatement(n)
+	case *parser.MatchStatement:
+		return e.evalMatchStatement(n)
+	case *parser.AssertStatement:
+		return e.evalAssertStatement(n)
+	case *parser.FailStatement:
+		return e.evalFailStatement(n)
 	case *parser.IncludeExpression:
 		return e.evalIncludeStatement(n)
+	case *parser.SuperExpression:
+		return e.evalSuperStatement(n)
+	case *parser.YieldStatement:
+		return e.evalYieldStatement(n)
+	case *parser.DocumentBlock:
+		return e.evalDocumentBlock(n)
+	case *parser.DocumentSeparator:
+		return e.evalDocumentSeparator(n)
+	case *parser.BreakStatement:
+		return breakSignal
+	case *parser.ContinueStatement:
+		return continueSignal
 	case parser.Expression:
 		// Evaluate the expression
 		val, err := e.evalExpression(n)
@@ -121,10 +290,10 @@ func (e *evaluator) evalStatement(node parser.Statement) error {
 
 		// If we're in a document collector context, add it as a document
 		if docColl, ok := e.coll.(*documentCollector); ok {
-			docColl.addDocument(val)
+			_, err = docColl.addDocument(val, n.GetPos())
 		}
 
-		return nil
+		return err
 	default:
 		return errorf(n.GetPos(), "unsupported statement: %T", node)
 	}
@@ -134,18 +303,9 @@ func (e *evaluator) evalKeyValue(n *parser.KeyValueStatement) error {
 	// Check if we're in a document collector - if so, we need an implicit root object
 	if docColl, ok := e.coll.(*documentCollector); ok {
 		// Create an implicit root object if we encounter key:value at document level
-		// Check if the last document is an object we can add to
-		var obj *runtime.ObjectValue
-		if len(docColl.documents) > 0 {
-			if lastObj, ok := docColl.documents[len(docColl.documents)-1].(*runtime.ObjectValue); ok {
-				obj = lastObj
-			}
-		}
-
-		// If we don't have an object yet, create one
-		if obj == nil {
-			obj = &runtime.ObjectValue{}
-			docColl.addDocument(obj)
+		obj, err := docColl.openObject(n.Pos)
+		if err != nil {
+			return err
 		}
 
 		// Evaluate the value
@@ -154,7 +314,7 @@ func (e *evaluator) evalKeyValue(n *parser.KeyValueStatement) error {
 			return err
 		}
 
-		obj.Set(n.Key, val)
+		e.setField(obj, n, val)
 		return nil
 	}
 
@@ -169,10 +329,23 @@ func (e *evaluator) evalKeyValue(n *parser.KeyValueStatement) error {
 		return err
 	}
 
-	obj.Set(n.Key, val)
+	e.setField(obj, n, val)
 	return nil
 }
 
+// setField sets the field of a key-value statement, keeping hidden fields
+// out of the output
+func (e *evaluator) setField(obj *runtime.ObjectValue, n *parser.KeyValueStatement, val runtime.Value) {
+	if n.Hidden {
+		obj.SetHidden(n.Key, val)
+	} else {
+		obj.Set(n.Key, val)
+	}
+	if e.state.options.trackPositions {
+		obj.SetPos(n.Key, n.Pos)
+	}
+}
+
 func (e *evaluator) evalValueStatement(node parser.ValueStatement) (runtime.Value, error) {
 	switch it := node.(type) {
 	case *parser.IfStatement:
@@ -183,6 +356,8 @@ func (e *evaluator) evalValueStatement(node parser.ValueStatement) (runtime.Valu
 		return e.collectSingleValue(node, func(sub *evaluator) error {
 			return sub.evalWithStatement(it)
 		})
+	case *parser.MatchStatement:
+		return e.evalMatchValue(it)
 	case parser.Expression:
 		return e.evalExpression(it)
 	default:
@@ -193,7 +368,7 @@ func (e *evaluator) evalValueStatement(node parser.ValueStatement) (runtime.Valu
 // evalArray evaluates an array literal
 func (e *evaluator) evalArray(node *parser.Array) (runtime.Value, error) {
 	arr := &runtime.ArrayValue{}
-	sub := evaluator{scope: e.scope, coll: arr}
+	sub := evaluator{scope: e.scope, coll: arr, state: e.state}
 
 	for _, item := range node.Body {
 		if err := sub.collectNode(item); err != nil {
@@ -207,25 +382,43 @@ func (e *evaluator) evalArray(node *parser.Array) (runtime.Value, error) {
 // evalObject evaluates an object literal
 func (e *evaluator) evalObject(node *parser.Object) (runtime.Value, error) {
 	obj := &runtime.ObjectValue{}
-	sub := evaluator{scope: e.scope, coll: obj}
+	sub := evaluator{scope: e.scope, coll: obj, state: e.state}
+
+	// Statements that use self are evaluated lazily
+	if self := newSelfObject(obj, node.Body); self != nil {
+		selves := e.state.selves
+		e.state.selves = append(selves, self)
+		defer func() {
+			e.state.selves = selves
+		}()
+		if err := self.evalBody(node.Body, e); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	}
 
 	for _, item := range node.Body {
 
 		it, ok := item.(parser.Statement)
 		if !ok {
-			return nil, errorf(it.GetPos(), "unsupported node: %T", it)
+			return nil, errorf(item.GetPos(), "unsupported node: %T", item)
 		}
 
 		if err := sub.evalStatement(it); err != nil {
 			return nil, err
 		}
 	}
+
 	return obj, nil
 }
 
 func (e *evaluator) collectNode(node parser.Node) error {
 	switch it := node.(type) {
 
+	case *parser.IncludeExpression, *parser.SuperExpression:
+		// Templates collect into the current object or array directly
+		return e.evalStatement(it.(parser.Statement))
+
 	case parser.Expression:
 		val, err := e.evalExpression(it)
 		if err != nil {
@@ -239,7 +432,8 @@ func (e *evaluator) collectNode(node parser.Node) error {
 			return coll.setVal(val)
 		case *documentCollector:
 			// Root-level expressions (typically object literals) become documents
-			coll.addDocument(val)
+			_, err := coll.addDocument(val, it.GetPos())
+			return err
 		default:
 			return errorf(it.GetPos(), "unexpected value")
 		}
@@ -278,13 +472,54 @@ func (e *evaluator) evalIfStatement(n *parser.IfStatement) error {
 	return nil
 }
 
-// documentCollector collects root-level objects as separate documents
+// documentCollector collects root-level objects as separate documents,
+// passing each one on once it's complete
 type documentCollector struct {
-	documents []runtime.Value
+	last   *Document // The most recent document, loose keys may still be added to it
+	emit   func(*Document) error
+	sealed bool // Set by document blocks and separators, so loose keys start a new document
+}
+
+func (d *documentCollector) addDocument(val runtime.Value, pos parser.Pos) (*Document, error) {
+	// Starting a document completes the previous one
+	if err := d.flush(); err != nil {
+		return nil, err
+	}
+
+	d.last = &Document{Value: val, Source: pos.Filename}
+	d.sealed = false
+	return d.last, nil
 }
 
-func (d *documentCollector) addDocument(val runtime.Value) {
-	d.documents = append(d.documents, val)
+// flush passes on the last document without its hidden fields
+func (d *documentCollector) flush() error {
+	if d.last == nil {
+		return nil
+	}
+	doc := d.last
+	d.last = nil
+
+	doc.Value = runtime.StripHidden(doc.Value)
+	if err := d.emit(doc); err != nil {
+		return &emitError{err}
+	}
+	return nil
+}
+
+// openObject returns the object that loose root-level keys are added to,
+// which is the last document if it's an object that hasn't been sealed
+func (d *documentCollector) openObject(pos parser.Pos) (*runtime.ObjectValue, error) {
+	if d.last != nil && !d.sealed {
+		if obj, ok := d.last.Value.(*runtime.ObjectValue); ok {
+			return obj, nil
+		}
+	}
+
+	obj := &runtime.ObjectValue{}
+	if _, err := d.addDocument(obj, pos); err != nil {
+		return nil, err
+	}
+	return obj, nil
 }
 
 type singleValueCollector struct {
@@ -302,7 +537,7 @@ func (s *singleValueCollector) setVal(v runtime.Value) error {
 func (e *evaluator) collectSingleValue(n parser.Node, cb func(*evaluator) error) (runtime.Value, error) {
 
 	coll := &singleValueCollector{}
-	sub := &evaluator{scope: e.scope, coll: coll}
+	sub := &evaluator{scope: e.scope, coll: coll, state: e.state}
 
 	if err := cb(sub); err != nil {
 		return nil, err
@@ -322,13 +557,24 @@ func (e *evaluator) evalWithStatement(n *parser.WithStatement) error {
 		return err
 	}
 
+	// Like Helm, skip the body for empty contexts (unless "always" is given)
+	if !n.Always && !context.IsTruthy() {
+		for _, item := range n.Else {
+			if err := e.collectNode(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	// Create new scope for with body and bind the context to the variable
-	newScope := runtime.NewScope(e.scope)
+	newScope := runtime.NewFrame(e.scope, n.Frame)
 	newScope.Set(n.VarName, context)
 
 	sub := evaluator{
 		scope: newScope,
 		coll:  e.coll,
+		state: e.state,
 	}
 
 	// Emit all items from the body
@@ -365,7 +611,14 @@ func (e *evaluator) evalSpreadStatement(n *parser.SpreadStatement) error {
 			return errorf(n.Pos, "cannot spread %s into object", val.Type())
 		}
 		for k, v := range obj.Fields {
-			coll.Set(k, v)
+			if obj.IsHidden(k) {
+				coll.SetHidden(k, v)
+			} else {
+				coll.Set(k, v)
+			}
+			if pos, ok := obj.Pos(k); ok {
+				coll.SetPos(k, pos)
+			}
 		}
 
 	default:
@@ -382,62 +635,223 @@ func (e *evaluator) evalForStatement(n *parser.ForStatement) error {
 		return err
 	}
 
-	switch iter := iterable.(type) {
-	case *runtime.ArrayValue:
-		for i, elem := range iter.Elements {
-			key := runtime.NewNumber(float64(i))
-			err := e.evalForIteration(n, key, elem)
-			if err == breakSignal {
-				break
+	items, err := e.loopItems(n, iterable)
+	if err != nil {
+		return err
+	}
+
+	// Apply the filter clause before iterating, so loop metadata only
+	// counts the items that are kept
+	if n.Filter != nil {
+		kept := items[:0]
+		for _, item := range items {
+			if err := e.state.cancelled(); err != nil {
+				return err
 			}
+			filterScope := runtime.NewFrame(e.scope, n.Frame)
+			bindLoopVars(filterScope, n, item)
+			sub := &evaluator{scope: filterScope, coll: e.coll, state: e.state}
+
+			cond, err := sub.evalExpression(n.Filter)
 			if err != nil {
 				return err
 			}
+			if cond.IsTruthy() {
+				kept = append(kept, item)
+			}
+		}
+		items = kept
+	}
+
+	if n.Sort != nil {
+		if err := e.sortLoopItems(n, iterable, items); err != nil {
+			return err
+		}
+	}
+
+	// Nothing to iterate, take the else branch
+	if len(items) == 0 {
+		for _, item := range n.Else {
+			if err := e.collectNode(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i, item := range items {
+		if err := e.state.cancelled(); err != nil {
+			return err
+		}
+		err := e.evalForIteration(n, item, i, len(items))
+		if err == breakSignal {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loopItem is a single key/value pair produced by a for loop
+type loopItem struct {
+	key   runtime.Value
+	value runtime.Value
+}
+
+// loopItems lists the items a for loop iterates over. Arrays yield their
+// elements, objects their fields, strings their characters and a
+// non-negative integer n the numbers 0 to n-1.
+func (e *evaluator) loopItems(n *parser.ForStatement, iterable runtime.Value) ([]loopItem, error) {
+	var items []loopItem
+
+	switch iter := iterable.(type) {
+	case *runtime.ArrayValue:
+		for i, elem := range iter.Elements {
+			items = append(items, loopItem{runtime.NewNumber(float64(i)), elem})
 		}
 
 	case *runtime.ObjectValue:
 		for key, val := range iter.Fields {
-			key := runtime.NewString(key)
-			err := e.evalForIteration(n, key, val)
-			if err == breakSignal {
-				break
-			}
-			if err != nil {
-				return err
+			items = append(items, loopItem{runtime.NewString(key), val})
+		}
+
+	case *runtime.StringValue:
+		for i, r := range []rune(iter.Value) {
+			items = append(items, loopItem{runtime.NewNumber(float64(i)), runtime.NewString(string(r))})
+		}
+
+	case *runtime.NumberValue:
+		count := int(iter.Value)
+		if float64(count) != iter.Value || count < 0 {
+			return nil, errorf(n.Iterable.GetPos(), "cannot iterate over %s, expected a non-negative integer", iter)
+		}
+		for i := 0; i < count; i++ {
+			// Ranges can be large enough to take a while to list
+			if i%1024 == 0 {
+				if err := e.state.cancelled(); err != nil {
+					return nil, err
+				}
 			}
+			items = append(items, loopItem{runtime.NewNumber(float64(i)), runtime.NewNumber(float64(i))})
 		}
 
 	default:
-		return errorf(n.Iterable.GetPos(), "cannot iterate over %s", iterable.Type())
+		return nil, errorf(n.Iterable.GetPos(), "cannot iterate over %s", iterable.Type())
 	}
 
+	return items, nil
+}
+
+// sortLoopItems orders loop items by their sort clause. Without a sort
+// expression, object fields are sorted by key and the items of arrays,
+// strings and ranges by value. Object fields are first ordered by key, so
+// items with equal sort values keep a stable order.
+func (e *evaluator) sortLoopItems(n *parser.ForStatement, iterable runtime.Value, items []loopItem) error {
+	_, isObject := iterable.(*runtime.ObjectValue)
+	if isObject {
+		slices.SortStableFunc(items, func(a, b loopItem) int {
+			return strings.Compare(a.key.String(), b.key.String())
+		})
+	}
+
+	// Compute the sort value of each item up front, so the comparison
+	// only has to deal with comparison errors
+	keyed := make([]sortedItem, len(items))
+	for i, item := range items {
+		keyed[i] = sortedItem{item: item, by: item.key}
+		if n.Sort.By == nil {
+			if !isObject {
+				keyed[i].by = item.value
+			}
+			continue
+		}
+
+		sortScope := runtime.NewScope(e.scope)
+		sortScope.Set("key", item.key)
+		sortScope.Set("value", item.value)
+		bindLoopVars(sortScope, n, item)
+		sub := &evaluator{scope: sortScope, coll: e.coll, state: e.state}
+
+		val, err := sub.evalExpression(n.Sort.By)
+		if err != nil {
+			return err
+		}
+		keyed[i].by = val
+	}
+
+	var sortErr error
+	slices.SortStableFunc(keyed, func(a, b sortedItem) int {
+		c, err := runtime.Compare(a.by, b.by)
+		if err != nil && sortErr == nil {
+			sortErr = err
+		}
+		if n.Sort.Desc {
+			return -c
+		}
+		return c
+	})
+	if sortErr != nil {
+		return wraperr(n.Sort.Pos, sortErr)
+	}
+
+	for i := range keyed {
+		items[i] = keyed[i].item
+	}
 	return nil
 }
 
-var breakSignal = errors.New("break")
+// sortedItem pairs a loop item with the value it's sorted by
+type sortedItem struct {
+	item loopItem
+	by   runtime.Value
+}
+
+// loopMetadata builds the "loop" object bound in each iteration
+func loopMetadata(index, length int) *runtime.ObjectValue {
+	loop := runtime.NewObject()
+	loop.Set("index", runtime.NewNumber(float64(index)))
+	loop.Set("first", runtime.NewBool(index == 0))
+	loop.Set("last", runtime.NewBool(index == length-1))
+	loop.Set("length", runtime.NewNumber(float64(length)))
+	return loop
+}
+
+// bindLoopVars binds the loop's key and value variables in scope
+func bindLoopVars(scope *runtime.Scope, n *parser.ForStatement, item loopItem) {
+	if n.KeyVar != "" {
+		scope.Set(n.KeyVar, item.key)
+	}
+	scope.Set(n.ValueVar, item.value)
+}
+
+var (
+	breakSignal    = errors.New("break outside of a loop")
+	continueSignal = errors.New("continue outside of a loop")
+)
 
 // evalForIteration evaluates a single iteration of a for loop
-func (e *evaluator) evalForIteration(n *parser.ForStatement, key, value runtime.Value) error {
+func (e *evaluator) evalForIteration(n *parser.ForStatement, item loopItem, index, length int) error {
 	// Create new scope for loop variables
-	loopScope := runtime.NewScope(e.scope)
-	sub := &evaluator{scope: loopScope, coll: e.coll}
+	loopScope := runtime.NewFrame(e.scope, n.Frame)
+	sub := &evaluator{scope: loopScope, coll: e.coll, state: e.state}
 
-	// Bind loop variables
-	if n.KeyVar != "" {
-		loopScope.Set(n.KeyVar, key)
+	// Bind loop variables. Compiled loops that don't use the metadata
+	// leave it out of their frame.
+	if n.Frame == nil || n.Frame.Index("loop") >= 0 {
+		loopScope.Set("loop", loopMetadata(index, length))
 	}
-	loopScope.Set(n.ValueVar, value)
+	bindLoopVars(loopScope, n, item)
 
 	// Emit all items from the body
-	for _, item := range n.Body {
-		switch item.(type) {
-		case *parser.BreakStatement:
-			return breakSignal
-		case *parser.ContinueStatement:
-			break
+	for _, node := range n.Body {
+		err := sub.collectNode(node)
+		if err == continueSignal {
+			return nil
 		}
-
-		if err := sub.collectNode(item); err != nil {
+		if err != nil {
 			return err
 		}
 	}
@@ -447,6 +861,16 @@ func (e *evaluator) evalForIteration(n *parser.ForStatement, key, value runtime.
 
 // evalMemberExpression evaluates member access (e.g., obj.field)
 func (e *evaluator) evalMemberExpression(n *parser.MemberExpression) (runtime.Value, error) {
+	// Fields read through self are evaluated on demand, and are null
+	// when no object sets them
+	if e.isSelf(n.Object) {
+		val, ok, err := e.selfField(n.Member)
+		if err != nil || ok {
+			return val, err
+		}
+		return runtime.NewNull(), nil
+	}
+
 	// Evaluate the object
 	objVal, err := e.evalExpression(n.Object)
 	if err != nil {
@@ -478,6 +902,10 @@ func (e *evaluator) evalMemberExpression(n *parser.MemberExpression) (runtime.Va
 
 // evalIndexExpression evaluates array/object indexing (e.g., arr[0], obj["key"])
 func (e *evaluator) evalIndexExpression(n *parser.IndexExpression) (runtime.Value, error) {
+	if e.isSelf(n.Object) {
+		return e.evalSelfIndex(n)
+	}
+
 	// Evaluate the object/array
 	objVal, err := e.evalExpression(n.Object)
 	if err != nil {
@@ -524,6 +952,28 @@ func (e *evaluator) evalIndexExpression(n *parser.IndexExpression) (runtime.Valu
 	}
 }
 
+// evalSelfIndex evaluates a field read through self with an index (e.g.,
+// self["name"])
+func (e *evaluator) evalSelfIndex(n *parser.IndexExpression) (runtime.Value, error) {
+	indexVal, err := e.evalExpression(n.Index)
+	if err != nil {
+		return nil, err
+	}
+	key, err := runtime.ToString(indexVal)
+	if err != nil {
+		return nil, errorf(n.Pos, "object index must be a string")
+	}
+
+	val, ok, err := e.selfField(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errorf(n.Pos, "undefined field: %s", key)
+	}
+	return val, nil
+}
+
 // evalBinaryOp evaluates a binary operation
 func (e *evaluator) evalBinaryOp(n *parser.BinaryOp) (runtime.Value, error) {
 	// Handle pipe operator specially
@@ -622,10 +1072,17 @@ func (e *evaluator) evalPipe(n *parser.BinaryOp) (runtime.Value, error) {
 
 // callFunction is a helper for calling functions
 func (e *evaluator) callFunction(pos parser.Pos, name string, args []runtime.Value) (runtime.Value, error) {
-	// Look up the function in the registry
-	fn, ok := e.scope.GetFunction(name)
+	// Look up the function in the registry, falling back to the builtins
+	// that need access to the evaluator
+	fn, ok := e.function(name)
 	if !ok {
-		return nil, errorf(pos, "undefined function: %s", name)
+		builtin, ok := builtins[name]
+		if !ok {
+			return nil, errorf(pos, "undefined function: %s", name)
+		}
+		fn = func(args ...runtime.Value) (runtime.Value, error) {
+			return builtin(e, args)
+		}
 	}
 
 	// Call the function
@@ -636,6 +1093,27 @@ func (e *evaluator) callFunction(pos parser.Pos, name string, args []runtime.Val
 	return res, nil
 }
 
+// function looks up a function of the environment. Compiled programs have
+// theirs bound up front.
+func (e *evaluator) function(name string) (runtime.Func, bool) {
+	if p := e.state.program; p != nil {
+		fn, ok := p.funcs[name]
+		return fn, ok
+	}
+	return e.scope.GetFunction(name)
+}
+
+// template looks up a template of the environment. Compiled programs have
+// theirs bound up front.
+func (e *evaluator) template(name string) (*runtime.Template, error) {
+	if p := e.state.program; p != nil {
+		if tmpl, ok := p.templates[name]; ok {
+			return tmpl, nil
+		}
+	}
+	return e.scope.GetTemplate(name)
+}
+
 // evalUnaryOp evaluates a unary operation
 func (e *evaluator) evalUnaryOp(n *parser.UnaryOp) (runtime.Value, error) {
 	operand, err := e.evalExpression(n.Operand)
@@ -683,57 +1161,244 @@ func (e *evaluator) evalCallExpression(n *parser.CallExpression) (runtime.Value,
 }
 
 func (e *evaluator) evalIncludeStatement(n *parser.IncludeExpression) error {
+	name, err := e.templateName(n)
+	if err != nil {
+		return err
+	}
+
 	// Get the template
-	tmpl, err := e.scope.GetTemplate(n.Name)
+	tmpl, err := e.template(name)
 	if err != nil {
 		return errorf(n.Pos, "%s", err.Error())
 	}
 
-	// Create new scope for template evaluation
-	tmplScope := runtime.NewScope(nil)
-	tmplScope.Link(e.scope)
+	args, err := e.templateArgs(n.Context)
+	if err != nil {
+		return err
+	}
 
-	if n.Context != nil {
-		val, err := e.evalExpression(n.Context)
-		if err != nil {
-			return err
-		}
-		obj, ok := val.(*runtime.ObjectValue)
-		if !ok {
-			return errorf(n.Context.GetPos(), "template context must be an object")
-		}
-		for k, v := range obj.Fields {
-			tmplScope.Set(k, v)
+	return e.renderTemplate(n.Pos, tmpl, args, newTemplateFrame(n, tmpl, args, e.scope))
+}
+
+// templateArgs evaluates the context object passed to a template
+func (e *evaluator) templateArgs(ctx parser.Expression) (*runtime.ObjectValue, error) {
+	if ctx == nil {
+		return nil, nil
+	}
+
+	val, err := e.evalExpression(ctx)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := val.(*runtime.ObjectValue)
+	if !ok {
+		return nil, errorf(ctx.GetPos(), "template context must be an object")
+	}
+	return obj, nil
+}
+
+// templateName resolves the name of an included template, evaluating it
+// when it isn't a literal
+func (e *evaluator) templateName(n *parser.IncludeExpression) (string, error) {
+	if n.NameExpr == nil {
+		return n.Name, nil
+	}
+
+	val, err := e.evalExpression(n.NameExpr)
+	if err != nil {
+		return "", err
+	}
+	str, ok := val.(*runtime.StringValue)
+	if !ok {
+		return "", errorf(n.NameExpr.GetPos(), "template name must be a string, got %s", val.Type())
+	}
+	return str.Value, nil
+}
+
+// bindParams binds include arguments in the template scope. Templates
+// without a parameter list take every field of the context object, while
+// templates with one only accept their declared parameters.
+func (e *evaluator) bindParams(pos parser.Pos, tmpl *runtime.Template, args *runtime.ObjectValue) error {
+	if tmpl.Params == nil {
+		if args != nil {
+			for k, v := range args.Fields {
+				e.scope.Set(k, v)
+			}
 		}
+		return nil
 	}
 
-	tmplEval := &evaluator{
-		scope: tmplScope,
-		coll:  e.coll,
+	if args == nil {
+		args = runtime.NewObject()
+	}
+
+	declared := make(map[string]bool, len(tmpl.Params))
+	for _, param := range tmpl.Params {
+		declared[param.Name] = true
+	}
+	for _, name := range slices.Sorted(maps.Keys(args.Fields)) {
+		if !declared[name] {
+			return errorf(pos, "include %q: unknown parameter %q", tmpl.Name, name)
+		}
 	}
 
-	for _, node := range tmpl.Body {
-		if err := tmplEval.collectNode(node); err != nil {
-			return errorf(n.Pos, "include %q: %s", n.Name, err)
+	// Defaults are evaluated in order, so they can refer to earlier parameters
+	for _, param := range tmpl.Params {
+		val, ok := args.Fields[param.Name]
+		if !ok {
+			if param.Default == nil {
+				return errorf(pos, "include %q: missing required parameter %q", tmpl.Name, param.Name)
+			}
+			var err error
+			if val, err = e.evalExpression(param.Default); err != nil {
+				return errorf(pos, "include %q: default for parameter %q: %s", tmpl.Name, param.Name, err)
+			}
+		}
+		if param.Type != nil {
+			t, err := e.resolveType(param.Type)
+			if err != nil {
+				return err
+			}
+			if err := t.Validate(val, param.Name); err != nil {
+				return errorf(pos, "include %q: %s", tmpl.Name, err)
+			}
 		}
+		e.scope.Set(param.Name, val)
+		e.scope.Annotate(param.Name, param.Type)
 	}
 
 	return nil
 }
 
 func (e *evaluator) evalAssignmentStatement(n *parser.AssignmentStatement) error {
-	// Evaluate the value
-	val, err := e.evalValueStatement(n.Value)
-	if err != nil {
-		return err
-	}
+	var val runtime.Value
+	switch n.Operator {
+	case "=":
+		v, err := e.evalValueStatement(n.Value)
+		if err != nil {
+			return err
+		}
+		val = v
 
-	// Update the variable in the current scope
-	// Unlike let, assignment should update an existing variable
-	e.scope.Set(n.Name, val)
+	case "||=":
+		// Only evaluate the new value if the current one is falsy
+		current, err := e.evalExpression(n.Target)
+		if err != nil {
+			return err
+		}
+		if current.IsTruthy() {
+			return nil
+		}
+		if val, err = e.evalValueStatement(n.Value); err != nil {
+			return err
+		}
+
+	case "+=", "-=":
+		current, err := e.evalExpression(n.Target)
+		if err != nil {
+			return err
+		}
+		operand, err := e.evalValueStatement(n.Value)
+		if err != nil {
+			return err
+		}
+		if n.Operator == "+=" {
+			val, err = e.evalAdd(current, operand)
+		} else {
+			val, err = e.evalSub(current, operand)
+		}
+		if err != nil {
+			return wraperr(n.Pos, err)
+		}
+
+	default:
+		return errorf(n.Pos, "unknown assignment operator: %s", n.Operator)
+	}
 
 	// Assignment statements don't produce a value
-	return nil
+	return e.assign(n.Target, val)
+}
+
+// assign stores val at the target path. The variable at the root of the path
+// is updated in the scope where it was declared; objects and arrays along the
+// path are copied, so other references to them are not affected. Its new
+// value must still match the type it was declared with, if any.
+func (e *evaluator) assign(target parser.Expression, val runtime.Value) error {
+	switch t := target.(type) {
+	case *parser.Identifier:
+		if typ, ok := e.scope.Annotation(t.Name); ok {
+			checked, err := e.resolveType(typ)
+			if err != nil {
+				return err
+			}
+			if err := checked.Validate(val, t.Name); err != nil {
+				return errorf(t.Pos, "%s", err)
+			}
+		}
+		if err := e.scope.Assign(t.Name, val); err != nil {
+			return errorf(t.Pos, "%s", err)
+		}
+		return nil
+
+	case *parser.MemberExpression:
+		parent, err := e.evalExpression(t.Object)
+		if err != nil {
+			return err
+		}
+
+		var updated *runtime.ObjectValue
+		switch obj := parent.(type) {
+		case *runtime.ObjectValue:
+			updated = obj.Copy()
+		case *runtime.NullValue:
+			// Assigning into a missing object creates it
+			updated = runtime.NewObject()
+		default:
+			return errorf(t.Pos, "cannot assign member of %s", parent.Type())
+		}
+		updated.Set(t.Member, val)
+		return e.assign(t.Object, updated)
+
+	case *parser.IndexExpression:
+		parent, err := e.evalExpression(t.Object)
+		if err != nil {
+			return err
+		}
+		indexVal, err := e.evalExpression(t.Index)
+		if err != nil {
+			return err
+		}
+
+		switch obj := parent.(type) {
+		case *runtime.ArrayValue:
+			num, ok := indexVal.(*runtime.NumberValue)
+			if !ok {
+				return errorf(t.Pos, "array index must be a number, got %s", indexVal.Type())
+			}
+			idx := int(num.Value)
+			if idx < 0 || idx >= len(obj.Elements) {
+				return errorf(t.Pos, "array index out of bounds: %d", idx)
+			}
+			updated := obj.Copy()
+			updated.Elements[idx] = val
+			return e.assign(t.Object, updated)
+
+		case *runtime.ObjectValue:
+			key, err := runtime.ToString(indexVal)
+			if err != nil {
+				return errorf(t.Pos, "object index must be a string")
+			}
+			updated := obj.Copy()
+			updated.Set(key, val)
+			return e.assign(t.Object, updated)
+
+		default:
+			return errorf(t.Pos, "cannot index %s", parent.Type())
+		}
+
+	default:
+		return errorf(target.GetPos(), "invalid assignment target: %T", target)
+	}
 }
 
 func (e *evaluator) evalLetStatement(n *parser.LetStatement) error {
@@ -743,8 +1408,20 @@ func (e *evaluator) evalLetStatement(n *parser.LetStatement) error {
 		return err
 	}
 
-	// Bind it in the current scope
+	// Check it against the annotation, if any
+	if n.Type != nil {
+		t, err := e.resolveType(n.Type)
+		if err != nil {
+			return err
+		}
+		if err := t.Validate(val, n.Name); err != nil {
+			return errorf(n.Pos, "%s", err)
+		}
+	}
+
+	// Bind it in the current scope, with the type assignments must keep to
 	e.scope.Set(n.Name, val)
+	e.scope.Annotate(n.Name, n.Type)
 
 	// Let statements don't produce a value
 	return nil
@@ -752,7 +1429,7 @@ func (e *evaluator) evalLetStatement(n *parser.LetStatement) error {
 
 // evalInterpolatedString evaluates an interpolated string with ${} expressions
 func (e *evaluator) evalInterpolatedString(n *parser.InterpolatedString) (runtime.Value, error) {
-	var result string
+	var result strings.Builder
 	for _, part := range n.Parts {
 		val, err := e.evalExpression(part)
 		if err != nil {
@@ -762,13 +1439,30 @@ func (e *evaluator) evalInterpolatedString(n *parser.InterpolatedString) (runtim
 		if err != nil {
 			return nil, wraperr(n.Pos, err)
 		}
-		result += str
+		result.WriteString(str)
 	}
-	return runtime.NewString(result), nil
+	return runtime.NewString(result.String()), nil
 }
 
 // evalIdentifier looks up an identifier in the current scope
 func (e *evaluator) evalIdentifier(n *parser.Identifier) (runtime.Value, error) {
+	// Inside an object literal, self is the object being built
+	if n.Name == "self" {
+		if self := e.currentSelf(); self != nil {
+			obj, err := self.all()
+			if err != nil {
+				return nil, err
+			}
+			return obj, nil
+		}
+	}
+
+	if n.Binding != nil {
+		if val, ok := e.scope.Lookup(n.Binding); ok {
+			return val, nil
+		}
+	}
+
 	val, err := e.scope.Get(n.Name)
 	if err != nil {
 		return nil, errorf(n.Pos, "%s", err.Error())
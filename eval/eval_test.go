@@ -1,11 +1,19 @@
 package eval
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 
 	"helmtk.dev/code/htkl/parser"
 	"helmtk.dev/code/htkl/runtime"
+	"helmtk.dev/code/htkl/types"
 )
 
 func TestArithmetic(t *testing.T) {
@@ -63,6 +71,17 @@ func TestStringConcat(t *testing.T) {
 	}
 }
 
+func TestNestedInterpolation(t *testing.T) {
+	obj := evalToObject(t, `
+let name = "web"
+result: "${ {suffix: "}"}.suffix }-${ "svc-${name}" }"
+	`)
+
+	if got := getString(t, obj, "result"); got != "}-svc-web" {
+		t.Errorf("result: got %q, want %q", got, "}-svc-web")
+	}
+}
+
 func TestComparison(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -204,6 +223,83 @@ result: x
 	}
 }
 
+func TestAssignmentPaths(t *testing.T) {
+	obj := evalToObject(t, `
+let config = {server: {host: "localhost", port: 8080}, ports: [80, 443]}
+let original = config
+config.server.port = 9090
+config.ports[1] = 8443
+config["mode"] = "debug"
+config.tls.enabled = true
+port: config.server.port
+second: config.ports[1]
+mode: config.mode
+tls: config.tls.enabled
+originalPort: original.server.port
+	`)
+
+	want := map[string]string{
+		"port":         "9090",
+		"second":       "8443",
+		"mode":         "debug",
+		"tls":          "true",
+		"originalPort": "8080",
+	}
+	for key, w := range want {
+		if got := getString(t, obj, key); got != w {
+			t.Errorf("%s: got %q, want %q", key, got, w)
+		}
+	}
+}
+
+func TestCompoundAssignment(t *testing.T) {
+	obj := evalToObject(t, `
+let total = 0
+let names = ""
+let label = null
+let kept = "set"
+for i, x in [1, 2, 3] do
+	total += x
+	names += "n${x}"
+end
+total -= 1
+label ||= "default"
+kept ||= "unused"
+total: total
+names: names
+label: label
+kept: kept
+	`)
+
+	want := map[string]string{
+		"total": "5",
+		"names": "n1n2n3",
+		"label": "default",
+		"kept":  "set",
+	}
+	for key, w := range want {
+		if got := getString(t, obj, key); got != w {
+			t.Errorf("%s: got %q, want %q", key, got, w)
+		}
+	}
+}
+
+func TestAssignmentUpdatesDeclaringScope(t *testing.T) {
+	obj := evalToObject(t, `
+let x = 1
+let cfg = {name: "a"}
+result: with cfg as c do
+	x = 2
+	c.name
+end
+x: x
+	`)
+
+	if got := getString(t, obj, "x"); got != "2" {
+		t.Errorf("x: got %q, want %q", got, "2")
+	}
+}
+
 func TestForStatement(t *testing.T) {
 	obj := evalToObject(t, `
 let items = [1, 2, 3]
@@ -223,6 +319,404 @@ results: [for i, item in items do item * 2 end]
 	}
 }
 
+func TestForLoopMetadata(t *testing.T) {
+	obj := evalToObject(t, `
+let items = ["a", "b", "c"]
+joined: [for x in items do if loop.last do x else x + "," end end]
+indexes: [for x in items do loop.index end]
+length: [for x in items do loop.length end][0]
+first: [for x in items do if loop.first do x end end]
+	`)
+
+	joined := getArray(t, obj, "joined")
+	want := []string{"a,", "b,", "c"}
+	for i, w := range want {
+		if got := joined.Elements[i].String(); got != w {
+			t.Errorf("joined[%d]: got %q, want %q", i, got, w)
+		}
+	}
+	if got := getArray(t, obj, "indexes").String(); got != "[0, 1, 2]" {
+		t.Errorf("indexes: got %q, want %q", got, "[0, 1, 2]")
+	}
+	if got := getString(t, obj, "length"); got != "3" {
+		t.Errorf("length: got %q, want %q", got, "3")
+	}
+	if got := getArray(t, obj, "first").String(); got != "[a]" {
+		t.Errorf("first: got %q, want %q", got, "[a]")
+	}
+}
+
+func TestForFilterAndElse(t *testing.T) {
+	obj := evalToObject(t, `
+let ports = [{name: "http", enabled: true}, {name: "debug", enabled: false}, {name: "metrics", enabled: true}]
+enabled: [for p in ports if p.enabled do "${p.name}:${loop.index}/${loop.length}" end]
+none: [for p in ports if p.name == "grpc" do p.name else "none" end]
+empty: [for x in [] do x else "empty" end]
+	`)
+
+	tests := map[string]string{
+		"enabled": "[http:0/2, metrics:1/2]",
+		"none":    "[none]",
+		"empty":   "[empty]",
+	}
+	for key, want := range tests {
+		if got := getArray(t, obj, key).String(); got != want {
+			t.Errorf("%s: got %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestForStringsAndRanges(t *testing.T) {
+	obj := evalToObject(t, `
+chars: [for i, c in "héy" do "${i}${c}" end]
+range: [for i in 3 do i * 10 end]
+breakContinue: [
+	for i in 10 do
+		if i == 1 do continue end
+		if i == 4 do break end
+		i
+	end
+]
+	`)
+
+	tests := map[string]string{
+		"chars":         "[0h, 1é, 2y]",
+		"range":         "[0, 10, 20]",
+		"breakContinue": "[0, 2, 3]",
+	}
+	for key, want := range tests {
+		if got := getArray(t, obj, key).String(); got != want {
+			t.Errorf("%s: got %q, want %q", key, got, want)
+		}
+	}
+
+	expectError(t, `result: [for i in 2.5 do i end]`, "expected a non-negative integer")
+	expectError(t, `result: [for x in null do x else "missing" end]`, "[test.helmtk 1:19] cannot iterate over null")
+}
+
+func TestForSorted(t *testing.T) {
+	obj := evalToObject(t, `
+let obj = {b: 2, a: 3, c: 1, d: 2}
+let ports = [{name: "https", port: 443}, {name: "http", port: 80}]
+keys: [for k, v in sorted obj do k end]
+keysDesc: [for k, v in sorted obj desc do k end]
+byValue: [for k, v in obj sort by value do k end]
+byValueDesc: [for k, v in obj sort by v desc if v > 1 do k end]
+byField: [for p in ports sort by p.port do p.name end]
+sorted: [for sorted in [2, 1] do sorted end]
+values: [for x in sorted [3, 1, 2] do x end]
+valuesDesc: [for i, x in sorted ["b", "c", "a"] desc do "${i}${x}" end]
+	`)
+
+	tests := map[string]string{
+		"keys":        "[a, b, c, d]",
+		"keysDesc":    "[d, c, b, a]",
+		"byValue":     "[c, b, d, a]",
+		"byValueDesc": "[a, b, d]",
+		"byField":     "[http, https]",
+		"sorted":      "[2, 1]",
+		"values":      "[1, 2, 3]",
+		"valuesDesc":  "[1c, 0b, 2a]",
+	}
+	for key, want := range tests {
+		if got := getArray(t, obj, key).String(); got != want {
+			t.Errorf("%s: got %q, want %q", key, got, want)
+		}
+	}
+
+	expectError(t, `result: [for x in [1, "a"] sort by x do x end]`, "cannot compare")
+	expectError(t, `result: [for x in sorted [1, "a"] do x end]`, "cannot compare")
+}
+
+func TestHiddenFields(t *testing.T) {
+	obj := evalToObject(t, `
+let app = {
+	hidden base: "web"
+	name: "app"
+}
+let merged = {
+	spread app
+	extra: true
+}
+name: "${app.base}-${app.name}"
+hidden internal: app.base
+nested: {
+	hidden secret: 1
+	visible: merged.base
+}
+list: [{hidden a: 1, b: 2}]
+hidden: "a plain key"
+overridden: {
+	hidden port: 80
+	port: 8080
+}
+	`)
+
+	if got := getString(t, obj, "name"); got != "web-app" {
+		t.Errorf("name: got %q, want %q", got, "web-app")
+	}
+	if got := getString(t, obj, "nested.visible"); got != "web" {
+		t.Errorf("nested.visible: got %q, want %q", got, "web")
+	}
+	if got := getString(t, obj, "hidden"); got != "a plain key" {
+		t.Errorf("hidden: got %q, want %q", got, "a plain key")
+	}
+	if got := getString(t, obj, "overridden.port"); got != "8080" {
+		t.Errorf("overridden.port: got %q, want %q", got, "8080")
+	}
+
+	for _, path := range []string{"internal", "nested.secret"} {
+		parts := strings.Split(path, ".")
+		parent := obj
+		if len(parts) > 1 {
+			parent = getPath(t, obj, parts[0]).(*runtime.ObjectValue)
+		}
+		if _, ok := parent.Get(parts[len(parts)-1]); ok {
+			t.Errorf("%s: expected hidden field to be stripped", path)
+		}
+	}
+	if got := getArray(t, obj, "list").String(); got != "[{b: 2}]" {
+		t.Errorf("list: got %q, want %q", got, "[{b: 2}]")
+	}
+}
+
+func TestSelfReferences(t *testing.T) {
+	obj := evalToObject(t, `
+app: {
+	fullname: "${self.name}-${self.suffix}"
+	name: "web"
+	hidden suffix: self["env"] + "1"
+	env: "prod"
+	labels: {
+		app: self.component
+		component: "server"
+	}
+	if self.debug do
+		logLevel: "debug"
+	end
+	debug: self.env != "prod"
+}
+override: {
+	name: self.base + "-x"
+	hidden base: "a"
+	name: "replaced"
+}
+service: {
+	if self.expose do
+		type: "LoadBalancer"
+	end
+	ports: [{port: self.port, targetPort: self.port}]
+	hidden port: 8080
+	expose: true
+}
+	`)
+
+	tests := map[string]string{
+		"app.fullname":   "web-prod1",
+		"app.labels.app": "server",
+		"override.name":  "replaced",
+		"service.type":   "LoadBalancer",
+	}
+	for path, want := range tests {
+		if got := getString(t, obj, path); got != want {
+			t.Errorf("%s: got %q, want %q", path, got, want)
+		}
+	}
+	if getBool(t, obj, "app.debug") {
+		t.Error("app.debug: expected false")
+	}
+
+	// A field that refers to its own name gets it from the enclosing object
+	ports := getArray(t, obj, "service.ports")
+	port := ports.Elements[0].(*runtime.ObjectValue)
+	for _, key := range []string{"port", "targetPort"} {
+		if val, _ := port.Get(key); val.String() != "8080" {
+			t.Errorf("service.ports[0].%s: got %s, want 8080", key, val)
+		}
+	}
+}
+
+func TestBareSelf(t *testing.T) {
+	// A bare self is a copy of the fields set so far, without the field
+	// that holds it
+	obj := evalToObject(t, `
+obj: {
+	a: 1
+	me: self
+}
+outer: {
+	nested: {
+		b: 2
+		back: self
+	}
+}
+	`)
+
+	tests := map[string]string{
+		"obj.me":            "{a: 1}",
+		"outer.nested.back": "{b: 2}",
+	}
+	for path, want := range tests {
+		if got := getString(t, obj, path); got != want {
+			t.Errorf("%s: got %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestErrorCircularSelfReference(t *testing.T) {
+	expectError(t, `app: {
+	a: self.b + 1
+	b: self.c + 1
+	c: self.a + 1
+}`, "[test.helmtk 2:2] circular field reference: a -> b -> c -> a")
+	expectError(t, `app: {
+	port: self.port
+}`, "[test.helmtk 2:2] circular field reference: port -> port")
+	expectError(t, `app: {
+	a: self.b
+	if self.a do
+		b: 1
+	end
+}`, "[test.helmtk 2:2] circular field reference: a -> b -> a")
+}
+
+func TestDocumentBlocks(t *testing.T) {
+	doc, err := parser.New(`
+{kind: "ConfigMap"}
+name: "merged"
+---
+name: "separate"
+
+document "deployment.yaml", weight = -10 do
+	kind: "Deployment"
+end
+loose: true
+
+for svc in ["a", "b"] do
+	document "service-${svc}.yaml" do
+		{kind: "Service", name: svc}
+	end
+end
+	`, "chart.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	docs, err := EvalDocuments(doc, runtime.NewScope(nil))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	want := []struct {
+		filename string
+		weight   int
+		fields   string
+	}{
+		{"deployment.yaml", -10, "kind"},
+		{"", 0, "kind name"},
+		{"", 0, "name"},
+		{"", 0, "loose"},
+		{"service-a.yaml", 0, "kind name"},
+		{"service-b.yaml", 0, "kind name"},
+	}
+	if len(docs) != len(want) {
+		t.Fatalf("expected %d documents, got %d", len(want), len(docs))
+	}
+	for i, w := range want {
+		d := docs[i]
+		obj := d.Value.(*runtime.ObjectValue)
+		fields := strings.Join(slices.Sorted(maps.Keys(obj.Fields)), " ")
+		if d.Filename != w.filename || d.Weight != w.weight || fields != w.fields {
+			t.Errorf("document %d: got %q weight %d fields %q, want %q weight %d fields %q",
+				i, d.Filename, d.Weight, fields, w.filename, w.weight, w.fields)
+		}
+		if d.Source != "chart.helmtk" {
+			t.Errorf("document %d: got source %q, want %q", i, d.Source, "chart.helmtk")
+		}
+	}
+
+	expectError(t, `config: {
+	document "x" do
+		a: 1
+	end
+}`, "expected ':'")
+	expectError(t, `items: [document "x" do a: 1 end]`, "document blocks are only allowed at the top level")
+	expectError(t, `document do
+	{a: 1}
+	{b: 2}
+end`, "document block must produce a single value, got 2")
+}
+
+func TestStreamDocuments(t *testing.T) {
+	doc, err := parser.New(`
+define("item") do
+	{name: "included"}
+end
+document "first.yaml", weight = 10 do
+	name: "first"
+end
+include("item")
+---
+name: "third"
+broken: missing
+	`, "chart.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	// Documents arrive in source order, and breaking out of the loop
+	// stops evaluation before the broken document
+	var names []string
+	for val, err := range StreamDocuments(doc, runtime.NewScope(nil)) {
+		if err != nil {
+			t.Fatalf("eval error: %v", err)
+		}
+		names = append(names, getString(t, val.(*runtime.ObjectValue), "name"))
+		if len(names) == 2 {
+			break
+		}
+	}
+	if got := strings.Join(names, " "); got != "first included" {
+		t.Errorf("got documents %q, want %q", got, "first included")
+	}
+
+	// Without breaking, the error is yielded after the complete documents
+	var errs []error
+	count := 0
+	for val, err := range StreamDocuments(doc, runtime.NewScope(nil)) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if val == nil {
+			t.Errorf("got nil document without an error")
+		}
+		count++
+	}
+	if count != 2 || len(errs) != 1 || !strings.Contains(errs[0].Error(), "undefined variable: missing") {
+		t.Errorf("got %d documents and errors %v, want 2 documents and an undefined variable error", count, errs)
+	}
+
+	// Callback errors are returned as is
+	errWrite := errors.New("write failed")
+	err = EvalDocumentsFunc(doc, runtime.NewScope(nil), func(d *Document) error {
+		if d.Filename == "" {
+			return errWrite
+		}
+		return nil
+	})
+	if err != errWrite {
+		t.Errorf("got error %v, want %v", err, errWrite)
+	}
+
+	err = EvalDocumentsFunc(doc, runtime.NewScope(nil), func(d *Document) error {
+		return ErrStop
+	})
+	if err != nil {
+		t.Errorf("got error %v after ErrStop, want nil", err)
+	}
+}
+
 func TestWithStatement(t *testing.T) {
 	obj := evalToObject(t, `
 let config = {name: "test"}
@@ -234,6 +728,103 @@ result: with config as cfg do cfg.name end
 	}
 }
 
+func TestMatchStatement(t *testing.T) {
+	obj := evalToObject(t, `
+let svc = {type: "LoadBalancer", port: 8443}
+let mode = "b"
+kind: match mode do
+	case "a", "b" => "letter"
+	case _ => "other"
+end
+exposure: match svc do
+	case {type: "ClusterIP"} => "internal"
+	case {type: "LoadBalancer", port: p} if p > 1024 => "external:${p}"
+	case _ => "unknown"
+end
+fallback: match 42 do
+	case "42" => "string"
+	case n => n + 1
+end
+ports: [
+	match svc.type do
+		case "LoadBalancer" => 80, 443
+	end
+]
+config: {
+	match mode do
+		case "a" => level: "debug"
+		case "b" => level: "info"
+			case: mode
+	end
+}
+route: {match: [{uri: {prefix: "/api"}}]}
+	`)
+
+	if got := getString(t, obj, "kind"); got != "letter" {
+		t.Errorf("kind: got %q, want %q", got, "letter")
+	}
+	if got := getString(t, obj, "exposure"); got != "external:8443" {
+		t.Errorf("exposure: got %q, want %q", got, "external:8443")
+	}
+	if got := getString(t, obj, "fallback"); got != "43" {
+		t.Errorf("fallback: got %q, want %q", got, "43")
+	}
+	if got := getArray(t, obj, "ports"); len(got.Elements) != 2 {
+		t.Errorf("ports: got %d elements, want 2", len(got.Elements))
+	}
+	if got := getString(t, obj, "config.level"); got != "info" {
+		t.Errorf("config.level: got %q, want %q", got, "info")
+	}
+	// Keywords are plain keys before a colon
+	if got := getString(t, obj, "config.case"); got != "b" {
+		t.Errorf("config.case: got %q, want %q", got, "b")
+	}
+	if got := getArray(t, obj, "route.match"); len(got.Elements) != 1 {
+		t.Errorf("route.match: got %d elements, want 1", len(got.Elements))
+	}
+}
+
+func TestWithStatementSkipsEmpty(t *testing.T) {
+	obj := evalToObject(t, `
+let ingress = {host: "example.com"}
+let empty = {}
+config: {
+	with ingress as ing do
+		host: ing.host
+	end
+	with empty as e do
+		skipped: true
+	end
+	with null as n do
+		tls: n
+	else
+		tls: false
+	end
+	with "" as s always do
+		bound: s == ""
+	end
+}
+name: with empty as e do "set" else "default" end
+	`)
+
+	if got := getString(t, obj, "config.host"); got != "example.com" {
+		t.Errorf("config.host: got %q, want %q", got, "example.com")
+	}
+	config := getPath(t, obj, "config").(*runtime.ObjectValue)
+	if _, ok := config.Get("skipped"); ok {
+		t.Error("config.skipped: expected body to be skipped for empty context")
+	}
+	if got := getString(t, obj, "config.tls"); got != "false" {
+		t.Errorf("config.tls: got %q, want %q", got, "false")
+	}
+	if got := getString(t, obj, "config.bound"); got != "true" {
+		t.Errorf("config.bound: got %q, want %q", got, "true")
+	}
+	if got := getString(t, obj, "name"); got != "default" {
+		t.Errorf("name: got %q, want %q", got, "default")
+	}
+}
+
 func TestSpread(t *testing.T) {
 	t.Run("array spread", func(t *testing.T) {
 		obj := evalToObject(t, `
@@ -314,73 +905,506 @@ labels: {
 	}
 }
 
-func TestPipes(t *testing.T) {
-	scope := runtime.NewScope(nil)
-	scope.SetFunction("upper", func(args ...runtime.Value) (runtime.Value, error) {
-		s, err := runtime.ToString(args[0])
-		if err != nil {
-			return nil, err
-		}
-		return runtime.NewString(strings.ToUpper(s)), nil
-	})
+func TestTemplateParams(t *testing.T) {
+	result := eval(t, `
+define("makeLabel", app, version = "1.0", name = "${app}-${version}") do
+	app: app
+	version: version
+	name: name
+end
 
-	result := evalWithScope(t, scope, `result: "hello" | upper`)
+defaults: {
+	include("makeLabel", {app: "web"})
+}
+explicit: {
+	include("makeLabel", {app: "api", version: "2.0"})
+}
+	`)
 
 	obj := getDocument(t, result, 0)
-	if got := getString(t, obj, "result"); got != "HELLO" {
-		t.Errorf("result: got %q, want %q", got, "HELLO")
+	tests := map[string]string{
+		"defaults.version": "1.0",
+		"defaults.name":    "web-1.0",
+		"explicit.version": "2.0",
+		"explicit.name":    "api-2.0",
+	}
+	for path, want := range tests {
+		if got := getString(t, obj, path); got != want {
+			t.Errorf("%s: got %q, want %q", path, got, want)
+		}
 	}
 }
 
-func TestMultipleDocuments(t *testing.T) {
-	result := eval(t, `
-{kind: "ConfigMap"}
-{kind: "Deployment"}
+func TestDynamicInclude(t *testing.T) {
+	obj := evalToObject(t, `
+define("probe.http") do
+	httpGet: {path: "/healthz"}
+end
+
+define("probe.tcp") do
+	tcpSocket: {port: 8080}
+end
+
+let kind = "tcp"
+probe: {
+	include("probe." + kind)
+}
+hasHttp: templateExists("probe.http")
+hasExec: templateExists("probe.exec")
 	`)
 
-	arr, ok := result.(*runtime.ArrayValue)
-	if !ok {
-		t.Fatalf("expected ArrayValue, got %T", result)
+	if got := getPath(t, obj, "probe.tcpSocket.port").String(); got != "8080" {
+		t.Errorf("probe.tcpSocket.port: got %q, want %q", got, "8080")
 	}
-
-	if len(arr.Elements) != 2 {
-		t.Fatalf("expected 2 documents, got %d", len(arr.Elements))
+	if !getBool(t, obj, "hasHttp") {
+		t.Error("hasHttp: expected true")
 	}
-
-	doc1 := arr.Elements[0].(*runtime.ObjectValue)
-	if got := getString(t, doc1, "kind"); got != "ConfigMap" {
-		t.Errorf("doc1.kind: got %q, want %q", got, "ConfigMap")
+	if getBool(t, obj, "hasExec") {
+		t.Error("hasExec: expected false")
 	}
 
-	doc2 := arr.Elements[1].(*runtime.ObjectValue)
-	if got := getString(t, doc2, "kind"); got != "Deployment" {
-		t.Errorf("doc2.kind: got %q, want %q", got, "Deployment")
-	}
+	expectError(t, `let kind = "exec"
+probe: include("probe.${kind}")`, "undefined template: probe.exec")
+	expectError(t, `probe: include(42)`, "template name must be a string, got number")
 }
 
-// Error tests
+func TestIncludeContentBlocks(t *testing.T) {
+	result := eval(t, `
+define("container", name) do
+	name: name
+	env: [
+		"LOG_LEVEL=info"
+		yield
+	]
+	ports: [yield ports]
+end
 
-func TestErrorDivisionByZero(t *testing.T) {
-	expectError(t, "result: 10 / 0", "division by zero")
-}
+define("wrapper") do
+	inner: {
+		include("container", {name: "inner"}) do
+			yield
+		end
+	}
+end
 
-func TestErrorArrayIndexBounds(t *testing.T) {
-	expectError(t, `
-let items = [1, 2, 3]
-result: items[10]
-	`, "array index out of bounds")
+let level = "debug"
+app: {
+	include("container", {name: "app"}) do
+		"DEBUG=${level}"
+		slot ports do
+			8080
+		end
+	end
 }
-
-func TestErrorUndefinedFunction(t *testing.T) {
-	expectError(t, `result: unknownFunc()`, "undefined function")
+bare: {
+	include("container", {name: "bare"})
 }
-
-func TestErrorUndefinedTemplate(t *testing.T) {
-	expectError(t, `include("unknown")`, "undefined template")
+wrapped: {
+	include("wrapper") do
+		"FROM_OUTSIDE=yes"
+	end
 }
+	`)
+
+	obj := getDocument(t, result, 0)
+	tests := map[string]string{
+		"app.env":             "[LOG_LEVEL=info, DEBUG=debug]",
+		"app.ports":           "[8080]",
+		"bare.env":            "[LOG_LEVEL=info]",
+		"bare.ports":          "[]",
+		"wrapped.inner.env":   "[LOG_LEVEL=info, FROM_OUTSIDE=yes]",
+		"wrapped.inner.ports": "[]",
+	}
+	for path, want := range tests {
+		if got := getArray(t, obj, path).String(); got != want {
+			t.Errorf("%s: got %q, want %q", path, got, want)
+		}
+	}
+
+	expectError(t, `items: [yield]`, "yield outside of a template")
+}
+
+func TestTemplateOverride(t *testing.T) {
+	scope := runtime.NewScope(nil)
+	lib, err := parser.New(`
+define("labels", app) do
+	app: app
+	managedBy: "helmtk"
+end`, "lib.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := DefineTemplates(lib, scope); err != nil {
+		t.Fatalf("define error: %v", err)
+	}
+
+	result := evalWithScope(t, scope, `
+override define("labels", app) do
+	super()
+	team: "platform"
+end
+
+override define("labels", app) do
+	super({app: app + "-v2"})
+end
+
+labels: {
+	include("labels", {app: "web"})
+}
+	`)
+
+	obj := getDocument(t, result, 0)
+	tests := map[string]string{
+		"labels.app":       "web-v2",
+		"labels.managedBy": "helmtk",
+		"labels.team":      "platform",
+	}
+	for path, want := range tests {
+		if got := getString(t, obj, path); got != want {
+			t.Errorf("%s: got %q, want %q", path, got, want)
+		}
+	}
+
+	// The overrides only apply to the evaluation, the scope keeps the original
+	tmpl, err := scope.GetTemplate("labels")
+	if err != nil {
+		t.Fatalf("GetTemplate error = %v", err)
+	}
+	if got := tmpl.Location(); got != "lib.helmtk:2:1" || tmpl.Super != nil {
+		t.Errorf("got template from %q with super %v, want the original from %q", got, tmpl.Super, "lib.helmtk:2:1")
+	}
+}
+
+func TestErrorTemplateRedefinition(t *testing.T) {
+	expectError(t, `define("x") 1
+define("x") 2`, `[test.helmtk 2:1] template "x" is already defined at test.helmtk:1:1`)
+	expectError(t, `override define("x") 1`, `cannot override undefined template "x"`)
+	expectError(t, `define("x") super()
+value: include("x")`, "super() used outside of an override")
+}
+
+func TestRecursiveTemplate(t *testing.T) {
+	obj := evalToObject(t, `
+define("menu", items) do
+	for item in items do
+		{
+			title: item.title
+			if item.children do
+				children: [include("menu", {items: item.children})]
+			end
+		}
+	end
+end
+
+let tree = [
+	{title: "a", children: [{title: "b", children: [{title: "c"}]}]}
+]
+menu: [include("menu", {items: tree})]
+	`)
+
+	if got := getArray(t, obj, "menu").String(); !strings.Contains(got, "title: c") {
+		t.Errorf("menu: expected the innermost item, got %q", got)
+	}
+}
+
+func TestErrorIncludeDepth(t *testing.T) {
+	doc, err := parser.New(`
+define("a", n) do
+	if n > 0 do
+		value: {include("b", {n: n - 1})}
+	end
+end
+
+define("b", n) do
+	include("a", {n: n})
+end
+
+result: {include("a", {n: 10})}
+	`, "test.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	if _, err := EvalDocument(doc, runtime.NewScope(nil)); err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	_, err = EvalDocument(doc, runtime.NewScope(nil), MaxIncludeDepth(5))
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	want := "[test.helmtk 4:11] maximum include depth of 5 exceeded, include cycle: b -> a -> b"
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+}
+
+func TestContextCancelled(t *testing.T) {
+	doc, err := parser.New(`
+define("item", n) do
+	value: tick(n)
+end
+
+items: [for i in 10 do try {include("item", {n: i})} else "skipped" end]
+	`, "test.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	// The context is cancelled while the document is being evaluated
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	scope := runtime.NewScope(nil)
+	scope.SetFunction("tick", func(args ...runtime.Value) (runtime.Value, error) {
+		calls++
+		if calls == 3 {
+			cancel()
+		}
+		return args[0], nil
+	})
+
+	_, err = EvalDocument(doc, scope, Context(ctx))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if calls != 3 {
+		t.Errorf("got %d calls after cancelling, want 3", calls)
+	}
+
+	prog, err := Compile(doc, scope)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	calls = 0
+	if _, err := prog.Run(nil, Context(ctx)); !errors.Is(err, context.Canceled) {
+		t.Errorf("compiled: got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestErrorUnconditionalIncludeCycle(t *testing.T) {
+	expectError(t, `define("a") do
+	name: "a"
+	child: {include("b")}
+end
+define("b") [include("a")]
+result: {include("a")}`, `[test.helmtk 1:1] template "a" always includes itself: a -> b -> a`)
+
+	// Includes behind a condition are only limited at runtime
+	expectError(t, `define("a") do
+	if true do
+		child: {include("a")}
+	end
+end
+result: {include("a")}`, "maximum include depth of 100 exceeded, include cycle: a -> a")
+}
+
+func TestErrorTemplateParams(t *testing.T) {
+	define := "define(\"makeLabel\", app, version = \"1.0\") do\n\tapp: app\nend\n"
+
+	expectError(t, define+`labels: { include("makeLabel", {ap: "web"}) }`,
+		`[test.helmtk 4:11] include "makeLabel": unknown parameter "ap"`)
+	expectError(t, define+`labels: { include("makeLabel", {version: "2.0"}) }`,
+		`include "makeLabel": missing required parameter "app"`)
+	expectError(t, define+`labels: { include("makeLabel") }`,
+		`include "makeLabel": missing required parameter "app"`)
+}
+
+func TestPipes(t *testing.T) {
+	scope := runtime.NewScope(nil)
+	scope.SetFunction("upper", func(args ...runtime.Value) (runtime.Value, error) {
+		s, err := runtime.ToString(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return runtime.NewString(strings.ToUpper(s)), nil
+	})
+
+	result := evalWithScope(t, scope, `result: "hello" | upper`)
+
+	obj := getDocument(t, result, 0)
+	if got := getString(t, obj, "result"); got != "HELLO" {
+		t.Errorf("result: got %q, want %q", got, "HELLO")
+	}
+}
+
+func TestMultipleDocuments(t *testing.T) {
+	result := eval(t, `
+{kind: "ConfigMap"}
+{kind: "Deployment"}
+	`)
+
+	arr, ok := result.(*runtime.ArrayValue)
+	if !ok {
+		t.Fatalf("expected ArrayValue, got %T", result)
+	}
+
+	if len(arr.Elements) != 2 {
+		t.Fatalf("expected 2 documents, got %d", len(arr.Elements))
+	}
+
+	doc1 := arr.Elements[0].(*runtime.ObjectValue)
+	if got := getString(t, doc1, "kind"); got != "ConfigMap" {
+		t.Errorf("doc1.kind: got %q, want %q", got, "ConfigMap")
+	}
+
+	doc2 := arr.Elements[1].(*runtime.ObjectValue)
+	if got := getString(t, doc2, "kind"); got != "Deployment" {
+		t.Errorf("doc2.kind: got %q, want %q", got, "Deployment")
+	}
+}
+
+func TestTryExpression(t *testing.T) {
+	obj := evalToObject(t, `
+define("broken") do
+	fail("snippet is broken")
+end
+
+let items = [1, 2]
+ok: try items[0] else 0
+outOfBounds: try items[5] else 0
+snippet: try include("missing") else {}
+message: try include("broken") catch err => err.message
+line: try 10 / 0 catch err => err.line
+	`)
+
+	if got := getString(t, obj, "ok"); got != "1" {
+		t.Errorf("ok: got %q, want %q", got, "1")
+	}
+	if got := getString(t, obj, "outOfBounds"); got != "0" {
+		t.Errorf("outOfBounds: got %q, want %q", got, "0")
+	}
+	if got := getPath(t, obj, "snippet"); got.Type() != runtime.ObjectType {
+		t.Errorf("snippet: got %s, want object", got.Type())
+	}
+	if got := getString(t, obj, "message"); !strings.Contains(got, "snippet is broken") {
+		t.Errorf("message: got %q, want it to mention the failure", got)
+	}
+	if got := getString(t, obj, "line"); got != "11" {
+		t.Errorf("line: got %q, want %q", got, "11")
+	}
+}
+
+func TestTryRecoversCollectedFailures(t *testing.T) {
+	doc, err := parser.New(`
+define("checked") do
+	fail("not allowed")
+end
+
+result: try include("checked") else "fallback"
+	`, "test.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	result, err := EvalDocument(doc, runtime.NewScope(nil), CollectFailures())
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := getString(t, getDocument(t, result, 0), "result"); got != "fallback" {
+		t.Errorf("result: got %q, want %q", got, "fallback")
+	}
+}
+
+// Error tests
+
+func TestErrorDivisionByZero(t *testing.T) {
+	expectError(t, "result: 10 / 0", "division by zero")
+}
+
+func TestErrorArrayIndexBounds(t *testing.T) {
+	expectError(t, `
+let items = [1, 2, 3]
+result: items[10]
+	`, "array index out of bounds")
+}
+
+func TestErrorUndefinedFunction(t *testing.T) {
+	expectError(t, `result: unknownFunc()`, "undefined function")
+}
+
+func TestErrorUndefinedTemplate(t *testing.T) {
+	expectError(t, `include("unknown")`, "undefined template")
+}
+
+func TestErrorAssignUndeclared(t *testing.T) {
+	expectError(t, `missing = 1`, "cannot assign to undeclared variable: missing")
+}
+
+func TestErrorMatchNoCase(t *testing.T) {
+	expectError(t, `
+result: match "c" do
+	case "a" => 1
+end
+	`, "no match case for value c")
+}
+
+func TestAssertStatement(t *testing.T) {
+	obj := evalToObject(t, `
+let replicas = 3
+assert replicas > 0, "replicas must be positive"
+replicas: replicas
+	`)
+	if got := getString(t, obj, "replicas"); got != "3" {
+		t.Errorf("replicas: got %q, want %q", got, "3")
+	}
+
+	expectError(t, `
+let replicas = 0
+assert replicas > 0, "replicas must be positive, got ${replicas}"
+	`, "[test.helmtk 3:1] replicas must be positive, got 0")
+
+	expectError(t, `assert false`, "assertion failed")
+}
+
+func TestFailStatement(t *testing.T) {
+	expectError(t, `
+let mode = "bogus"
+config: {
+	if mode != "debug" do
+		fail("unsupported mode: " + mode)
+	end
+}
+	`, "[test.helmtk 5:3] unsupported mode: bogus")
+}
+
+func TestCollectFailures(t *testing.T) {
+	doc, err := parser.New(`
+assert 1 > 2, "first"
+config: {
+	assert false, "second"
+	fail("third")
+	name: "ok"
+}
+	`, "test.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	_, err = EvalDocument(doc, runtime.NewScope(nil), CollectFailures())
+	failures, ok := err.(AssertionErrors)
+	if !ok {
+		t.Fatalf("expected AssertionErrors, got %T (%v)", err, err)
+	}
+
+	want := []string{"first", "second", "third"}
+	if len(failures) != len(want) {
+		t.Fatalf("expected %d failures, got %d: %v", len(want), len(failures), failures)
+	}
+	for i, w := range want {
+		if failures[i].Message != w {
+			t.Errorf("failure %d: got %q, want %q", i, failures[i].Message, w)
+		}
+	}
+	if failures[1].Line != 4 || failures[1].Col != 2 {
+		t.Errorf("failure 1: got position %d:%d, want 4:2", failures[1].Line, failures[1].Col)
+	}
+}
+
+// Helper functions
 
-// Helper functions
-
 func eval(t *testing.T, input string) runtime.Value {
 	t.Helper()
 	return evalWithScope(t, runtime.NewScope(nil), input)
@@ -398,9 +1422,33 @@ func evalWithScope(t *testing.T, scope *runtime.Scope, input string) runtime.Val
 		t.Fatalf("eval error: %v", err)
 	}
 
+	// A compiled program must give the same documents as the tree-walker
+	docs, err := compileAndRun(doc, scope)
+	if err != nil {
+		t.Fatalf("compiled run error: %v", err)
+	}
+	for i, d := range docs {
+		want := runtime.ToNative(result.(*runtime.ArrayValue).Elements[i])
+		if got := runtime.ToNative(d.Value); !reflect.DeepEqual(got, want) {
+			t.Fatalf("compiled run gave document %d:\n%v\nwant:\n%v", i, got, want)
+		}
+	}
+	if n := len(result.(*runtime.ArrayValue).Elements); len(docs) != n {
+		t.Fatalf("compiled run gave %d documents, want %d", len(docs), n)
+	}
+
 	return result
 }
 
+// compileAndRun evaluates a document as a compiled program
+func compileAndRun(doc *parser.Document, scope *runtime.Scope) ([]*Document, error) {
+	prog, err := Compile(doc, scope)
+	if err != nil {
+		return nil, err
+	}
+	return prog.Run(nil)
+}
+
 func evalToObject(t *testing.T, input string) *runtime.ObjectValue {
 	t.Helper()
 	result := eval(t, input)
@@ -484,4 +1532,510 @@ func expectError(t *testing.T, input string, wantErr string) {
 	if !strings.Contains(err.Error(), wantErr) {
 		t.Errorf("error mismatch\ngot: %v\nwant substring: %s", err, wantErr)
 	}
+
+	// A compiled program must fail the same way as the tree-walker
+	if _, cerr := compileAndRun(doc, scope); cerr == nil {
+		t.Errorf("compiled run: expected error %q but got none", err)
+	} else if cerr.Error() != err.Error() {
+		t.Errorf("compiled run error mismatch\ngot: %v\nwant: %v", cerr, err)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	doc, err := parser.New(`
+define("labels") do
+	app: Values.name
+	tier: "web-${1 + 1}"
+end
+let port = 8000 + 80
+name: Values.name
+port: port
+labels: {include("labels")}
+ratio: 1 / 0
+	`, "chart.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	env := runtime.NewScope(nil)
+	env.SetGlobal("region", runtime.NewString("eu"))
+	prog, err := Compile(doc, env)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+
+	// Constants are folded in a copy of the document, and expressions that
+	// fail are left to fail at runtime
+	let := prog.body[0].(*parser.LetStatement)
+	if lit, ok := let.Value.(*parser.NumberLiteral); !ok || lit.Value != 8080 {
+		t.Errorf("got let value %#v, want folded 8080", let.Value)
+	}
+	if _, ok := doc.Body[0].(*parser.LetStatement).Value.(*parser.BinaryOp); !ok {
+		t.Errorf("compile changed the document")
+	}
+	if _, err := prog.Run(runtime.NewValue(map[string]any{"name": "a"})); err == nil {
+		t.Errorf("expected division by zero error")
+	}
+
+	// Variables are resolved to slots, globals are looked up by name
+	port := prog.body[2].(*parser.KeyValueStatement).Value.(*parser.Identifier)
+	if port.Binding == nil || port.Binding.Frame != prog.frame {
+		t.Errorf("port isn't bound to the top-level frame: %#v", port.Binding)
+	}
+	name := prog.body[1].(*parser.KeyValueStatement).Value.(*parser.MemberExpression).Object.(*parser.Identifier)
+	if name.Binding != nil {
+		t.Errorf("Values is bound to a slot: %#v", name.Binding)
+	}
+
+	// Templates are defined in the program rather than env, so the same
+	// env can be compiled against again
+	doc.Body = doc.Body[:len(doc.Body)-1]
+	prog, err = Compile(doc, env)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+
+	// Runs don't see each other's values
+	var wg sync.WaitGroup
+	for _, name := range []string{"a", "b", "c", "d"} {
+		wg.Go(func() {
+			docs, err := prog.Run(runtime.NewValue(map[string]any{"name": name}))
+			if err != nil {
+				t.Errorf("run error: %v", err)
+				return
+			}
+			obj := docs[0].Value.(*runtime.ObjectValue)
+			labels := getPath(t, obj, "labels").(*runtime.ObjectValue)
+			if getString(t, obj, "name") != name || getString(t, labels, "app") != name ||
+				getString(t, labels, "tier") != "web-2" {
+				t.Errorf("run %q: got %s", name, obj)
+			}
+		})
+	}
+	wg.Wait()
+
+	if _, err := env.GetTemplate("labels"); err == nil {
+		t.Errorf("compile defined templates in env")
+	}
+}
+
+func TestConcurrentEvaluation(t *testing.T) {
+	root := runtime.NewScope(nil)
+	root.Set("region", runtime.NewString("eu"))
+	root.SetFunction("upper", func(args ...runtime.Value) (runtime.Value, error) {
+		return runtime.NewString(strings.ToUpper(args[0].String())), nil
+	})
+	lib, err := parser.New(`define("labels", app) do
+	app: app
+	region: region
+end`, "lib.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if err := DefineTemplates(lib, root); err != nil {
+		t.Fatalf("define error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Go(func() {
+			doc, err := parser.New(fmt.Sprintf(`
+override define("labels", app) do
+	super({app: upper(app)})
+	worker: %d
+end
+let name = "app-%d"
+labels: {include("labels", {app: name})}
+`, i, i), "test.helmtk").Parse()
+			if err != nil {
+				t.Errorf("parse error: %v", err)
+				return
+			}
+			docs, err := EvalDocuments(doc, root)
+			if err != nil {
+				t.Errorf("eval error: %v", err)
+				return
+			}
+			labels := getPath(t, docs[0].Value.(*runtime.ObjectValue), "labels").(*runtime.ObjectValue)
+			if got, want := getString(t, labels, "app"), fmt.Sprintf("APP-%d", i); got != want {
+				t.Errorf("worker %d: got app %q, want %q", i, got, want)
+			}
+			if got := getString(t, labels, "region"); got != "eu" {
+				t.Errorf("worker %d: got region %q, want %q", i, got, "eu")
+			}
+		})
+	}
+	wg.Wait()
+
+	// Variables of the root scope are read-only during evaluation
+	doc, err := parser.New(`region = "us"`, "test.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if _, err := EvalDocument(doc, root); err == nil || !strings.Contains(err.Error(), "cannot assign to read-only variable: region") {
+		t.Errorf("got error %v, want read-only variable error", err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	doc, err := parser.New(`
+define("labels", app) do
+	app: app
+	name: ap
+end
+
+define("loose") do
+	anything: fromContext
+end
+
+let replicas = Values.replicas
+if Values.debug do
+	debug: replcias
+	level: lower(Values.level)
+end
+
+for i, port in Values.ports sort by port.number do
+	name: "port-${i}"
+	number: port.number | uper
+end
+
+let replicas = 3
+config: {
+	size: self.count
+	fallback: try missing else "default"
+	labels: {include("lables", {app: "web"})}
+}
+if templateExists("custom") do
+	custom: include("custom")
+end
+	`, "chart.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	scope := runtime.NewScope(nil)
+	scope.SetFunction("upper", func(args ...runtime.Value) (runtime.Value, error) { return args[0], nil })
+	scope.SetFunction("lower", func(args ...runtime.Value) (runtime.Value, error) { return args[0], nil })
+
+	var got []string
+	for _, d := range Resolve(doc, scope, "Values") {
+		got = append(got, fmt.Sprintf("%v %s", d.Warning, d))
+	}
+	want := []string{
+		`false chart.helmtk:4:8: undefined variable: ap, did you mean "app"?`,
+		`false chart.helmtk:13:9: undefined variable: replcias, did you mean "replicas"?`,
+		`false chart.helmtk:19:24: undefined function: uper, did you mean "upper"?`,
+		`true chart.helmtk:22:1: let replicas shadows the variable declared at line 11`,
+		`false chart.helmtk:26:11: undefined template: lables, did you mean "labels"?`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got diagnostics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Sort expressions are evaluated before the loop, without its metadata
+	doc, err = parser.New("items: [for x in [2, 1] sort by loop.index do x end]", "chart.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	diags := Resolve(doc, scope)
+	if len(diags) != 1 || diags[0].Message != "undefined variable: loop" {
+		t.Errorf("got diagnostics %v, want undefined variable: loop", diags)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	doc, err := parser.New(`
+define("ports") do
+	[80, 443]
+end
+
+let name = "web"
+let config = {replicas: 3, labels: {app: name}}
+first: name["first"]
+total: config.labels + config.replicas
+for i, port in include("ports") do
+	"port-${i}": port.number
+end
+for key, val in config do
+	"${key}": "${val} ${config.labels}"
+end
+image: Values.image.tag - 1
+count: len(Values.ports) * 2
+short: len(5)
+safe: try config.labels + 1 else 0
+loose: Values.extra.anything + 1
+`, "chart.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	values := types.ObjectOf(map[string]*types.Type{
+		"image": types.ObjectOf(map[string]*types.Type{"tag": types.ArrayOf(types.String)}),
+		"ports": types.ArrayOf(types.Number),
+	})
+	sigs := map[string]types.Signature{
+		"len": {Params: []*types.Type{types.ArrayOf(nil)}, Result: types.Number},
+	}
+
+	var got []string
+	for _, d := range Check(doc, runtime.NewScope(nil), ValuesType(values), Signatures(sigs)) {
+		got = append(got, d.String())
+	}
+	want := []string{
+		`chart.helmtk:8:12: cannot index string with string`,
+		`chart.helmtk:9:22: cannot add object and number`,
+		`chart.helmtk:11:19: cannot access member of number`,
+		`chart.helmtk:14:28: cannot convert object to string`,
+		`chart.helmtk:16:25: cannot subtract number from array`,
+		`chart.helmtk:18:11: argument 1 of len must be array, got number`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got diagnostics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCheckValid(t *testing.T) {
+	doc, err := parser.New(benchmarkSource, "test.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	// The types of Values are inferred from the values in scope
+	scope := runtime.NewScope(nil)
+	scope.SetGlobal("Values", benchmarkValues())
+	if diags := Check(doc, scope); len(diags) > 0 {
+		t.Errorf("expected no diagnostics, got %v", diags)
+	}
+}
+
+func TestTypeAnnotations(t *testing.T) {
+	result := eval(t, `
+type Port = {name: string, port: int, protocol?: "TCP" | "UDP"}
+
+define("named", name: string, replicas: int = 1) do
+	name: name
+	replicas: replicas
+end
+
+let ports: [Port] = [{name: "http", port: 80}]
+let count: int = 1
+count = count + 1
+web: {include("named", {name: "web"})}
+checks: {
+	port: ports[0] is Port
+	missing: {port: 80} is Port
+	number: 1.5 is number
+	int: 1.5 is int
+	literal: "TCP" is ("TCP" | "UDP")
+}
+	`)
+
+	obj := getDocument(t, result, 0)
+	tests := map[string]string{
+		"web.name":       "web",
+		"web.replicas":   "1",
+		"checks.port":    "true",
+		"checks.missing": "false",
+		"checks.number":  "true",
+		"checks.int":     "false",
+		"checks.literal": "true",
+	}
+	for path, want := range tests {
+		if got := getPath(t, obj, path).String(); got != want {
+			t.Errorf("%s: got %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestErrorTypeAnnotations(t *testing.T) {
+	port := "type Port = {name: string, port: int, protocol?: \"TCP\" | \"UDP\"}\n"
+
+	expectError(t, port+`let ports: [Port] = [{name: "a", port: 1}, {name: "b", port: 2}, {name: "c", port: "3"}]`,
+		`[test.helmtk 2:1] ports[2].port: expected int, got string`)
+	expectError(t, port+`let p: Port = {name: "a", port: 1, protocol: "SCTP"}`,
+		`p.protocol: expected "TCP" | "UDP", got "SCTP"`)
+	expectError(t, port+`let p: Port = {port: 1}`,
+		`p.name: required field is missing`)
+	expectError(t, "define(\"t\", n: int) do\n\tn: n\nend\nx: {include(\"t\", {n: \"1\"})}",
+		`include "t": n: expected int, got string`)
+	expectError(t, `let x: Missing = 1`, `undefined type: Missing`)
+	expectError(t, "let p: int = 1\np = \"s\"", `[test.helmtk 2:1] p: expected int, got string`)
+	expectError(t, "let p: int = 1\np += 0.5", `p: expected int, got 1.5`)
+	expectError(t, port+"let q: Port = {name: \"a\", port: 1}\nq.port = \"x\"", `[test.helmtk 3:1] q.port: expected int, got string`)
+	expectError(t, port+"let qs: [Port] = [{name: \"a\", port: 1}]\nqs[0][\"port\"] = true", `qs[0].port: expected int, got bool`)
+	expectError(t, "define(\"t\", n: int) do\n\tn = \"x\"\nend\nx: {include(\"t\", {n: 1})}", `n: expected int, got string`)
+	expectError(t, port+`type Port = string`, `type "Port" is already declared at test.helmtk:1:1`)
+	expectError(t, `type int = string`, `cannot redeclare builtin type int`)
+	expectError(t, "type A = B\ntype B = A\nx: 1 is A", `circular type: A -> B -> A`)
+}
+
+func TestCheckAnnotations(t *testing.T) {
+	doc, err := parser.New(`
+type Port = {name: string, port: int}
+
+define("named", name: string) do
+	name: name
+end
+
+let port: Port = {name: "http", port: "80"}
+let count: int = 1
+count = "two"
+named: {include("named", {name: 5})}
+`, "chart.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	var got []string
+	for _, d := range Check(doc, runtime.NewScope(nil)) {
+		got = append(got, d.String())
+	}
+	want := []string{
+		`chart.helmtk:8:1: port: expected Port, got {name: string, port: string}`,
+		`chart.helmtk:10:1: count: expected int, got string`,
+		`chart.helmtk:11:26: include "named": name: expected string, got number`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got diagnostics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestTrackPositions(t *testing.T) {
+	doc, err := parser.New(`define("labels") do
+	app: "web"
+end
+let extra = {tier: "frontend"}
+metadata: {
+	name: "web"
+	labels: {
+		include("labels")
+		spread extra
+	}
+}`, "app.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	val, err := EvalDocument(doc, runtime.NewScope(nil), TrackPositions())
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	obj := getDocument(t, val, 0)
+	metadata := obj.Fields["metadata"].(*runtime.ObjectValue)
+	labels := metadata.Fields["labels"].(*runtime.ObjectValue)
+
+	tests := []struct {
+		obj  *runtime.ObjectValue
+		key  string
+		want string
+	}{
+		{obj, "metadata", "5:1"},
+		{metadata, "name", "6:2"},
+		{labels, "app", "2:2"},
+		{labels, "tier", "4:14"},
+	}
+	for _, tt := range tests {
+		pos, ok := tt.obj.Pos(tt.key)
+		if got := fmt.Sprintf("%d:%d", pos.Line, pos.Col); !ok || got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.key, got, tt.want)
+		}
+	}
+
+	// Positions aren't recorded unless asked for
+	val, err = EvalDocument(doc, runtime.NewScope(nil))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if _, ok := getDocument(t, val, 0).Pos("metadata"); ok {
+		t.Error("expected no positions without TrackPositions")
+	}
+}
+
+const benchmarkSource = `
+define("labels") do
+	app: Values.name
+	release: "${Values.name}-${Values.version}"
+	tier: "frontend"
+end
+
+for i, svc in Values.services do
+	document "${svc.name}.yaml" do
+		kind: "Service"
+		metadata: {
+			name: "${Values.name}-${svc.name}"
+			labels: {include("labels")}
+		}
+		spec: {
+			ports: [for p in svc.ports do {port: p, targetPort: p + 8000 - 8000} end]
+			index: i * 2 + 1
+		}
+	end
+end
+`
+
+func benchmarkValues() runtime.Value {
+	var services []any
+	for i := range 20 {
+		services = append(services, map[string]any{
+			"name":  fmt.Sprintf("svc%d", i),
+			"ports": []any{80, 443, 8080},
+		})
+	}
+	return runtime.NewValue(map[string]any{"name": "app", "version": "1.0", "services": services})
+}
+
+func BenchmarkEvalDocuments(b *testing.B) {
+	doc, err := parser.New(benchmarkSource, "bench.helmtk").Parse()
+	if err != nil {
+		b.Fatal(err)
+	}
+	values := benchmarkValues()
+
+	for b.Loop() {
+		root := runtime.NewScope(nil)
+		root.SetGlobal("Values", values)
+		if _, err := EvalDocuments(doc, root); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProgramRun(b *testing.B) {
+	doc, err := parser.New(benchmarkSource, "bench.helmtk").Parse()
+	if err != nil {
+		b.Fatal(err)
+	}
+	prog, err := Compile(doc, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	values := benchmarkValues()
+
+	for b.Loop() {
+		if _, err := prog.Run(values); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProgramRunParallel(b *testing.B) {
+	doc, err := parser.New(benchmarkSource, "bench.helmtk").Parse()
+	if err != nil {
+		b.Fatal(err)
+	}
+	prog, err := Compile(doc, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	values := benchmarkValues()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := prog.Run(values); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
 }
@@ -0,0 +1,116 @@
+package eval
+
+import (
+	"helmtk.dev/code/htkl/parser"
+	"helmtk.dev/code/htkl/runtime"
+)
+
+// foldConstants replaces operators and interpolations whose operands are
+// all literals with the literal they evaluate to, so they aren't worked
+// out again on every run. Expressions that fail to evaluate are left
+// alone, to report their error when they're reached.
+func foldConstants(doc *parser.Document) {
+	fold := func(node parser.Node) bool {
+		switch n := node.(type) {
+		case *parser.KeyValueStatement:
+			n.Value = foldValue(n.Value)
+		case *parser.LetStatement:
+			n.Value = foldValue(n.Value)
+		case *parser.AssignmentStatement:
+			n.Value = foldValue(n.Value)
+		case *parser.IfStatement:
+			n.Condition = foldExpression(n.Condition)
+		case *parser.CallExpression:
+			for i, arg := range n.Args {
+				n.Args[i] = foldExpression(arg)
+			}
+		case *parser.Array:
+			foldList(n.Body)
+		}
+		return true
+	}
+
+	parser.InspectDocument(doc, fold)
+}
+
+// foldValue folds the value of a key or variable when it's an expression
+func foldValue(val parser.ValueStatement) parser.ValueStatement {
+	expr, ok := val.(parser.Expression)
+	if !ok {
+		return val
+	}
+	if folded, ok := foldExpression(expr).(parser.ValueStatement); ok {
+		return folded
+	}
+	return val
+}
+
+// foldList folds the expressions in a list of nodes, like array elements
+func foldList(nodes []parser.Node) {
+	for i, node := range nodes {
+		if expr, ok := node.(parser.Expression); ok {
+			nodes[i] = foldExpression(expr)
+		}
+	}
+}
+
+// foldExpression returns the literal that expr evaluates to if it's
+// constant, and expr itself otherwise
+func foldExpression(expr parser.Expression) parser.Expression {
+	switch n := expr.(type) {
+	case *parser.BinaryOp:
+		n.Left = foldExpression(n.Left)
+		n.Right = foldExpression(n.Right)
+		if !isLiteral(n.Left) || !isLiteral(n.Right) {
+			return expr
+		}
+	case *parser.UnaryOp:
+		n.Operand = foldExpression(n.Operand)
+		if !isLiteral(n.Operand) {
+			return expr
+		}
+	case *parser.InterpolatedString:
+		for i, part := range n.Parts {
+			n.Parts[i] = foldExpression(part)
+			if !isLiteral(n.Parts[i]) {
+				return expr
+			}
+		}
+	default:
+		return expr
+	}
+
+	e := &evaluator{
+		scope: runtime.NewScope(nil),
+		state: &evalState{options: defaultOptions()},
+	}
+	val, err := e.evalExpression(expr)
+	if err != nil {
+		return expr
+	}
+	return literal(val, expr.GetPos(), expr)
+}
+
+func isLiteral(expr parser.Expression) bool {
+	switch expr.(type) {
+	case *parser.StringLiteral, *parser.NumberLiteral, *parser.BooleanLiteral, *parser.NullLiteral:
+		return true
+	}
+	return false
+}
+
+// literal returns the literal node for a scalar value, or fallback for
+// values that have no literal form
+func literal(val runtime.Value, pos parser.Pos, fallback parser.Expression) parser.Expression {
+	switch v := val.(type) {
+	case *runtime.StringValue:
+		return &parser.StringLiteral{Value: v.Value, Pos: pos}
+	case *runtime.NumberValue:
+		return &parser.NumberLiteral{Value: v.Value, Pos: pos}
+	case *runtime.BoolValue:
+		return &parser.BooleanLiteral{Value: v.Value, Pos: pos}
+	case *runtime.NullValue:
+		return &parser.NullLiteral{Pos: pos}
+	}
+	return fallback
+}
@@ -0,0 +1,126 @@
+package eval
+
+import (
+	"helmtk.dev/code/htkl/parser"
+	"helmtk.dev/code/htkl/runtime"
+)
+
+// evalMatchStatement evaluates the body of the first matching case.
+// When no case matches, nothing is emitted.
+func (e *evaluator) evalMatchStatement(n *parser.MatchStatement) error {
+	c, caseScope, err := e.selectCase(n)
+	if err != nil || c == nil {
+		return err
+	}
+
+	sub := &evaluator{scope: caseScope, coll: e.coll, state: e.state}
+	for _, item := range c.Body {
+		if err := sub.collectNode(item); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// evalMatchValue evaluates a match used as a value, which requires a case to match
+func (e *evaluator) evalMatchValue(n *parser.MatchStatement) (runtime.Value, error) {
+	c, caseScope, err := e.selectCase(n)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		subject, err := e.evalExpression(n.Subject)
+		if err != nil {
+			return nil, err
+		}
+		return nil, errorf(n.Pos, "no match case for value %s", subject)
+	}
+
+	caseEval := &evaluator{scope: caseScope, coll: e.coll, state: e.state}
+	return caseEval.collectSingleValue(c, func(sub *evaluator) error {
+		for _, item := range c.Body {
+			if err := sub.collectNode(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// selectCase finds the first case whose pattern and guard match the subject.
+// It returns the case along with a scope holding the pattern's bindings, or
+// a nil case when nothing matches.
+func (e *evaluator) selectCase(n *parser.MatchStatement) (*parser.MatchCase, *runtime.Scope, error) {
+	subject, err := e.evalExpression(n.Subject)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, c := range n.Cases {
+		for _, pat := range c.Patterns {
+			caseScope := runtime.NewScope(e.scope)
+
+			ok, err := e.matchPattern(pat, subject, caseScope)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !ok {
+				continue
+			}
+
+			if c.Guard != nil {
+				guard := &evaluator{scope: caseScope, coll: e.coll, state: e.state}
+				cond, err := guard.evalExpression(c.Guard)
+				if err != nil {
+					return nil, nil, err
+				}
+				if !cond.IsTruthy() {
+					continue
+				}
+			}
+
+			return c, caseScope, nil
+		}
+	}
+
+	return nil, nil, nil
+}
+
+// matchPattern reports whether val matches pat, binding any names into scope
+func (e *evaluator) matchPattern(pat parser.Pattern, val runtime.Value, scope *runtime.Scope) (bool, error) {
+	switch p := pat.(type) {
+	case *parser.LiteralPattern:
+		lit, err := e.evalExpression(p.Value)
+		if err != nil {
+			return false, err
+		}
+		return runtime.Equal(lit, val), nil
+
+	case *parser.BindingPattern:
+		if !p.IsWildcard() {
+			scope.Set(p.Name, val)
+		}
+		return true, nil
+
+	case *parser.ObjectPattern:
+		obj, ok := val.(*runtime.ObjectValue)
+		if !ok {
+			return false, nil
+		}
+		for _, field := range p.Fields {
+			fieldVal, ok := obj.Get(field.Key)
+			if !ok {
+				return false, nil
+			}
+			ok, err := e.matchPattern(field.Pattern, fieldVal, scope)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+
+	default:
+		return false, errorf(pat.GetPos(), "unsupported pattern: %T", pat)
+	}
+}
@@ -0,0 +1,60 @@
+package eval
+
+import "context"
+
+// Option configures how a document is evaluated
+type Option func(*options)
+
+type options struct {
+	ctx             context.Context
+	collectFailures bool
+	maxIncludeDepth int
+	trackPositions  bool
+}
+
+// DefaultMaxIncludeDepth is the default limit on nested includes
+const DefaultMaxIncludeDepth = 100
+
+func defaultOptions() options {
+	return options{
+		maxIncludeDepth: DefaultMaxIncludeDepth,
+	}
+}
+
+// CollectFailures makes failed assert and fail statements record their error
+// and let evaluation continue, instead of stopping at the first failure.
+// All failures are then returned together as AssertionErrors.
+func CollectFailures() Option {
+	return func(o *options) {
+		o.collectFailures = true
+	}
+}
+
+// MaxIncludeDepth limits how deeply includes can nest, so recursive
+// templates that never stop fail with an error showing the include cycle.
+// Values below 1 keep the default of DefaultMaxIncludeDepth.
+func MaxIncludeDepth(depth int) Option {
+	return func(o *options) {
+		if depth > 0 {
+			o.maxIncludeDepth = depth
+		}
+	}
+}
+
+// TrackPositions records where each object field was set in the source, in
+// the Positions of the objects evaluated, so that problems found in the
+// output can be traced back to the template that produced them
+func TrackPositions() Option {
+	return func(o *options) {
+		o.trackPositions = true
+	}
+}
+
+// Context makes evaluation stop with the error of ctx once it's done. It's
+// checked as loops iterate and templates are included, so that a single
+// document that takes long to evaluate stops as well.
+func Context(ctx context.Context) Option {
+	return func(o *options) {
+		o.ctx = ctx
+	}
+}
@@ -0,0 +1,298 @@
+package eval
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+
+	"helmtk.dev/code/htkl/internal/suggest"
+	"helmtk.dev/code/htkl/parser"
+	"helmtk.dev/code/htkl/runtime"
+	"helmtk.dev/code/htkl/types"
+)
+
+// Diagnostic is a problem found in a document without evaluating it
+type Diagnostic struct {
+	Message string
+	Pos     parser.Pos
+	Warning bool // Whether the document can still be evaluated
+}
+
+func (d Diagnostic) String() string {
+	if d.Pos.Filename != "" {
+		return fmt.Sprintf("%s:%d:%d: %s", d.Pos.Filename, d.Pos.Line, d.Pos.Col, d.Message)
+	}
+	return fmt.Sprintf("%d:%d: %s", d.Pos.Line, d.Pos.Col, d.Message)
+}
+
+// Resolve checks the names a document refers to before it's evaluated, so
+// that mistakes in branches that rarely run are found up front. It reports
+// undefined variables, functions, templates and types as errors, and let
+// statements that shadow another variable as warnings.
+//
+// Names are looked up in the document and in scope, which is the scope the
+// document will be evaluated with. Globals lists the names of variables
+// that will be defined by then but aren't in scope yet, like Values for a
+// compiled program.
+//
+// Templates without a parameter list take their variables from the
+// context they're included with, so undefined variables can't be told
+// apart from context fields inside them, and aren't reported.
+func Resolve(doc *parser.Document, scope *runtime.Scope, globals ...string) []Diagnostic {
+	r := &resolver{
+		funcs:     make(map[string]bool),
+		templates: make(map[string]bool),
+		types:     make(map[string]bool),
+	}
+	for _, name := range scope.Env().FunctionNames() {
+		r.funcs[name] = true
+	}
+	for name := range builtins {
+		r.funcs[name] = true
+	}
+	for _, tmpl := range scope.Templates() {
+		r.templates[tmpl.Name] = true
+	}
+	for _, def := range doc.Definitions {
+		r.templates[def.Name] = true
+	}
+	for _, name := range types.BuiltinNames() {
+		r.types[name] = true
+	}
+	for _, name := range scope.Env().TypeNames() {
+		r.types[name] = true
+	}
+	for _, decl := range doc.Types {
+		r.types[decl.Name] = true
+	}
+
+	root := &resolveScope{names: make(map[string]parser.Pos)}
+	for _, name := range scope.Names() {
+		root.names[name] = parser.Pos{}
+	}
+	for _, name := range globals {
+		root.names[name] = parser.Pos{}
+	}
+
+	for _, decl := range doc.Types {
+		r.resolveType(decl.Type)
+	}
+	r.root = root
+	walkScopes(doc, r)
+
+	sortDiagnostics(r.diags)
+	return r.diags
+}
+
+// sortDiagnostics orders diagnostics by their position
+func sortDiagnostics(diags []Diagnostic) {
+	slices.SortStableFunc(diags, func(a, b Diagnostic) int {
+		return cmp.Or(
+			cmp.Compare(a.Pos.Filename, b.Pos.Filename),
+			cmp.Compare(a.Pos.Line, b.Pos.Line),
+			cmp.Compare(a.Pos.Col, b.Pos.Col),
+		)
+	})
+}
+
+type resolver struct {
+	funcs     map[string]bool
+	templates map[string]bool
+	types     map[string]bool
+	guarded   []string // Templates checked with templateExists by enclosing ifs
+	root      *resolveScope
+	blocks    []resolveBlock // Innermost last
+	diags     []Diagnostic
+}
+
+// resolveBlock is a block the resolver is in, with the number of guarded
+// templates to go back to when it's left
+type resolveBlock struct {
+	scope   *resolveScope
+	guarded int
+}
+
+// resolveScope tracks the variables declared in a block, mirroring the
+// scopes the evaluator creates
+type resolveScope struct {
+	parent  *resolveScope
+	names   map[string]parser.Pos // Declared names, with where they were declared
+	dynamic bool                  // Names may come from a template's context object
+	lenient bool                  // Undefined names are recovered from by a try expression
+	object  bool                  // Inside an object literal, where self is defined
+}
+
+func (s *resolveScope) child() *resolveScope {
+	return &resolveScope{parent: s, dynamic: s.dynamic, lenient: s.lenient, object: s.object}
+}
+
+// view returns a scope that shares the variables of this one, for blocks
+// that the evaluator doesn't give a scope of their own
+func (s *resolveScope) view() *resolveScope {
+	if s.names == nil {
+		s.names = make(map[string]parser.Pos)
+	}
+	view := *s
+	return &view
+}
+
+func (s *resolveScope) declare(name string, pos parser.Pos) {
+	if s.names == nil {
+		s.names = make(map[string]parser.Pos)
+	}
+	s.names[name] = pos
+}
+
+// lookup finds where a name was declared. Names from the scope a document is
+// evaluated with have a zero position.
+func (s *resolveScope) lookup(name string) (parser.Pos, bool) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if pos, ok := scope.names[name]; ok {
+			return pos, true
+		}
+	}
+	return parser.Pos{}, false
+}
+
+// visible returns every name visible from the scope
+func (s *resolveScope) visible() []string {
+	names := make(map[string]bool)
+	for scope := s; scope != nil; scope = scope.parent {
+		for name := range scope.names {
+			names[name] = true
+		}
+	}
+	return slices.Collect(maps.Keys(names))
+}
+
+func (r *resolver) report(pos parser.Pos, warning bool, format string, args ...any) {
+	r.diags = append(r.diags, Diagnostic{Message: fmt.Sprintf(format, args...), Pos: pos, Warning: warning})
+}
+
+// scope returns the scope of the innermost block
+func (r *resolver) scope() *resolveScope {
+	if len(r.blocks) == 0 {
+		return r.root
+	}
+	return r.blocks[len(r.blocks)-1].scope
+}
+
+func (r *resolver) enter(kind blockKind, node parser.Node) {
+	parent := r.scope()
+	var scope *resolveScope
+	switch kind {
+	case thenBlock:
+		scope = parent.view()
+	case objectBlock:
+		// Object bodies are evaluated in the enclosing scope, with self
+		// defined as well
+		scope = parent.view()
+		scope.object = true
+	case tryBlock:
+		scope = parent.view()
+		scope.lenient = true
+	default:
+		scope = parent.child()
+	}
+
+	guarded := len(r.guarded)
+	switch n := node.(type) {
+	case *parser.Definition:
+		scope.dynamic = n.Params == nil
+		for _, param := range n.Params {
+			r.resolveType(param.Type)
+		}
+	case *parser.IfStatement:
+		// Includes of templates that are checked for first may be missing
+		r.guarded = append(r.guarded, existsChecks(n.Condition)...)
+	}
+	r.blocks = append(r.blocks, resolveBlock{scope, guarded})
+}
+
+func (r *resolver) leave() {
+	block := r.blocks[len(r.blocks)-1]
+	r.blocks = r.blocks[:len(r.blocks)-1]
+	r.guarded = r.guarded[:block.guarded]
+}
+
+func (r *resolver) declare(name string, pos parser.Pos, let bool) {
+	scope := r.scope()
+	if let {
+		if prev, ok := scope.lookup(name); ok {
+			if prev.Line > 0 {
+				r.report(pos, true, "let %s shadows the variable declared at line %d", name, prev.Line)
+			} else {
+				r.report(pos, true, "let %s shadows a variable of the same name", name)
+			}
+		}
+	}
+	scope.declare(name, pos)
+}
+
+func (r *resolver) variable(id *parser.Identifier) {
+	r.resolveIdentifier(id, r.scope())
+}
+
+func (r *resolver) function(name string, pos parser.Pos) {
+	r.resolveFunction(name, pos)
+}
+
+func (r *resolver) visit(node parser.Node) {
+	switch n := node.(type) {
+	case *parser.LetStatement:
+		r.resolveType(n.Type)
+	case *parser.IsExpression:
+		r.resolveType(n.Type)
+	case *parser.IncludeExpression:
+		if n.NameExpr == nil && !r.templates[n.Name] && !slices.Contains(r.guarded, n.Name) {
+			r.report(n.Pos, false, "%s", suggest.Hint(fmt.Sprintf("undefined template: %s", n.Name), n.Name, slices.Collect(maps.Keys(r.templates))))
+		}
+	}
+}
+
+func (r *resolver) resolveIdentifier(n *parser.Identifier, scope *resolveScope) {
+	if _, ok := scope.lookup(n.Name); ok {
+		return
+	}
+	if scope.dynamic || scope.lenient || (n.Name == "self" && scope.object) {
+		return
+	}
+	r.report(n.Pos, false, "%s", suggest.Hint(fmt.Sprintf("undefined variable: %s", n.Name), n.Name, scope.visible()))
+}
+
+func (r *resolver) resolveFunction(name string, pos parser.Pos) {
+	if r.funcs[name] {
+		return
+	}
+	r.report(pos, false, "%s", suggest.Hint(fmt.Sprintf("undefined function: %s", name), name, slices.Collect(maps.Keys(r.funcs))))
+}
+
+// resolveType checks the names of the types a type annotation refers to
+func (r *resolver) resolveType(expr parser.TypeExpr) {
+	parser.Inspect(expr, func(node parser.Node) bool {
+		if named, ok := node.(*parser.NamedType); ok && !r.types[named.Name] {
+			r.report(named.Pos, false, "%s", suggest.Hint(fmt.Sprintf("undefined type: %s", named.Name), named.Name, slices.Collect(maps.Keys(r.types))))
+		}
+		return true
+	})
+}
+
+// existsChecks returns the template names that a condition checks for
+// with templateExists
+func existsChecks(cond parser.Expression) []string {
+	var names []string
+	parser.Inspect(cond, func(node parser.Node) bool {
+		call, ok := node.(*parser.CallExpression)
+		if !ok || len(call.Args) != 1 {
+			return true
+		}
+		if fn, ok := call.Function.(*parser.Identifier); ok && fn.Name == "templateExists" {
+			if name, ok := call.Args[0].(*parser.StringLiteral); ok {
+				names = append(names, name.Value)
+			}
+		}
+		return true
+	})
+	return names
+}
@@ -0,0 +1,257 @@
+package eval
+
+import (
+	"helmtk.dev/code/htkl/parser"
+)
+
+// blockKind tells apart the blocks of a document that the evaluator gives a
+// scope of their own, or that share their enclosing scope but are special
+// in some other way
+type blockKind int
+
+const (
+	documentBlock blockKind = iota // The top-level body of a document
+	templateBlock                  // The body of a template, with its parameters
+	loopBlock                      // The filter and body of a for loop, with its variables
+	sortBlock                      // The sort expression of a for loop, with key, value and the loop variables
+	withBlock                      // The body of a with statement, with its variable
+	caseBlock                      // A case of a match statement, with the variables its patterns bind
+	outputBlock                    // The body of a document block
+	contentBlock                   // A content block or named slot of an include
+	catchBlock                     // The fallback of a try expression, with its error variable
+	thenBlock                      // The body of an if statement, which shares the enclosing scope
+	objectBlock                    // An object literal, which shares the enclosing scope and defines self
+	tryBlock                       // The body of a try expression, which shares the enclosing scope
+)
+
+// scopeVisitor is told about the blocks, variables and names of a document
+// as walkScopes goes through it. The walk follows the scoping rules of the
+// evaluator, so that the passes that work on variables before evaluating
+// don't each need a copy of them.
+type scopeVisitor interface {
+	// enter opens a block for node, which is the *parser.Document,
+	// *parser.Definition or *parser.MatchCase, or else the statement or
+	// expression the block belongs to. Leave closes the innermost block.
+	enter(kind blockKind, node parser.Node)
+	leave()
+	// declare adds a variable to the innermost block. Let is set for let
+	// statements, rather than parameters, loop variables and bindings.
+	declare(name string, pos parser.Pos, let bool)
+	// variable is called with the identifiers that are looked up as
+	// variables, and function with the names of the functions called
+	variable(id *parser.Identifier)
+	function(name string, pos parser.Pos)
+	// visit is called with each statement and expression before the
+	// nodes in it
+	visit(node parser.Node)
+}
+
+// walkScopes goes through the templates and body of a document, telling v
+// about them. Templates come first, each in a block of its own.
+func walkScopes(doc *parser.Document, v scopeVisitor) {
+	w := &scopeWalker{v: v}
+	for _, def := range doc.Definitions {
+		v.enter(templateBlock, def)
+		for _, param := range def.Params {
+			w.expression(param.Default)
+			v.declare(param.Name, param.Pos, false)
+		}
+		w.list(def.Body)
+		v.leave()
+	}
+
+	v.enter(documentBlock, doc)
+	for _, stmt := range doc.Body {
+		w.node(stmt)
+	}
+	v.leave()
+}
+
+type scopeWalker struct {
+	v scopeVisitor
+}
+
+func (w *scopeWalker) list(nodes []parser.Node) {
+	for _, node := range nodes {
+		w.node(node)
+	}
+}
+
+func (w *scopeWalker) node(node parser.Node) {
+	if node == nil {
+		return
+	}
+	if expr, ok := node.(parser.Expression); ok {
+		w.expression(expr)
+		return
+	}
+
+	v := w.v
+	v.visit(node)
+	switch n := node.(type) {
+	case *parser.KeyValueStatement:
+		w.value(n.Value)
+	case *parser.LetStatement:
+		w.value(n.Value)
+		v.declare(n.Name, n.Pos, true)
+	case *parser.AssignmentStatement:
+		w.expression(n.Target)
+		w.value(n.Value)
+	case *parser.IfStatement:
+		w.expression(n.Condition)
+		v.enter(thenBlock, n)
+		w.list(n.Body)
+		v.leave()
+		w.list(n.Else)
+	case *parser.ForStatement:
+		w.expression(n.Iterable)
+		if n.Sort != nil {
+			v.enter(sortBlock, n)
+			v.declare("key", n.Sort.Pos, false)
+			v.declare("value", n.Sort.Pos, false)
+			w.loopVars(n)
+			w.expression(n.Sort.By)
+			v.leave()
+		}
+		// The filter is evaluated in a scope like the body's, holding only
+		// the loop variables
+		v.enter(loopBlock, n)
+		w.loopVars(n)
+		v.declare("loop", n.Pos, false)
+		w.expression(n.Filter)
+		w.list(n.Body)
+		v.leave()
+		w.list(n.Else)
+	case *parser.WithStatement:
+		w.expression(n.Context)
+		v.enter(withBlock, n)
+		v.declare(n.VarName, n.Pos, false)
+		w.list(n.Body)
+		v.leave()
+		w.list(n.Else)
+	case *parser.MatchStatement:
+		w.expression(n.Subject)
+		for _, c := range n.Cases {
+			v.enter(caseBlock, c)
+			for _, pattern := range c.Patterns {
+				w.pattern(pattern)
+			}
+			w.expression(c.Guard)
+			w.list(c.Body)
+			v.leave()
+		}
+	case *parser.SpreadStatement:
+		w.value(n.Operand)
+	case *parser.AssertStatement:
+		w.expression(n.Condition)
+		w.expression(n.Message)
+	case *parser.FailStatement:
+		w.expression(n.Message)
+	case *parser.DocumentBlock:
+		w.expression(n.Name)
+		w.expression(n.Weight)
+		v.enter(outputBlock, n)
+		w.list(n.Body)
+		v.leave()
+	}
+}
+
+func (w *scopeWalker) loopVars(n *parser.ForStatement) {
+	if n.KeyVar != "" {
+		w.v.declare(n.KeyVar, n.Pos, false)
+	}
+	w.v.declare(n.ValueVar, n.Pos, false)
+}
+
+func (w *scopeWalker) value(val parser.ValueStatement) {
+	if node, ok := val.(parser.Node); ok {
+		w.node(node)
+	}
+}
+
+func (w *scopeWalker) pattern(pattern parser.Pattern) {
+	switch p := pattern.(type) {
+	case *parser.LiteralPattern:
+		w.expression(p.Value)
+	case *parser.BindingPattern:
+		if !p.IsWildcard() {
+			w.v.declare(p.Name, p.Pos, false)
+		}
+	case *parser.ObjectPattern:
+		for _, field := range p.Fields {
+			w.pattern(field.Pattern)
+		}
+	}
+}
+
+func (w *scopeWalker) expression(expr parser.Expression) {
+	if expr == nil {
+		return
+	}
+
+	v := w.v
+	v.visit(expr)
+	switch n := expr.(type) {
+	case *parser.Identifier:
+		v.variable(n)
+	case *parser.InterpolatedString:
+		for _, part := range n.Parts {
+			w.expression(part)
+		}
+	case *parser.MemberExpression:
+		w.expression(n.Object)
+	case *parser.IndexExpression:
+		w.expression(n.Object)
+		w.expression(n.Index)
+	case *parser.BinaryOp:
+		w.expression(n.Left)
+		// The right side of a pipe names a function, or calls one with
+		// more arguments
+		if fn, ok := n.Right.(*parser.Identifier); ok && n.Operator == "|" {
+			v.function(fn.Name, fn.Pos)
+		} else {
+			w.expression(n.Right)
+		}
+	case *parser.UnaryOp:
+		w.expression(n.Operand)
+	case *parser.CallExpression:
+		if fn, ok := n.Function.(*parser.Identifier); ok {
+			v.function(fn.Name, fn.Pos)
+		}
+		for _, arg := range n.Args {
+			w.expression(arg)
+		}
+	case *parser.Object:
+		v.enter(objectBlock, n)
+		w.list(n.Body)
+		v.leave()
+	case *parser.Array:
+		w.list(n.Body)
+	case *parser.IncludeExpression:
+		w.expression(n.NameExpr)
+		w.expression(n.Context)
+		// Content blocks are rendered in a child of the including scope
+		v.enter(contentBlock, n)
+		w.list(n.Block)
+		v.leave()
+		for _, slot := range n.Slots {
+			v.enter(contentBlock, n)
+			w.list(slot.Body)
+			v.leave()
+		}
+	case *parser.SuperExpression:
+		w.expression(n.Context)
+	case *parser.IsExpression:
+		w.expression(n.Value)
+	case *parser.TryExpression:
+		v.enter(tryBlock, n)
+		w.expression(n.Body)
+		v.leave()
+		v.enter(catchBlock, n)
+		if n.ErrorVar != "" {
+			v.declare(n.ErrorVar, n.Pos, false)
+		}
+		w.expression(n.Fallback)
+		v.leave()
+	}
+}
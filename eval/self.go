@@ -0,0 +1,310 @@
+package eval
+
+import (
+	"slices"
+	"strings"
+
+	"helmtk.dev/code/htkl/parser"
+	"helmtk.dev/code/htkl/runtime"
+)
+
+// selfObject is an object literal whose body uses self. Its statements are
+// evaluated on demand: reading a field through self first evaluates the
+// statements that may set it, wherever they are in the body. Statements
+// that don't use self run in source order, and those that do once the rest
+// of the body is done, unless they're needed earlier. Each statement
+// collects into an object of its own, and the literal's value merges those
+// in source order, so later statements override earlier ones whatever
+// order they ran in.
+//
+// A field can't refer to itself, so self.name in the value of name refers
+// to the name of an enclosing object, and so does self.name in an object
+// that doesn't set name at all.
+type selfObject struct {
+	obj     *runtime.ObjectValue // The merged value, complete once the body is done
+	stmts   []*selfStatement
+	forcing []*selfStatement // Statements being evaluated, innermost last
+}
+
+// selfStatement is a statement of an object body that uses self
+type selfStatement struct {
+	stmt       parser.Statement
+	keys       []string             // Keys the statement may set
+	anyKey     bool                 // Whether it may set keys that can't be told up front
+	deferred   bool                 // Whether it waits for the rest of the body
+	eval       *evaluator           // Evaluates the statement into part
+	selves     []*selfObject        // The self stack where the statement is
+	part       *runtime.ObjectValue // Fields the statement set
+	label      string               // Name of the statement in circular reference errors
+	evaluating bool
+	done       bool
+}
+
+// newSelfObject returns a selfObject for an object literal, or nil when
+// neither its body nor the objects nested in it use self. The literal must
+// be on top of the self stack before its statements are evaluated.
+func newSelfObject(obj *runtime.ObjectValue, body []parser.Node) *selfObject {
+	if !slices.ContainsFunc(body, refersToSelf) {
+		return nil
+	}
+	return &selfObject{obj: obj}
+}
+
+// refersToSelf reports whether a node uses self, including in the object
+// literals nested in it, which can refer to the fields of the objects
+// around them
+func refersToSelf(node parser.Node) bool {
+	found := false
+	parser.Inspect(node, func(node parser.Node) bool {
+		if id, ok := node.(*parser.Identifier); ok && id.Name == "self" {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// evalBody evaluates the statements of the object's body and merges the
+// fields they set
+func (s *selfObject) evalBody(body []parser.Node, e *evaluator) error {
+	for _, item := range body {
+		stmt, ok := item.(parser.Statement)
+		if !ok {
+			return errorf(item.GetPos(), "unsupported node: %T", item)
+		}
+		st := &selfStatement{
+			stmt:   stmt,
+			part:   &runtime.ObjectValue{},
+			selves: slices.Clip(e.state.selves),
+		}
+		st.eval = &evaluator{scope: e.scope, coll: st.part, state: e.state}
+		st.keys, st.anyKey = keysOf(stmt)
+		// Statements that bind variables run in order, since later
+		// statements may use them
+		st.deferred = (st.anyKey || len(st.keys) > 0) && refersToSelf(stmt)
+		s.stmts = append(s.stmts, st)
+	}
+
+	for _, st := range s.stmts {
+		if !st.deferred {
+			if err := s.run(st, ""); err != nil {
+				return err
+			}
+		}
+	}
+	for i, st := range s.stmts {
+		if st.done {
+			continue
+		}
+		// A field replaced by a later one is never evaluated
+		if kv, ok := st.stmt.(*parser.KeyValueStatement); ok && s.setLater(i, kv.Key) {
+			st.done = true
+			continue
+		}
+		if err := s.run(st, ""); err != nil {
+			return err
+		}
+	}
+
+	*s.obj = *s.merged()
+	return nil
+}
+
+// setLater reports whether a key-value statement after the i'th statement
+// sets key
+func (s *selfObject) setLater(i int, key string) bool {
+	return slices.ContainsFunc(s.stmts[i+1:], func(st *selfStatement) bool {
+		kv, ok := st.stmt.(*parser.KeyValueStatement)
+		return ok && kv.Key == key
+	})
+}
+
+// run evaluates a statement, unless it already was. Key is the field it's
+// evaluated for, if any.
+func (s *selfObject) run(st *selfStatement, key string) error {
+	if st.done {
+		return nil
+	}
+	st.label = statementLabel(st.stmt, key)
+	st.evaluating = true
+	s.forcing = append(s.forcing, st)
+
+	// Evaluate with the self stack from where the statement is
+	state := st.eval.state
+	saved := state.selves
+	state.selves = st.selves
+	err := st.eval.evalStatement(st.stmt)
+	state.selves = saved
+
+	s.forcing = s.forcing[:len(s.forcing)-1]
+	st.evaluating = false
+	st.done = err == nil
+	return err
+}
+
+// lookup finds the value that the last statement setting key gives it,
+// evaluating statements as needed. It reports false when no statement sets
+// key. The statement being evaluated is passed over, since a field can't
+// refer to itself.
+func (s *selfObject) lookup(key string) (runtime.Value, bool, error) {
+	for i := len(s.stmts) - 1; i >= 0; i-- {
+		st := s.stmts[i]
+		if !st.anyKey && !slices.Contains(st.keys, key) {
+			continue
+		}
+		if st.evaluating {
+			if st == s.current() {
+				continue
+			}
+			start := slices.Index(s.forcing, st)
+			var cycle []string
+			for _, f := range s.forcing[start:] {
+				cycle = append(cycle, f.label)
+			}
+			cycle = append(cycle, key)
+			return nil, false, errorf(st.stmt.GetPos(), "circular field reference: %s", strings.Join(cycle, " -> "))
+		}
+		if err := s.run(st, key); err != nil {
+			return nil, false, err
+		}
+		if val, ok := st.part.Get(key); ok {
+			return val, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// current returns the statement being evaluated, if any
+func (s *selfObject) current() *selfStatement {
+	if len(s.forcing) == 0 {
+		return nil
+	}
+	return s.forcing[len(s.forcing)-1]
+}
+
+// all evaluates every statement that isn't being evaluated, and returns a
+// copy of the object with the fields set so far. The statements being
+// evaluated are left out, so that a field can't hold the object it's in
+// (e.g., me: self).
+func (s *selfObject) all() (*runtime.ObjectValue, error) {
+	for _, st := range s.stmts {
+		if st.evaluating {
+			continue
+		}
+		if err := s.run(st, ""); err != nil {
+			return nil, err
+		}
+	}
+	return s.merged(), nil
+}
+
+// merged returns an object with the fields of the statements that are
+// done, in source order
+func (s *selfObject) merged() *runtime.ObjectValue {
+	obj := &runtime.ObjectValue{}
+	for _, st := range s.stmts {
+		if !st.done {
+			continue
+		}
+		for k, v := range st.part.Fields {
+			if st.part.IsHidden(k) {
+				obj.SetHidden(k, v)
+			} else {
+				obj.Set(k, v)
+			}
+			if pos, ok := st.part.Pos(k); ok {
+				obj.SetPos(k, pos)
+			}
+		}
+	}
+	return obj
+}
+
+// keysOf returns the keys a statement of an object body may set. Dynamic
+// is true when it may set keys that can't be told without evaluating it,
+// like those of a spread or an include.
+func keysOf(node parser.Node) (keys []string, dynamic bool) {
+	add := func(nodes []parser.Node) {
+		for _, n := range nodes {
+			k, a := keysOf(n)
+			keys = append(keys, k...)
+			dynamic = dynamic || a
+		}
+	}
+
+	switch n := node.(type) {
+	case *parser.KeyValueStatement:
+		return []string{n.Key}, false
+	case *parser.LetStatement, *parser.AssignmentStatement, *parser.AssertStatement,
+		*parser.FailStatement, *parser.BreakStatement, *parser.ContinueStatement, *parser.Comment:
+		return nil, false
+	case *parser.IfStatement:
+		add(n.Body)
+		add(n.Else)
+	case *parser.ForStatement:
+		add(n.Body)
+		add(n.Else)
+	case *parser.WithStatement:
+		add(n.Body)
+		add(n.Else)
+	case *parser.MatchStatement:
+		for _, c := range n.Cases {
+			add(c.Body)
+		}
+	default:
+		return nil, true
+	}
+	return keys, dynamic
+}
+
+// statementLabel names a statement in circular reference errors: by its
+// key, or by the key it was evaluated for
+func statementLabel(stmt parser.Statement, key string) string {
+	if kv, ok := stmt.(*parser.KeyValueStatement); ok {
+		return kv.Key
+	}
+	if key != "" {
+		return key
+	}
+	return "self"
+}
+
+// currentSelf returns the innermost object being evaluated with self
+// references, if any
+func (e *evaluator) currentSelf() *selfObject {
+	if len(e.state.selves) == 0 {
+		return nil
+	}
+	return e.state.selves[len(e.state.selves)-1]
+}
+
+// isSelf reports whether an expression is self inside an object literal
+// with self references
+func (e *evaluator) isSelf(expr parser.Expression) bool {
+	id, ok := expr.(*parser.Identifier)
+	return ok && id.Name == "self" && e.currentSelf() != nil
+}
+
+// selfField reads a field through self (e.g., self.name or self["name"])
+// from the innermost object that sets it. It reports false when no object
+// does.
+func (e *evaluator) selfField(key string) (runtime.Value, bool, error) {
+	selves := e.state.selves
+	for i := len(selves) - 1; i >= 0; i-- {
+		val, ok, err := selves[i].lookup(key)
+		if err != nil || ok {
+			return val, ok, err
+		}
+	}
+
+	// A field that refers to itself, with no other field of its name to
+	// refer to, is circular
+	for i := len(selves) - 1; i >= 0; i-- {
+		if st := selves[i].current(); st != nil {
+			if kv, ok := st.stmt.(*parser.KeyValueStatement); ok && kv.Key == key {
+				return nil, false, errorf(kv.Pos, "circular field reference: %s -> %s", key, key)
+			}
+		}
+	}
+	return nil, false, nil
+}
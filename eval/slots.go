@@ -0,0 +1,147 @@
+package eval
+
+import (
+	"helmtk.dev/code/htkl/parser"
+)
+
+// assignSlots gives the blocks of a document that the evaluator creates a
+// scope for frames listing the variables they declare, and binds the
+// identifiers that refer to those variables to their slots.
+//
+// Identifiers that can't be bound for certain are left alone, and looked
+// up by name as before: globals, variables of scopes that don't get a
+// frame, like match cases, and names in templates that take their
+// variables from the context they're included with.
+func assignSlots(doc *parser.Document) {
+	s := &slotter{}
+	walkScopes(doc, s)
+
+	// Frames are complete now, so identifiers are bound to the innermost
+	// variable of their name even when it's declared after them
+	for _, ref := range s.refs {
+		ref.ident.Binding = ref.scope.lookup(ref.ident.Name)
+	}
+}
+
+// slotScope mirrors a scope the evaluator creates
+type slotScope struct {
+	parent  *slotScope
+	frame   *parser.Frame   // Variables kept in slots, nil for scopes without a frame
+	names   map[string]bool // Variables of scopes without a frame
+	dynamic bool            // Names may come from a template's context object
+	noLoop  bool            // The loop metadata isn't used, so it isn't declared
+}
+
+func (s *slotScope) declare(name string) {
+	if s.frame == nil {
+		if s.names == nil {
+			s.names = make(map[string]bool)
+		}
+		s.names[name] = true
+		return
+	}
+	if s.frame.Index(name) < 0 {
+		s.frame.Names = append(s.frame.Names, name)
+	}
+}
+
+// lookup finds the slot of the variable a name refers to, or nil if it
+// can't be told for certain
+func (s *slotScope) lookup(name string) *parser.Binding {
+	for scope := s; scope != nil; scope = scope.parent {
+		if scope.frame != nil {
+			if i := scope.frame.Index(name); i >= 0 {
+				return &parser.Binding{Frame: scope.frame, Index: i}
+			}
+		}
+		if scope.names[name] || scope.dynamic {
+			return nil
+		}
+	}
+	return nil
+}
+
+// slotter gives frames to the blocks that the evaluator creates a scope
+// with a frame for, and collects the identifiers to bind
+type slotter struct {
+	scopes []*slotScope // Innermost last
+	refs   []slotRef
+}
+
+// slotRef is an identifier waiting to be bound, with the scope it's in
+type slotRef struct {
+	ident *parser.Identifier
+	scope *slotScope
+}
+
+func (s *slotter) enter(kind blockKind, node parser.Node) {
+	var parent *slotScope
+	if len(s.scopes) > 0 {
+		parent = s.scopes[len(s.scopes)-1]
+	}
+
+	scope := &slotScope{parent: parent}
+	switch n := node.(type) {
+	case *parser.Document:
+		n.Frame = &parser.Frame{}
+		scope.frame = n.Frame
+	case *parser.Definition:
+		n.Frame = &parser.Frame{}
+		scope.frame, scope.dynamic = n.Frame, n.Params == nil
+	case *parser.ForStatement:
+		if kind == loopBlock {
+			n.Frame = &parser.Frame{}
+			// The loop metadata is only built for loops that use it
+			scope.frame, scope.noLoop = n.Frame, !refersTo(n.Body, "loop")
+		}
+	case *parser.WithStatement:
+		n.Frame = &parser.Frame{}
+		scope.frame = n.Frame
+	case *parser.DocumentBlock:
+		n.Frame = &parser.Frame{}
+		scope.frame = n.Frame
+	}
+
+	// Blocks that share the enclosing scope
+	if kind == thenBlock || kind == objectBlock || kind == tryBlock {
+		scope = parent
+	}
+	s.scopes = append(s.scopes, scope)
+}
+
+func (s *slotter) leave() {
+	s.scopes = s.scopes[:len(s.scopes)-1]
+}
+
+func (s *slotter) declare(name string, _ parser.Pos, _ bool) {
+	scope := s.scopes[len(s.scopes)-1]
+	if name == "loop" && scope.noLoop {
+		return
+	}
+	scope.declare(name)
+}
+
+func (s *slotter) variable(id *parser.Identifier) {
+	// Inside object literals, self is the object rather than a variable
+	if id.Name != "self" {
+		s.refs = append(s.refs, slotRef{id, s.scopes[len(s.scopes)-1]})
+	}
+}
+
+func (s *slotter) function(string, parser.Pos) {}
+func (s *slotter) visit(parser.Node)           {}
+
+// refersTo reports whether an identifier with the given name appears
+// anywhere in nodes
+func refersTo(nodes []parser.Node, name string) bool {
+	found := false
+	for _, node := range nodes {
+		parser.Inspect(node, func(n parser.Node) bool {
+			if id, ok := n.(*parser.Identifier); ok && id.Name == name {
+				found = true
+			}
+			return !found
+		})
+	}
+	return found
+}
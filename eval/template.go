@@ -0,0 +1,139 @@
+package eval
+
+import (
+	"slices"
+
+	"helmtk.dev/code/htkl/parser"
+	"helmtk.dev/code/htkl/runtime"
+)
+
+// templateFrame tracks a template being rendered: the arguments it was
+// given, for super(), and the content blocks passed by the caller, along
+// with the caller's scope they are evaluated in
+type templateFrame struct {
+	tmpl  *runtime.Template
+	args  *runtime.ObjectValue
+	scope *runtime.Scope
+	block []parser.Node
+	slots map[string][]parser.Node
+}
+
+func newTemplateFrame(n *parser.IncludeExpression, tmpl *runtime.Template, args *runtime.ObjectValue, scope *runtime.Scope) *templateFrame {
+	frame := &templateFrame{tmpl: tmpl, args: args, scope: scope, block: n.Block}
+	if len(n.Slots) > 0 {
+		frame.slots = make(map[string][]parser.Node, len(n.Slots))
+		for _, slot := range n.Slots {
+			frame.slots[slot.Name] = slot.Body
+		}
+	}
+	return frame
+}
+
+// renderTemplate evaluates a template's body into the current collector
+func (e *evaluator) renderTemplate(pos parser.Pos, tmpl *runtime.Template, args *runtime.ObjectValue, frame *templateFrame) error {
+	if err := e.state.cancelled(); err != nil {
+		return err
+	}
+	includes := e.state.includes
+	if max := e.state.options.maxIncludeDepth; len(includes) >= max {
+		return &depthError{errorf(pos, "maximum include depth of %d exceeded, %s", max, includeCycle(includes, tmpl.Name))}
+	}
+	e.state.includes = append(includes, tmpl.Name)
+	defer func() {
+		e.state.includes = includes
+	}()
+
+	// Templates see the variables and environment the evaluation was
+	// started with, but not the variables of the including document
+	tmplScope := e.state.env.IsolatedFrame(tmpl.Frame)
+
+	tmplEval := &evaluator{
+		scope: tmplScope,
+		coll:  e.coll,
+		state: e.state,
+	}
+
+	if err := tmplEval.bindParams(pos, tmpl, args); err != nil {
+		return err
+	}
+
+	// Make the frame available to yield and super inside the template
+	e.state.templates = append(e.state.templates, frame)
+	defer func() {
+		e.state.templates = e.state.templates[:len(e.state.templates)-1]
+	}()
+
+	for _, node := range tmpl.Body {
+		if err := tmplEval.collectNode(node); err != nil {
+			// Keep the depth error short, rather than wrapping it once per
+			// level, and pass callback errors up as is
+			switch err.(type) {
+			case *depthError, *emitError:
+				return err
+			}
+			return errorf(pos, "include %q: %s", tmpl.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// evalSuperStatement renders the definition the current override replaced,
+// with the same arguments and content blocks unless given a new context
+func (e *evaluator) evalSuperStatement(n *parser.SuperExpression) error {
+	stack := e.state.templates
+	if len(stack) == 0 || stack[len(stack)-1].tmpl.Super == nil {
+		return errorf(n.Pos, "super() used outside of an override")
+	}
+	frame := stack[len(stack)-1]
+
+	args := frame.args
+	if n.Context != nil {
+		var err error
+		if args, err = e.templateArgs(n.Context); err != nil {
+			return err
+		}
+	}
+
+	prev := *frame
+	prev.tmpl = frame.tmpl.Super
+	prev.args = args
+	return e.renderTemplate(n.Pos, prev.tmpl, args, &prev)
+}
+
+// evalYieldStatement renders a content block of the current include. The
+// block is evaluated in the caller's scope, but collected into the
+// template's current object or array. A missing block renders nothing.
+func (e *evaluator) evalYieldStatement(n *parser.YieldStatement) error {
+	stack := e.state.templates
+	if len(stack) == 0 {
+		return errorf(n.Pos, "yield outside of a template")
+	}
+	frame := stack[len(stack)-1]
+
+	body := frame.block
+	if n.Name != "" {
+		body = frame.slots[n.Name]
+	}
+
+	// While the block runs, yields inside it refer to the caller's own
+	// template, if any. The stack is clipped so includes inside the block
+	// don't overwrite the current frame.
+	e.state.templates = slices.Clip(stack[:len(stack)-1])
+	defer func() {
+		e.state.templates = stack
+	}()
+
+	sub := &evaluator{
+		scope: runtime.NewScope(frame.scope),
+		coll:  e.coll,
+		state: e.state,
+	}
+	for _, node := range body {
+		if err := sub.collectNode(node); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
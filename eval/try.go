@@ -0,0 +1,50 @@
+package eval
+
+import (
+	"errors"
+
+	"helmtk.dev/code/htkl/parser"
+	"helmtk.dev/code/htkl/runtime"
+)
+
+// evalTryExpression evaluates the body, falling back on any evaluation error
+func (e *evaluator) evalTryExpression(n *parser.TryExpression) (runtime.Value, error) {
+	e.state.tryDepth++
+	val, err := e.evalExpression(n.Body)
+	e.state.tryDepth--
+	// Cancellation isn't an error of the body to fall back from
+	if err == nil || e.state.cancelled() != nil {
+		return val, err
+	}
+
+	fallback := e
+	if n.ErrorVar != "" {
+		scope := runtime.NewScope(e.scope)
+		scope.Set(n.ErrorVar, errorValue(n.Pos, err))
+		fallback = &evaluator{scope: scope, coll: e.coll, state: e.state}
+	}
+
+	return fallback.evalExpression(n.Fallback)
+}
+
+// errorValue converts an evaluation error into an object exposing its
+// message and position. Errors without position information are reported
+// at pos.
+func errorValue(pos parser.Pos, err error) runtime.Value {
+	obj := runtime.NewObject()
+
+	var evalErr *EvalError
+	if errors.As(err, &evalErr) {
+		obj.Set("message", runtime.NewString(evalErr.Message))
+		obj.Set("filename", runtime.NewString(evalErr.Filename))
+		obj.Set("line", runtime.NewNumber(float64(evalErr.Line)))
+		obj.Set("col", runtime.NewNumber(float64(evalErr.Col)))
+		return obj
+	}
+
+	obj.Set("message", runtime.NewString(err.Error()))
+	obj.Set("filename", runtime.NewString(pos.Filename))
+	obj.Set("line", runtime.NewNumber(float64(pos.Line)))
+	obj.Set("col", runtime.NewNumber(float64(pos.Col)))
+	return obj
+}
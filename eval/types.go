@@ -0,0 +1,51 @@
+package eval
+
+import (
+	"helmtk.dev/code/htkl/parser"
+	"helmtk.dev/code/htkl/runtime"
+	"helmtk.dev/code/htkl/types"
+)
+
+// defineTypes registers the type declarations of a document in scope
+func defineTypes(scope *runtime.Scope, decls []*parser.TypeDecl) error {
+	for _, decl := range decls {
+		if _, ok := types.Builtin(decl.Name); ok {
+			return errorf(decl.Pos, "cannot redeclare builtin type %s", decl.Name)
+		}
+		if err := scope.DefineType(decl); err != nil {
+			return wraperr(decl.Pos, err)
+		}
+	}
+	return nil
+}
+
+// resolveType converts a type annotation into a type, looking up the types
+// it refers to in the scope. Types are converted once per evaluation.
+func (e *evaluator) resolveType(expr parser.TypeExpr) (*types.Type, error) {
+	if t, ok := e.state.types[expr]; ok {
+		return t, nil
+	}
+
+	t, err := types.FromExpr(expr, e.scope.GetType)
+	if err != nil {
+		return nil, errorf(expr.GetPos(), "%s", err)
+	}
+	if e.state.types == nil {
+		e.state.types = make(map[parser.TypeExpr]*types.Type)
+	}
+	e.state.types[expr] = t
+	return t, nil
+}
+
+// evalIsExpression checks whether a value matches a type
+func (e *evaluator) evalIsExpression(n *parser.IsExpression) (runtime.Value, error) {
+	val, err := e.evalExpression(n.Value)
+	if err != nil {
+		return nil, err
+	}
+	t, err := e.resolveType(n.Type)
+	if err != nil {
+		return nil, err
+	}
+	return runtime.NewBool(t.Matches(val)), nil
+}
@@ -1,3 +1,5 @@
 module helmtk.dev/code/htkl
 
 go 1.25.4
+
+require go.yaml.in/yaml/v3 v3.0.5
@@ -0,0 +1,370 @@
+package htkl
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"helmtk.dev/code/htkl/kube"
+	"helmtk.dev/code/htkl/runtime"
+	"helmtk.dev/code/htkl/schema"
+	"helmtk.dev/code/htkl/types"
+)
+
+func TestRender(t *testing.T) {
+	src := `
+name: Values.name
+replicas: Values.replicas * 2
+greeting: shout("hi")
+hidden scratch: 1
+---
+document "service.yaml", weight = -1 do
+	kind: "Service"
+end
+`
+	shout := func(args ...runtime.Value) (runtime.Value, error) {
+		return runtime.NewString(strings.ToUpper(args[0].String()) + "!"), nil
+	}
+	values := map[string]any{"name": "web", "replicas": 2}
+
+	res, err := Render(context.Background(), src, WithValues(values), WithFunction("shout", shout))
+	if err != nil {
+		t.Fatalf("render error: %v", err)
+	}
+
+	want := "kind: Service\n---\ngreeting: HI!\nname: web\nreplicas: 4\n"
+	if got := string(res.Bytes()); got != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", got, want)
+	}
+	if res.Documents[0].Name != "service.yaml" || res.Documents[0].Source != "input.helmtk" {
+		t.Errorf("got document %q from %q, want %q from %q",
+			res.Documents[0].Name, res.Documents[0].Source, "service.yaml", "input.helmtk")
+	}
+
+	res, err = Render(context.Background(), "a: [1, 2.5]\n---\nb: true", WithFormat(FormatJSON))
+	if err != nil {
+		t.Fatalf("render error: %v", err)
+	}
+	want = "{\n  \"a\": [\n    1,\n    2.5\n  ]\n}\n{\n  \"b\": true\n}\n"
+	if got := string(res.Bytes()); got != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRenderDiagnostics(t *testing.T) {
+	res, err := Render(context.Background(), "a: missing", WithFilename("app.helmtk"))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	errs := res.Errors()
+	if len(errs) != 1 || errs[0].String() != "app.helmtk:1:4: error: undefined variable: missing" {
+		t.Errorf("got diagnostics %v", errs)
+	}
+
+	// Names are checked before evaluation, even in branches that don't run
+	res, err = Render(context.Background(), `
+let enabled = false
+if enabled do
+	name: upper(nmae)
+end
+`, WithFilename("app.helmtk"))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	var msgs []string
+	for _, d := range res.Errors() {
+		msgs = append(msgs, d.String())
+	}
+	if got, want := strings.Join(msgs, "\n"), "app.helmtk:4:8: error: undefined function: upper\napp.helmtk:4:14: error: undefined variable: nmae"; got != want {
+		t.Errorf("got diagnostics:\n%s\nwant:\n%s", got, want)
+	}
+
+	res, err = Render(context.Background(), "a: (1", WithFilename("app.helmtk"))
+	if err == nil {
+		t.Fatal("expected a parse error")
+	}
+	if errs := res.Errors(); len(errs) != 1 || errs[0].Filename != "app.helmtk" || errs[0].Line != 1 {
+		t.Errorf("got diagnostics %v", errs)
+	}
+
+	res, err = Render(context.Background(), `
+assert false, "first"
+fail("second")
+`, WithCollectFailures())
+	if err == nil {
+		t.Fatal("expected failures")
+	}
+	if errs := res.Errors(); len(errs) != 2 || errs[0].Message != "first" || errs[1].Message != "second" {
+		t.Errorf("got diagnostics %v", errs)
+	}
+
+	res, err = Render(context.Background(), `
+let x = 2
+match x do
+	case 1 => a: 1
+end
+`)
+	if err != nil {
+		t.Fatalf("render error: %v", err)
+	}
+	if warnings := res.Warnings(); len(warnings) != 1 || !strings.Contains(warnings[0].Message, "not exhaustive") {
+		t.Errorf("got warnings %v", warnings)
+	}
+}
+
+func TestTypeCheck(t *testing.T) {
+	src := `
+if Values.debug do
+	level: Values.level + 1
+end
+`
+	// Without type checking, the branch that fails isn't taken
+	values := map[string]any{"debug": false, "level": map[string]any{"name": "info"}}
+	if _, err := Render(context.Background(), src, WithValues(values)); err != nil {
+		t.Fatalf("render error: %v", err)
+	}
+
+	res, err := Render(context.Background(), src, WithValues(values), WithTypeCheck(nil), WithFilename("app.helmtk"))
+	if err == nil {
+		t.Fatal("expected a type error")
+	}
+	if errs := res.Errors(); len(errs) != 1 || errs[0].String() != "app.helmtk:3:22: error: cannot add object and number" {
+		t.Errorf("got diagnostics %v", errs)
+	}
+
+	// Compiled programs are checked against the declared type of Values
+	_, err = Compile(src, WithTypeCheck(types.ObjectOf(map[string]*types.Type{"level": types.ArrayOf(nil)})))
+	if err == nil || !strings.Contains(err.Error(), "cannot add array and number") {
+		t.Errorf("expected a type error, got %v", err)
+	}
+	if _, err := Compile(src, WithTypeCheck(nil)); err != nil {
+		t.Errorf("compile error: %v", err)
+	}
+}
+
+func TestValuesSchema(t *testing.T) {
+	s, err := schema.Parse([]byte(`{
+		"type": "object",
+		"required": ["name"],
+		"properties": {
+			"name": {"type": "string"},
+			"replicas": {"type": "integer", "default": 1}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("schema error: %v", err)
+	}
+	src := `name: Values.name
+replicas: Values.replicas`
+
+	res, err := Render(context.Background(), src, WithValues(map[string]any{"name": "web"}), WithValuesSchema(s))
+	if err != nil {
+		t.Fatalf("render error: %v", err)
+	}
+	if got, want := string(res.Bytes()), "name: web\nreplicas: 1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	res, err = Render(context.Background(), src, WithValues(map[string]any{"replicas": "two"}), WithValuesSchema(s))
+	if err == nil {
+		t.Fatal("expected invalid values")
+	}
+	var got []string
+	for _, d := range res.Errors() {
+		got = append(got, d.String())
+	}
+	want := []string{
+		"error: invalid values: /name: required property is missing",
+		"error: invalid values: /replicas: expected integer, got string",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got diagnostics %q, want %q", got, want)
+	}
+
+	// Compiled programs validate the values of each render
+	prog, err := Compile(src, WithValuesSchema(s))
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	if res, err := prog.Render(context.Background(), map[string]any{"name": "api", "replicas": 3}); err != nil {
+		t.Errorf("render error: %v", err)
+	} else if got := string(res.Bytes()); got != "name: api\nreplicas: 3\n" {
+		t.Errorf("got %q", got)
+	}
+	if _, err := prog.Render(context.Background(), nil); err == nil || !strings.Contains(err.Error(), "/name: required property is missing") {
+		t.Errorf("got error %v, want missing name", err)
+	}
+}
+
+func TestKubernetesValidation(t *testing.T) {
+	v := kube.NewValidator()
+	err := v.Load([]byte(`{
+		"swagger": "2.0",
+		"definitions": {
+			"io.k8s.api.core.v1.ConfigMap": {
+				"type": "object",
+				"properties": {
+					"apiVersion": {"type": "string"},
+					"kind": {"type": "string"},
+					"metadata": {"type": "object"},
+					"data": {"type": "object", "additionalProperties": {"type": "string"}}
+				},
+				"x-kubernetes-group-version-kind": [{"group": "", "kind": "ConfigMap", "version": "v1"}]
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+
+	src := `apiVersion: "v1"
+kind: "ConfigMap"
+metadata: {name: "config"}
+data: {port: Values.port}
+dta: {}
+---
+name: "plain"`
+	res, err := Render(context.Background(), src, WithValues(map[string]any{"port": 80}), WithKubernetesValidation(v), WithFilename("config.helmtk"))
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	var got []string
+	for _, d := range res.Diagnostics {
+		got = append(got, d.String())
+	}
+	want := []string{
+		`config.helmtk:4:8: error: data.port: expected string, got number`,
+		`config.helmtk:5:1: error: dta: unknown field, did you mean "data"?`,
+		`config.helmtk: warning: document has no apiVersion and kind, so it wasn't validated`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got diagnostics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if _, err := Render(context.Background(), src, WithValues(map[string]any{"port": "80"}), WithKubernetesValidation(v)); err == nil {
+		t.Error("expected the unknown field to still be reported")
+	}
+}
+
+func TestRenderLimits(t *testing.T) {
+	src := `for i in [1, 2, 3] do
+	document do
+		{i: i}
+	end
+end`
+	_, err := Render(context.Background(), src, WithMaxDocuments(2))
+	if err == nil || !strings.Contains(err.Error(), "more than the maximum of 2 documents") {
+		t.Errorf("got error %v, want document limit error", err)
+	}
+
+	_, err = Render(context.Background(), `
+define("loop") do
+	if n > 0 do
+		include("loop", {n: n - 1})
+	end
+end
+a: include("loop", {n: 10})
+`, WithMaxIncludeDepth(5))
+	if err == nil || !strings.Contains(err.Error(), "maximum include depth of 5 exceeded") {
+		t.Errorf("got error %v, want include depth error", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Render(ctx, src)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+
+	// A single document that would take long stops when the context is done
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	long := `items: [for i in 100000000 do try {n: i} else 0 end]`
+	_, err = Render(ctx, long)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	prog, err := Compile(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = prog.Render(ctx, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("compiled: got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRenderFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"templates/_helpers.helmtk": {Data: []byte(`define("labels") do
+	app: Values.name
+end`)},
+		"templates/deployment.helmtk": {Data: []byte(`kind: "Deployment"
+labels: {include("labels")}`)},
+		"templates/service.helmtk": {Data: []byte(`kind: "Service"`)},
+	}
+
+	res, err := RenderFiles(fsys, []string{"templates/*.helmtk", "extra/*.helmtk"},
+		WithValues(map[string]any{"name": "web"}))
+	if err != nil {
+		t.Fatalf("render error: %v", err)
+	}
+
+	want := "kind: Deployment\nlabels:\n  app: web\n---\nkind: Service\n"
+	if got := string(res.Bytes()); got != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", got, want)
+	}
+	if got := res.Documents[1].Source; got != "templates/service.helmtk" {
+		t.Errorf("got source %q, want %q", got, "templates/service.helmtk")
+	}
+	if warnings := res.Warnings(); len(warnings) != 1 || warnings[0].Message != `pattern "extra/*.helmtk" matched no files` {
+		t.Errorf("got warnings %v", warnings)
+	}
+
+	fsys["templates/broken.helmtk"] = &fstest.MapFile{Data: []byte("a: (")}
+	res, err = RenderFiles(fsys, []string{"templates/*.helmtk"})
+	if err == nil {
+		t.Fatal("expected a parse error")
+	}
+	if errs := res.Errors(); len(errs) != 1 || errs[0].Filename != "templates/broken.helmtk" {
+		t.Errorf("got diagnostics %v", errs)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	prog, err := Compile(`
+define("greeting") do
+	text: "hello ${Values.name}"
+end
+{include("greeting")}
+`, WithValues(map[string]any{"name": "default"}))
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		res, err := prog.Render(context.Background(), map[string]any{"name": name})
+		if err != nil {
+			t.Fatalf("render error: %v", err)
+		}
+		if got, want := string(res.Bytes()), "text: hello "+name+"\n"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	res, err := prog.Render(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("render error: %v", err)
+	}
+	if got, want := string(res.Bytes()), "text: hello default\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := Compile("a: ("); err == nil {
+		t.Error("expected a parse error")
+	}
+}
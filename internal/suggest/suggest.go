@@ -0,0 +1,59 @@
+// Package suggest finds likely typos of names, for "did you mean" hints in
+// error messages.
+package suggest
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Hint appends a "did you mean" hint to msg for the candidate closest to
+// name, if any is close enough
+func Hint(msg, name string, candidates []string) string {
+	if best := closest(name, candidates); best != "" {
+		return fmt.Sprintf("%s, did you mean %q?", msg, best)
+	}
+	return msg
+}
+
+// closest returns the candidate nearest to name by edit distance, if any is
+// close enough to be a likely typo. Candidates are sorted in place, so ties
+// go to the first in alphabetical order.
+func closest(name string, candidates []string) string {
+	slices.Sort(candidates)
+
+	best, bestDist := "", max(1, len(name)/3)+1
+	for _, c := range candidates {
+		if d := editDistance(name, c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// editDistance is the optimal string alignment distance between two
+// strings: the Levenshtein distance, with swapping two adjacent letters
+// counted as a single edit
+func editDistance(a, b string) int {
+	prev2 := make([]int, len(b)+1) // Row i-2, for transpositions
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(b)]
+}
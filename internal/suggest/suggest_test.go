@@ -0,0 +1,48 @@
+package suggest
+
+import "testing"
+
+func TestHint(t *testing.T) {
+	candidates := []string{"replicas", "image", "imagePullPolicy"}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"replica", `unknown field, did you mean "replicas"?`},
+		{"imag", `unknown field, did you mean "image"?`},
+		{"ports", "unknown field"},
+		{"", "unknown field"},
+	}
+	for _, tt := range tests {
+		if got := Hint("unknown field", tt.name, candidates); got != tt.want {
+			t.Errorf("Hint(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// Swapped letters count as a single edit, even in short names
+	for name, want := range map[string]string{"cuont": "count", "prot": "port"} {
+		if got := closest(name, []string{"count", "name", "port"}); got != want {
+			t.Errorf("closest(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"name", "name", 0},
+		{"cuont", "count", 1},
+		{"prot", "port", 1},
+		{"ca", "abc", 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Package kube checks rendered documents against the OpenAPI schemas of
+// Kubernetes objects, so that typos and wrongly typed fields are found
+// before they reach a cluster.
+//
+// Schemas are loaded from OpenAPI v2 documents (the swagger.json served at
+// /openapi/v2), OpenAPI v3 documents (served at /openapi/v3/apis/...), and
+// CustomResourceDefinition YAML. The schema of a document is picked by its
+// apiVersion and kind.
+package kube
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Validator holds the schemas of the kinds documents are checked against
+type Validator struct {
+	kinds map[gvk]*schema    // Schemas of top-level objects
+	defs  map[string]*schema // Named definitions that $refs point to
+}
+
+// gvk identifies a kind of object. The group is empty for core kinds.
+type gvk struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+func (k gvk) String() string {
+	if k.Group == "" {
+		return k.Version + " " + k.Kind
+	}
+	return k.Group + "/" + k.Version + " " + k.Kind
+}
+
+// schema is the subset of an OpenAPI schema, with the Kubernetes
+// extensions, that validation uses
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Properties           map[string]*schema `json:"properties"`
+	AdditionalProperties *schema            `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	Required             []string           `json:"required"`
+	Enum                 []any              `json:"enum"`
+	AllOf                []*schema          `json:"allOf"`
+	IntOrString          bool               `json:"x-kubernetes-int-or-string"`
+	PreserveUnknown      bool               `json:"x-kubernetes-preserve-unknown-fields"`
+	EmbeddedResource     bool               `json:"x-kubernetes-embedded-resource"`
+	Kinds                []gvk              `json:"x-kubernetes-group-version-kind"`
+
+	// Allows is set for the boolean schemas true and false, which
+	// additionalProperties may be
+	Allows *bool `json:"-"`
+}
+
+func (s *schema) UnmarshalJSON(data []byte) error {
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		s.Allows = &b
+		return nil
+	}
+	type plain schema
+	return json.Unmarshal(data, (*plain)(s))
+}
+
+// NewValidator returns a validator without any schemas
+func NewValidator() *Validator {
+	return &Validator{kinds: make(map[gvk]*schema), defs: make(map[string]*schema)}
+}
+
+// Load adds the schemas in an OpenAPI v2 or v3 document, given as JSON, or
+// in a stream of YAML documents holding CustomResourceDefinitions. Other
+// documents in a YAML stream are ignored.
+func (v *Validator) Load(data []byte) error {
+	var spec struct {
+		Swagger     string             `json:"swagger"`
+		OpenAPI     string             `json:"openapi"`
+		Definitions map[string]*schema `json:"definitions"`
+		Components  struct {
+			Schemas map[string]*schema `json:"schemas"`
+		} `json:"components"`
+	}
+	if json.Unmarshal(data, &spec) == nil {
+		switch {
+		case spec.Swagger != "":
+			v.addDefinitions(spec.Definitions)
+			return nil
+		case spec.OpenAPI != "":
+			v.addDefinitions(spec.Components.Schemas)
+			return nil
+		}
+	}
+	return v.loadCRDs(data)
+}
+
+// addDefinitions adds the named schemas of an OpenAPI document, and
+// indexes the ones describing top-level kinds
+func (v *Validator) addDefinitions(defs map[string]*schema) {
+	for name, s := range defs {
+		v.defs[name] = s
+		for _, k := range s.Kinds {
+			v.kinds[k] = s
+		}
+	}
+}
+
+// crd is the part of a CustomResourceDefinition that holds its schemas
+type crd struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Group string `json:"group"`
+		Names struct {
+			Kind string `json:"kind"`
+		} `json:"names"`
+		Versions []struct {
+			Name   string `json:"name"`
+			Schema struct {
+				OpenAPIV3Schema *schema `json:"openAPIV3Schema"`
+			} `json:"schema"`
+		} `json:"versions"`
+	} `json:"spec"`
+}
+
+// loadCRDs adds the schemas of the CustomResourceDefinitions in a YAML
+// stream
+func (v *Validator) loadCRDs(data []byte) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	found := false
+	for {
+		var doc any
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("parsing schemas: %w", err)
+		}
+
+		// The YAML is converted to JSON to decode it with the same
+		// struct tags as OpenAPI documents
+		js, err := json.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("parsing schemas: %w", err)
+		}
+		var def crd
+		if err := json.Unmarshal(js, &def); err != nil {
+			return fmt.Errorf("parsing schemas: %w", err)
+		}
+		if def.Kind != "CustomResourceDefinition" || !strings.HasPrefix(def.APIVersion, "apiextensions.k8s.io/") {
+			continue
+		}
+		found = true
+		for _, version := range def.Spec.Versions {
+			if s := version.Schema.OpenAPIV3Schema; s != nil {
+				v.kinds[gvk{def.Spec.Group, version.Name, def.Spec.Names.Kind}] = s
+			}
+		}
+	}
+	if !found {
+		return errors.New("no OpenAPI document or CustomResourceDefinition found")
+	}
+	return nil
+}
+
+// resolve follows a schema's $ref. Refs name definitions by the last part
+// of their path, which is the same in OpenAPI v2 and v3 documents.
+func (v *Validator) resolve(s *schema) *schema {
+	for s != nil && s.Ref != "" {
+		name := s.Ref[strings.LastIndex(s.Ref, "/")+1:]
+		s = v.defs[name]
+	}
+	return s
+}
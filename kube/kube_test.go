@@ -0,0 +1,138 @@
+package kube
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"helmtk.dev/code/htkl/eval"
+	"helmtk.dev/code/htkl/parser"
+	"helmtk.dev/code/htkl/runtime"
+)
+
+func newTestValidator(t *testing.T) *Validator {
+	t.Helper()
+	v := NewValidator()
+	for _, name := range []string{"swagger.json", "openapi-v3.json", "crd.yaml"} {
+		data, err := os.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := v.Load(data); err != nil {
+			t.Fatalf("loading %s: %v", name, err)
+		}
+	}
+	return v
+}
+
+// render evaluates source with positions tracked, and validates each
+// document it produces
+func render(t *testing.T, v *Validator, src string) []string {
+	t.Helper()
+	doc, err := parser.New(src, "app.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	docs, err := eval.EvalDocuments(doc, runtime.NewScope(nil), eval.TrackPositions())
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	var got []string
+	for _, d := range docs {
+		for _, f := range v.Validate(d.Value) {
+			got = append(got, fmt.Sprintf("%d:%d %v %s", f.Pos.Line, f.Pos.Col, f.Warning, f))
+		}
+	}
+	return got
+}
+
+func TestValidate(t *testing.T) {
+	v := newTestValidator(t)
+
+	got := render(t, v, `
+define("container", name, port) do
+	name: name
+	image: "nginx"
+	ports: [{contanerPort: port}]
+end
+
+apiVersion: "apps/v1"
+kind: "Deployment"
+metadata: {name: "web", labels: {app: "web", replicas: 3}}
+spec: {
+	replicas: "3"
+	template: {
+		spec: {
+			containers: [{include("container", {name: "web", port: 80})}, {image: "sidecar"}]
+			restartPolicy: "Sometimes"
+		}
+	}
+}
+`)
+	want := []string{
+		`10:46 false metadata.labels.replicas: expected string, got number`,
+		`11:1 false spec.selector: required field is missing`,
+		`12:2 false spec.replicas: expected integer, got string`,
+		`5:2 false spec.template.spec.containers[0].ports[0].containerPort: required field is missing`,
+		`5:11 false spec.template.spec.containers[0].ports[0].contanerPort: unknown field, did you mean "containerPort"?`,
+		`15:4 false spec.template.spec.containers[1].name: required field is missing`,
+		`16:4 false spec.template.spec.restartPolicy: must be one of "Always", "Never", "OnFailure", got "Sometimes"`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got findings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestValidateOpenAPIV3AndCRDs(t *testing.T) {
+	v := newTestValidator(t)
+
+	got := render(t, v, `
+apiVersion: "v1"
+kind: "Service"
+metadata: {name: "web"}
+spec: {
+	type: "ClusterIP"
+	selector: {app: "web"}
+	ports: [{port: 80, targetPort: "http"}, {port: 443, targetPort: 8443.5}, {name: "x"}]
+}
+---
+apiVersion: "example.com/v1"
+kind: "Certificate"
+metadata: {name: "web", annotations: {a: "b"}}
+spec: {
+	dnsNames: ["example.com", 1]
+	duration: "24h"
+	extra: {anything: true}
+	issuer: "letsencrypt"
+}
+---
+apiVersion: "example.com/v2"
+kind: "Certificate"
+---
+name: "not a kubernetes object"
+`)
+	want := []string{
+		`8:54 false spec.ports[1].targetPort: expected integer or string, got number`,
+		`8:2 false spec.ports[2].port: required field is missing`,
+		`14:1 false spec.secretName: required field is missing`,
+		`15:2 false spec.dnsNames[1]: expected string, got number`,
+		`18:2 false spec.issuer: unknown field`,
+		`22:1 true no schema for example.com/v2 Certificate, so it wasn't validated`,
+		`0:0 true document has no apiVersion and kind, so it wasn't validated`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got findings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	if err := NewValidator().Load([]byte("apiVersion: v1\nkind: ConfigMap\n")); err == nil {
+		t.Error("expected an error for a file without schemas")
+	}
+	if err := NewValidator().Load([]byte("a: [")); err == nil {
+		t.Error("expected a parse error")
+	}
+}
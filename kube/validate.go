@@ -0,0 +1,213 @@
+package kube
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"helmtk.dev/code/htkl/internal/suggest"
+	"helmtk.dev/code/htkl/parser"
+	"helmtk.dev/code/htkl/runtime"
+)
+
+// Finding is a problem with a document. Pos is the source position of the
+// field the problem is in, or of the closest field containing it, when the
+// document was evaluated with eval.TrackPositions.
+type Finding struct {
+	Pos     parser.Pos
+	Path    string // Path to the field, like spec.containers[0].ports
+	Message string
+	Warning bool // Whether the document couldn't be checked, rather than being invalid
+}
+
+func (f Finding) String() string {
+	if f.Path == "" {
+		return f.Message
+	}
+	return f.Path + ": " + f.Message
+}
+
+// Validate checks a document against the schema of its kind, and returns
+// what's wrong with it. Documents that don't have an apiVersion and kind,
+// or whose kind has no schema, get a warning.
+func (v *Validator) Validate(doc runtime.Value) []Finding {
+	obj, ok := doc.(*runtime.ObjectValue)
+	if !ok {
+		return []Finding{{Message: fmt.Sprintf("expected an object, got %s", doc.Type()), Warning: true}}
+	}
+	apiVersion, _ := obj.Fields["apiVersion"].(*runtime.StringValue)
+	kind, _ := obj.Fields["kind"].(*runtime.StringValue)
+	if apiVersion == nil || kind == nil {
+		return []Finding{{Message: "document has no apiVersion and kind, so it wasn't validated", Warning: true}}
+	}
+
+	k := gvk{Version: apiVersion.Value, Kind: kind.Value}
+	if group, version, ok := strings.Cut(apiVersion.Value, "/"); ok {
+		k.Group, k.Version = group, version
+	}
+	// Problems with the document as a whole are reported at its kind
+	pos, _ := obj.Pos("kind")
+	s, ok := v.kinds[k]
+	if !ok {
+		return []Finding{{Pos: pos, Message: fmt.Sprintf("no schema for %s, so it wasn't validated", k), Warning: true}}
+	}
+
+	c := &checker{validator: v}
+	c.check(s, obj, "", pos, true)
+	return c.findings
+}
+
+type checker struct {
+	validator *Validator
+	findings  []Finding
+}
+
+func (c *checker) report(pos parser.Pos, path, format string, args ...any) {
+	c.findings = append(c.findings, Finding{Pos: pos, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// check checks a value against a schema. Pos is where the value was set,
+// and resource says whether it's a whole object, which may have apiVersion,
+// kind and metadata fields.
+func (c *checker) check(s *schema, val runtime.Value, path string, pos parser.Pos, resource bool) {
+	s = c.validator.resolve(s)
+	if s == nil || s.Allows != nil {
+		return
+	}
+	// Unset fields are left out when objects are sent to the API server
+	if _, ok := val.(*runtime.NullValue); ok {
+		return
+	}
+	for _, sub := range s.AllOf {
+		c.check(sub, val, path, pos, resource)
+	}
+
+	if !c.checkType(s, val, path, pos) {
+		return
+	}
+	if s.Enum != nil && !slices.ContainsFunc(s.Enum, func(e any) bool { return equal(val, e) }) {
+		options := make([]string, len(s.Enum))
+		for i, e := range s.Enum {
+			options[i] = format(e)
+		}
+		c.report(pos, path, "must be one of %s, got %s", strings.Join(options, ", "), format(runtime.ToNative(val)))
+	}
+
+	switch v := val.(type) {
+	case *runtime.ArrayValue:
+		if s.Items == nil {
+			return
+		}
+		for i, elem := range v.Elements {
+			c.check(s.Items, elem, fmt.Sprintf("%s[%d]", path, i), pos, false)
+		}
+	case *runtime.ObjectValue:
+		c.checkObject(s, v, path, pos, resource || s.EmbeddedResource)
+	}
+}
+
+// checkType reports a value that doesn't have the schema's type, and
+// returns whether it has it
+func (c *checker) checkType(s *schema, val runtime.Value, path string, pos parser.Pos) bool {
+	want := s.Type
+	if s.IntOrString || s.Format == "int-or-string" {
+		want = "integer or string"
+	}
+
+	ok := true
+	switch want {
+	case "":
+		return true
+	case "integer or string":
+		ok = runtime.IsString(val) || isInteger(val)
+	case "integer":
+		ok = isInteger(val)
+	case "boolean":
+		ok = runtime.IsBool(val)
+	default:
+		ok = val.Type().String() == want
+	}
+	if !ok {
+		got := val.Type().String()
+		if want == "integer" && runtime.IsNumber(val) {
+			got = val.String()
+		}
+		c.report(pos, path, "expected %s, got %s", want, got)
+	}
+	return ok
+}
+
+func (c *checker) checkObject(s *schema, obj *runtime.ObjectValue, path string, pos parser.Pos, resource bool) {
+	for _, name := range s.Required {
+		if field, ok := obj.Fields[name]; !ok || runtime.IsNull(field) {
+			c.report(pos, fieldPath(path, name), "required field is missing")
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(obj.Fields)) {
+		fieldPos := pos
+		if p, ok := obj.Pos(name); ok {
+			fieldPos = p
+		}
+		field := obj.Fields[name]
+
+		if prop, ok := s.Properties[name]; ok {
+			c.check(prop, field, fieldPath(path, name), fieldPos, false)
+			continue
+		}
+		switch {
+		case s.AdditionalProperties != nil:
+			if a := s.AdditionalProperties.Allows; a != nil && !*a {
+				c.report(fieldPos, fieldPath(path, name), "unknown field")
+				continue
+			}
+			c.check(s.AdditionalProperties, field, fieldPath(path, name), fieldPos, false)
+		case resource && (name == "apiVersion" || name == "kind" || name == "metadata"):
+			// Embedded resources have these even when their schema
+			// doesn't list them
+		case s.PreserveUnknown || s.Properties == nil:
+			// Objects without known properties can have any fields
+		default:
+			c.report(fieldPos, fieldPath(path, name), "%s", suggest.Hint("unknown field", name, slices.Collect(maps.Keys(s.Properties))))
+		}
+	}
+}
+
+func isInteger(val runtime.Value) bool {
+	num, ok := val.(*runtime.NumberValue)
+	return ok && num.Value == math.Trunc(num.Value)
+}
+
+// equal compares a value to a value decoded from JSON
+func equal(val runtime.Value, native any) bool {
+	a, err := json.Marshal(runtime.ToNative(val))
+	if err != nil {
+		return false
+	}
+	b, err := json.Marshal(native)
+	return err == nil && string(a) == string(b)
+}
+
+// format shows a value decoded from JSON as JSON
+func format(native any) string {
+	data, err := json.Marshal(native)
+	if err != nil {
+		return fmt.Sprint(native)
+	}
+	return string(data)
+}
+
+// fieldPath appends a field name to a path
+func fieldPath(path, name string) string {
+	if strings.ContainsAny(name, ".[]") {
+		return fmt.Sprintf("%s[%s]", path, strconv.Quote(name))
+	}
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
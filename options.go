@@ -0,0 +1,170 @@
+package htkl
+
+import (
+	"helmtk.dev/code/htkl/eval"
+	"helmtk.dev/code/htkl/kube"
+	"helmtk.dev/code/htkl/runtime"
+	"helmtk.dev/code/htkl/schema"
+	"helmtk.dev/code/htkl/types"
+)
+
+// Format is an encoding that rendered documents are written in
+type Format int
+
+const (
+	FormatYAML Format = iota
+	FormatJSON
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatYAML:
+		return "yaml"
+	case FormatJSON:
+		return "json"
+	default:
+		return "unknown"
+	}
+}
+
+// Option configures how templates are rendered
+type Option func(*config)
+
+type config struct {
+	filename     string
+	globals      map[string]runtime.Value
+	funcs        map[string]runtime.Func
+	format       Format
+	maxDocuments int
+	evalOpts     []eval.Option
+	typeCheck    bool
+	checkOpts    []eval.CheckOption
+	schema       *schema.Schema
+	kube         *kube.Validator
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		filename: "input.helmtk",
+		globals:  make(map[string]runtime.Value),
+		funcs:    make(map[string]runtime.Func),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// scope creates the root scope that templates are evaluated in
+func (c *config) scope() *runtime.Scope {
+	scope := runtime.NewScope(nil)
+	for name, val := range c.globals {
+		scope.SetGlobal(name, val)
+	}
+	for name, fn := range c.funcs {
+		scope.SetFunction(name, fn)
+	}
+	return scope
+}
+
+// WithValues makes values available to templates as Values. Go maps,
+// slices, structs and scalars are converted to runtime values.
+func WithValues(values any) Option {
+	return WithGlobal("Values", values)
+}
+
+// WithGlobal makes a value available to templates under the given name
+func WithGlobal(name string, value any) Option {
+	return func(c *config) {
+		val, ok := value.(runtime.Value)
+		if !ok {
+			val = runtime.NewValue(value)
+		}
+		c.globals[name] = val
+	}
+}
+
+// WithFunction registers a function that templates can call by name
+func WithFunction(name string, fn runtime.Func) Option {
+	return func(c *config) {
+		c.funcs[name] = fn
+	}
+}
+
+// WithFormat sets the encoding of rendered documents, FormatYAML by default
+func WithFormat(format Format) Option {
+	return func(c *config) {
+		c.format = format
+	}
+}
+
+// WithFilename sets the name that Render reports source positions with
+func WithFilename(filename string) Option {
+	return func(c *config) {
+		c.filename = filename
+	}
+}
+
+// WithMaxIncludeDepth limits how deeply includes can nest
+// (see eval.MaxIncludeDepth)
+func WithMaxIncludeDepth(depth int) Option {
+	return func(c *config) {
+		c.evalOpts = append(c.evalOpts, eval.MaxIncludeDepth(depth))
+	}
+}
+
+// WithMaxDocuments makes rendering fail once templates produce more than
+// the given number of documents. Values below 1 mean no limit.
+func WithMaxDocuments(n int) Option {
+	return func(c *config) {
+		c.maxDocuments = n
+	}
+}
+
+// WithCollectFailures reports every failed assertion as a diagnostic,
+// instead of stopping at the first one (see eval.CollectFailures)
+func WithCollectFailures() Option {
+	return func(c *config) {
+		c.evalOpts = append(c.evalOpts, eval.CollectFailures())
+	}
+}
+
+// WithTypeCheck checks templates for type errors before rendering them
+// (see eval.Check). Values describes the values templates are rendered
+// with. When it's nil, the type of the values given to WithValues is used.
+func WithTypeCheck(values *types.Type) Option {
+	return func(c *config) {
+		c.typeCheck = true
+		if values != nil {
+			c.checkOpts = append(c.checkOpts, eval.ValuesType(values))
+		}
+	}
+}
+
+// WithSignature declares the parameters and result of a function, which
+// type checking uses to check calls to it
+func WithSignature(name string, sig types.Signature) Option {
+	return func(c *config) {
+		c.checkOpts = append(c.checkOpts, eval.Signatures(map[string]types.Signature{name: sig}))
+	}
+}
+
+// WithValuesSchema validates Values against a JSON Schema before rendering,
+// like a chart's values.schema.json, and fills in the defaults it declares.
+// Each mismatch is reported as a diagnostic naming its JSON pointer.
+func WithValuesSchema(s *schema.Schema) Option {
+	return func(c *config) {
+		c.schema = s
+	}
+}
+
+// WithKubernetesValidation checks each rendered document against the
+// schema of its Kubernetes kind. Unknown fields, wrong types and missing
+// required fields are reported as errors at the template position that set
+// them, and documents that can't be checked get a warning.
+func WithKubernetesValidation(v *kube.Validator) Option {
+	return func(c *config) {
+		c.kube = v
+		c.evalOpts = append(c.evalOpts, eval.TrackPositions())
+	}
+}
@@ -16,11 +16,39 @@ type Pos struct {
 type Document struct {
 	Body        []Statement
 	Definitions []*Definition
+	Types       []*TypeDecl
+	Warnings    []Warning // Non-fatal diagnostics reported by the parser
+	Frame       *Frame    // Variables of the top-level body, see Frame
 }
 
 func (d *Document) node()       {}
 func (d *Document) GetPos() Pos { return Pos{} }
 
+// Frame lists the variables declared directly in a block that gets a scope
+// of its own, like a for loop's body, so that they can be kept in slots
+// rather than looked up by name. Frames and the Bindings that point into
+// them are filled in by eval.Compile, parsing leaves them nil.
+type Frame struct {
+	Names []string
+}
+
+// Index returns the slot of a variable in the frame, or -1 if the block
+// doesn't declare it
+func (f *Frame) Index(name string) int {
+	for i, n := range f.Names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Binding is the slot of the variable an identifier refers to
+type Binding struct {
+	Frame *Frame // Frame of the block that declares the variable
+	Index int
+}
+
 // Statement represents a top-level statement
 type Statement interface {
 	Node
@@ -41,9 +69,10 @@ type Expression interface {
 
 // KeyValueStatement represents a key-value pair (e.g., apiVersion: "apps/v1")
 type KeyValueStatement struct {
-	Key   string
-	Value ValueStatement
-	Pos   Pos
+	Key    string
+	Value  ValueStatement
+	Hidden bool // Set by "hidden key: value", the field is readable but not output
+	Pos    Pos
 }
 
 func (kv *KeyValueStatement) node()       {}
@@ -122,8 +151,9 @@ func (c *CurrentContext) GetPos() Pos     { return c.Pos }
 
 // Identifier represents a variable reference (e.g., Values, name)
 type Identifier struct {
-	Name string
-	Pos  Pos
+	Name    string
+	Binding *Binding // Set when the variable is kept in a slot, see Frame
+	Pos     Pos
 }
 
 func (i *Identifier) node()           {}
@@ -232,12 +262,87 @@ func (i *IfStatement) statement()      {}
 func (i *IfStatement) valueStatement() {}
 func (i *IfStatement) GetPos() Pos     { return i.Pos }
 
-// ForStatement represents a loop (e.g., for k, v in Values.extraEnvs { ... })
+// MatchStatement represents a pattern match
+// (e.g., match Values.service.type do case "ClusterIP" => ... case _ => ... end)
+type MatchStatement struct {
+	Subject Expression
+	Cases   []*MatchCase
+	Pos     Pos
+}
+
+func (m *MatchStatement) node()           {}
+func (m *MatchStatement) statement()      {}
+func (m *MatchStatement) valueStatement() {}
+func (m *MatchStatement) GetPos() Pos     { return m.Pos }
+
+// MatchCase represents a single case of a match (e.g., case "a", "b" if cond => ...)
+type MatchCase struct {
+	Patterns []Pattern  // Alternatives, the case applies if any of them matches
+	Guard    Expression // Optional guard condition
+	Body     []Node
+	Pos      Pos
+}
+
+func (c *MatchCase) node()       {}
+func (c *MatchCase) GetPos() Pos { return c.Pos }
+
+// Pattern represents a pattern in a match case
+type Pattern interface {
+	Node
+	pattern()
+}
+
+// LiteralPattern matches values equal to a literal (e.g., "TCP", 80, true, null)
+type LiteralPattern struct {
+	Value Expression
+	Pos   Pos
+}
+
+func (l *LiteralPattern) node()       {}
+func (l *LiteralPattern) pattern()    {}
+func (l *LiteralPattern) GetPos() Pos { return l.Pos }
+
+// BindingPattern matches any value and binds it to a name ("_" binds nothing)
+type BindingPattern struct {
+	Name string
+	Pos  Pos
+}
+
+func (b *BindingPattern) node()       {}
+func (b *BindingPattern) pattern()    {}
+func (b *BindingPattern) GetPos() Pos { return b.Pos }
+
+// IsWildcard reports whether the pattern is the "_" wildcard
+func (b *BindingPattern) IsWildcard() bool { return b.Name == "_" }
+
+// ObjectPattern matches objects that have all the listed fields
+// (e.g., {type: "ClusterIP", port: p})
+type ObjectPattern struct {
+	Fields []*FieldPattern
+	Pos    Pos
+}
+
+func (o *ObjectPattern) node()       {}
+func (o *ObjectPattern) pattern()    {}
+func (o *ObjectPattern) GetPos() Pos { return o.Pos }
+
+// FieldPattern represents a single field of an object pattern
+type FieldPattern struct {
+	Key     string
+	Pattern Pattern
+	Pos     Pos
+}
+
+// ForStatement represents a loop (e.g., for k, v in Values.extraEnvs if v do ... else ... end)
 type ForStatement struct {
-	KeyVar   string
+	KeyVar   string // Optional, empty when only the value is bound (e.g., for x in xs)
 	ValueVar string
 	Iterable Expression
+	Sort     *SortClause // Optional, orders the items before iterating
+	Filter   Expression  // Optional, items are skipped when it's falsy
 	Body     []Node
+	Else     []Node // Optional else clause, runs when there is nothing to iterate
+	Frame    *Frame // Variables of each iteration, see Frame
 	Pos      Pos
 }
 
@@ -246,11 +351,25 @@ func (f *ForStatement) statement()      {}
 func (f *ForStatement) valueStatement() {}
 func (f *ForStatement) GetPos() Pos     { return f.Pos }
 
-// WithStatement represents a context change (e.g., with Values.ingress as ing do ... end)
+// SortClause orders the items of a for loop (e.g., for k, v in sorted obj,
+// for p in ports sort by p.port desc). The sort expression can refer to the
+// loop variables, or to the item's key and value as "key" and "value".
+type SortClause struct {
+	By   Expression // nil sorts object fields by key, other items by value
+	Desc bool
+	Pos  Pos
+}
+
+// WithStatement represents a context change (e.g., with Values.ingress as ing do ... else ... end).
+// Like Helm's with, the body only runs when the context is truthy, unless
+// Always is set (e.g., with Values.ingress as ing always do ... end).
 type WithStatement struct {
 	Context Expression
 	VarName string // Variable name for the context (optional, empty string means use ".")
+	Always  bool   // Bind and run the body even when the context is falsy
 	Body    []Node
+	Else    []Node // Optional else clause, runs when the context is falsy
+	Frame   *Frame // Variables of the body, see Frame
 	Pos     Pos
 }
 
@@ -277,9 +396,11 @@ func (c *ContinueStatement) node()       {}
 func (c *ContinueStatement) statement()  {}
 func (c *ContinueStatement) GetPos() Pos { return c.Pos }
 
-// LetStatement represents a variable definition (e.g., let name = "helmtk")
+// LetStatement represents a variable definition (e.g., let name = "helmtk",
+// or let ports: [Port] = Values.ports)
 type LetStatement struct {
 	Name  string
+	Type  TypeExpr // Optional, the value is checked against it
 	Value ValueStatement
 	Pos   Pos
 }
@@ -288,17 +409,40 @@ func (l *LetStatement) node()       {}
 func (l *LetStatement) statement()  {}
 func (l *LetStatement) GetPos() Pos { return l.Pos }
 
-// AssignmentStatement represents variable reassignment (e.g., name = "new value")
+// AssignmentStatement represents reassignment of a variable or a path inside
+// one (e.g., name = "new value", config.ports[0] = 80, count += 1)
 type AssignmentStatement struct {
-	Name  string
-	Value ValueStatement
-	Pos   Pos
+	Target   Expression // Identifier, MemberExpression or IndexExpression
+	Operator string     // "=", "+=", "-=", "||="
+	Value    ValueStatement
+	Pos      Pos
 }
 
 func (a *AssignmentStatement) node()       {}
 func (a *AssignmentStatement) statement()  {}
 func (a *AssignmentStatement) GetPos() Pos { return a.Pos }
 
+// AssertStatement represents an assertion (e.g., assert Values.replicas > 0, "replicas must be positive")
+type AssertStatement struct {
+	Condition Expression
+	Message   Expression // Optional, defaults to "assertion failed"
+	Pos       Pos
+}
+
+func (a *AssertStatement) node()       {}
+func (a *AssertStatement) statement()  {}
+func (a *AssertStatement) GetPos() Pos { return a.Pos }
+
+// FailStatement represents an unconditional failure (e.g., fail("unsupported mode"))
+type FailStatement struct {
+	Message Expression
+	Pos     Pos
+}
+
+func (f *FailStatement) node()       {}
+func (f *FailStatement) statement()  {}
+func (f *FailStatement) GetPos() Pos { return f.Pos }
+
 // Comment represents a comment line
 type Comment struct {
 	Text string
@@ -310,27 +454,112 @@ func (c *Comment) GetPos() Pos { return c.Pos }
 
 // Definition represents a template definition (e.g., define(name, arg1, arg2) body)
 type Definition struct {
-	Name string
-	Body []Node // Single value for expression form, multiple for do block
-	Pos  Pos
+	Name     string
+	Params   []*Parameter // nil when the definition has no parameter list
+	Body     []Node       // Single value for expression form, multiple for do block
+	Override bool         // Set by "override define", replacing an earlier definition
+	Frame    *Frame       // Parameters and variables of the body, see Frame
+	Pos      Pos
 }
 
 func (d *Definition) node()       {}
 func (d *Definition) GetPos() Pos { return d.Pos }
 
-// IncludeExpression represents a template inclusion (e.g., include(name, arg1, arg2))
-type IncludeExpression struct {
+// Parameter represents a named template parameter (e.g., version = "1.0",
+// or port: int = 80)
+type Parameter struct {
 	Name    string
-	Context Expression
+	Type    TypeExpr   // Optional, arguments are checked against it
+	Default Expression // Optional, the parameter is required when nil
 	Pos     Pos
 }
 
+// IncludeExpression represents a template inclusion (e.g., include(name, arg1, arg2))
+type IncludeExpression struct {
+	Name     string     // Template name when given as a literal
+	NameExpr Expression // Template name expression when computed at runtime, nil for literals
+	Context  Expression
+	Block    []Node  // Optional content block, rendered where the template yields
+	Slots    []*Slot // Optional named content blocks
+	Pos      Pos
+}
+
 func (i *IncludeExpression) node()           {}
 func (i *IncludeExpression) expression()     {}
 func (i *IncludeExpression) statement()      {}
 func (i *IncludeExpression) valueStatement() {}
 func (i *IncludeExpression) GetPos() Pos     { return i.Pos }
 
+// SuperExpression renders the definition an override replaced (e.g., super(),
+// or super(ctx) to pass a different context)
+type SuperExpression struct {
+	Context Expression // Optional, defaults to the override's own context
+	Pos     Pos
+}
+
+func (s *SuperExpression) node()           {}
+func (s *SuperExpression) expression()     {}
+func (s *SuperExpression) statement()      {}
+func (s *SuperExpression) valueStatement() {}
+func (s *SuperExpression) GetPos() Pos     { return s.Pos }
+
+// Slot represents a named content block passed to a template
+// (e.g., slot env do ... end)
+type Slot struct {
+	Name string
+	Body []Node
+	Pos  Pos
+}
+
+// YieldStatement renders the content block passed to the current template
+// (e.g., yield, or yield env for a named slot)
+type YieldStatement struct {
+	Name string // Empty for the main content block
+	Pos  Pos
+}
+
+func (y *YieldStatement) node()       {}
+func (y *YieldStatement) statement()  {}
+func (y *YieldStatement) GetPos() Pos { return y.Pos }
+
+// DocumentBlock represents an explicit output document
+// (e.g., document "deployment.yaml", weight = 10 do ... end)
+type DocumentBlock struct {
+	Name   Expression // Optional output filename
+	Weight Expression // Optional ordering weight, documents with lower weights come first
+	Body   []Node
+	Frame  *Frame // Variables of the body, see Frame
+	Pos    Pos
+}
+
+func (d *DocumentBlock) node()       {}
+func (d *DocumentBlock) statement()  {}
+func (d *DocumentBlock) GetPos() Pos { return d.Pos }
+
+// DocumentSeparator represents a "---" line, which ends the current document
+type DocumentSeparator struct {
+	Pos Pos
+}
+
+func (d *DocumentSeparator) node()       {}
+func (d *DocumentSeparator) statement()  {}
+func (d *DocumentSeparator) GetPos() Pos { return d.Pos }
+
+// TryExpression represents error recovery
+// (e.g., try include("custom") else {} or try include("custom") catch err => err.message)
+type TryExpression struct {
+	Body     Expression
+	ErrorVar string // Variable bound to the error in Fallback (optional, set by "catch")
+	Fallback Expression
+	Pos      Pos
+}
+
+func (t *TryExpression) node()           {}
+func (t *TryExpression) expression()     {}
+func (t *TryExpression) statement()      {}
+func (t *TryExpression) valueStatement() {}
+func (t *TryExpression) GetPos() Pos     { return t.Pos }
+
 // CallExpression represents a function call (e.g., upper(name), quote(str))
 type CallExpression struct {
 	Function Expression
@@ -343,3 +572,92 @@ func (c *CallExpression) expression()     {}
 func (c *CallExpression) statement()      {}
 func (c *CallExpression) valueStatement() {}
 func (c *CallExpression) GetPos() Pos     { return c.Pos }
+
+// IsExpression checks a value against a type (e.g., port is Port)
+type IsExpression struct {
+	Value Expression
+	Type  TypeExpr
+	Pos   Pos
+}
+
+func (i *IsExpression) node()           {}
+func (i *IsExpression) expression()     {}
+func (i *IsExpression) statement()      {}
+func (i *IsExpression) valueStatement() {}
+func (i *IsExpression) GetPos() Pos     { return i.Pos }
+
+// TypeDecl represents a named type declaration
+// (e.g., type Port = {name: string, port: int})
+type TypeDecl struct {
+	Name string
+	Type TypeExpr
+	Pos  Pos
+}
+
+func (t *TypeDecl) node()       {}
+func (t *TypeDecl) GetPos() Pos { return t.Pos }
+
+// TypeExpr represents a type in a declaration or annotation
+type TypeExpr interface {
+	Node
+	typeExpr()
+}
+
+// NamedType refers to a builtin type or a declared one (e.g., string, Port)
+type NamedType struct {
+	Name string
+	Pos  Pos
+}
+
+func (n *NamedType) node()       {}
+func (n *NamedType) typeExpr()   {}
+func (n *NamedType) GetPos() Pos { return n.Pos }
+
+// LiteralType only allows a single value (e.g., "TCP")
+type LiteralType struct {
+	Value Expression // StringLiteral, NumberLiteral, BooleanLiteral or NullLiteral
+	Pos   Pos
+}
+
+func (l *LiteralType) node()       {}
+func (l *LiteralType) typeExpr()   {}
+func (l *LiteralType) GetPos() Pos { return l.Pos }
+
+// ArrayType represents arrays of a type (e.g., [Port])
+type ArrayType struct {
+	Elem TypeExpr
+	Pos  Pos
+}
+
+func (a *ArrayType) node()       {}
+func (a *ArrayType) typeExpr()   {}
+func (a *ArrayType) GetPos() Pos { return a.Pos }
+
+// ObjectType represents objects with the given fields
+// (e.g., {name: string, protocol?: string})
+type ObjectType struct {
+	Fields []*FieldType
+	Pos    Pos
+}
+
+func (o *ObjectType) node()       {}
+func (o *ObjectType) typeExpr()   {}
+func (o *ObjectType) GetPos() Pos { return o.Pos }
+
+// FieldType is a field of an object type
+type FieldType struct {
+	Name     string
+	Type     TypeExpr
+	Optional bool // Set by "name?:", the field may be missing
+	Pos      Pos
+}
+
+// UnionType allows values of any of its types (e.g., "TCP" | "UDP")
+type UnionType struct {
+	Types []TypeExpr
+	Pos   Pos
+}
+
+func (u *UnionType) node()       {}
+func (u *UnionType) typeExpr()   {}
+func (u *UnionType) GetPos() Pos { return u.Pos }
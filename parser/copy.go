@@ -0,0 +1,71 @@
+package parser
+
+import "reflect"
+
+// CopyDocument returns a deep copy of a document, which can be changed
+// without affecting the original. Nodes the original shares are shared in
+// the copy as well.
+func CopyDocument(doc *Document) *Document {
+	c := &copier{copies: make(map[any]reflect.Value)}
+	return c.copy(reflect.ValueOf(doc)).Interface().(*Document)
+}
+
+// copier copies the values of an AST, keeping track of the pointers it
+// has copied so far
+type copier struct {
+	copies map[any]reflect.Value
+}
+
+func (c *copier) copy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		if dup, ok := c.copies[v.Interface()]; ok {
+			return dup
+		}
+		dup := reflect.New(v.Type().Elem())
+		c.copies[v.Interface()] = dup
+		dup.Elem().Set(c.copy(v.Elem()))
+		return dup
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		dup := reflect.New(v.Type()).Elem()
+		dup.Set(c.copy(v.Elem()))
+		return dup
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		dup := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := range v.Len() {
+			dup.Index(i).Set(c.copy(v.Index(i)))
+		}
+		return dup
+
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		dup := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			dup.SetMapIndex(iter.Key(), c.copy(iter.Value()))
+		}
+		return dup
+
+	case reflect.Struct:
+		dup := reflect.New(v.Type()).Elem()
+		for i := range v.NumField() {
+			dup.Field(i).Set(c.copy(v.Field(i)))
+		}
+		return dup
+
+	default:
+		return v
+	}
+}
@@ -61,3 +61,31 @@ end`
 
 	t.Logf("Parse error (as expected):\n%s", parseErr.FormatWithContext())
 }
+
+func TestWithAlwaysRejectsElse(t *testing.T) {
+	input := `with Values.routes as r always do
+  name: "test"
+else
+  name: "never"
+end`
+
+	_, err := New(input, "test.helmtk").Parse()
+	if err == nil {
+		t.Fatal("expected parse error for 'always' with an else branch, got nil")
+	}
+
+	if !strings.Contains(err.Error(), "'always'") {
+		t.Errorf("error should mention 'always', got: %s", err)
+	}
+}
+
+func TestDefineRejectsDuplicateParams(t *testing.T) {
+	_, err := New(`define("makeLabel", app, app = "x") app`, "test.helmtk").Parse()
+	if err == nil {
+		t.Fatal("expected parse error for duplicate parameter, got nil")
+	}
+
+	if !strings.Contains(err.Error(), `duplicate parameter "app"`) {
+		t.Errorf("error should name the duplicate parameter, got: %s", err)
+	}
+}
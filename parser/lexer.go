@@ -40,22 +40,28 @@ const (
 	TokenTrue
 	TokenFalse
 	TokenNull
-	TokenDot    // .
-	TokenAssign // =
-	TokenPlus   // +
-	TokenMinus  // -
-	TokenMul    // *
-	TokenDiv    // /
-	TokenPipe   // |
-	TokenAnd    // &&
-	TokenOr     // ||
-	TokenEq     // ==
-	TokenNeq    // !=
-	TokenNot    // !
-	TokenLt     // <
-	TokenLte    // <=
-	TokenGt     // >
-	TokenGte    // >=
+	TokenDot         // .
+	TokenAssign      // =
+	TokenPlus        // +
+	TokenMinus       // -
+	TokenMul         // *
+	TokenDiv         // /
+	TokenPipe        // |
+	TokenAnd         // &&
+	TokenOr          // ||
+	TokenEq          // ==
+	TokenNeq         // !=
+	TokenNot         // !
+	TokenLt          // <
+	TokenLte         // <=
+	TokenGt          // >
+	TokenGte         // >=
+	TokenArrow       // =>
+	TokenPlusAssign  // +=
+	TokenMinusAssign // -=
+	TokenOrAssign    // ||=
+	TokenSeparator   // --- on a line of its own
+	TokenQuestion    // ?
 )
 
 func (t TokenType) String() string {
@@ -154,6 +160,18 @@ func (t TokenType) String() string {
 		return "'>'"
 	case TokenGte:
 		return "'>='"
+	case TokenArrow:
+		return "'=>'"
+	case TokenPlusAssign:
+		return "'+='"
+	case TokenMinusAssign:
+		return "'-='"
+	case TokenOrAssign:
+		return "'||='"
+	case TokenSeparator:
+		return "'---'"
+	case TokenQuestion:
+		return "'?'"
 	default:
 		return fmt.Sprintf("unknown(%d)", t)
 	}
@@ -164,6 +182,19 @@ type Token struct {
 	Value string
 	Line  int
 	Col   int
+	Parts []StringPart // Set on string tokens that contain ${...} interpolations
+}
+
+// StringPart is a piece of an interpolated string token. Literal parts hold
+// unescaped text; expression parts hold the raw source between "${" and "}"
+// along with its position in the input, so it can be lexed in place.
+type StringPart struct {
+	Expr   bool
+	Value  string
+	Line   int
+	Col    int
+	Offset int  // Byte offset of Value in the lexer input
+	Closed bool // For expression parts, whether the closing '}' was found
 }
 
 type Lexer struct {
@@ -273,7 +304,13 @@ func (l *Lexer) NextToken() Token {
 			l.advance()
 		}
 	case '|':
-		if l.peek() == '|' {
+		if l.peek() == '|' && l.peekN(2) == '=' {
+			token.Type = TokenOrAssign
+			token.Value = "||="
+			l.advance()
+			l.advance()
+			l.advance()
+		} else if l.peek() == '|' {
 			token.Type = TokenOr
 			token.Value = "||"
 			l.advance()
@@ -289,6 +326,11 @@ func (l *Lexer) NextToken() Token {
 			token.Value = "=="
 			l.advance()
 			l.advance()
+		} else if l.peek() == '>' {
+			token.Type = TokenArrow
+			token.Value = "=>"
+			l.advance()
+			l.advance()
 		} else {
 			token.Type = TokenAssign
 			token.Value = "="
@@ -328,12 +370,36 @@ func (l *Lexer) NextToken() Token {
 			l.advance()
 		}
 	case '+':
-		token.Type = TokenPlus
-		token.Value = "+"
-		l.advance()
+		if l.peek() == '=' {
+			token.Type = TokenPlusAssign
+			token.Value = "+="
+			l.advance()
+			l.advance()
+		} else {
+			token.Type = TokenPlus
+			token.Value = "+"
+			l.advance()
+		}
 	case '-':
-		token.Type = TokenMinus
-		token.Value = "-"
+		if l.isDocumentSeparator() {
+			token.Type = TokenSeparator
+			token.Value = "---"
+			l.advance()
+			l.advance()
+			l.advance()
+		} else if l.peek() == '=' {
+			token.Type = TokenMinusAssign
+			token.Value = "-="
+			l.advance()
+			l.advance()
+		} else {
+			token.Type = TokenMinus
+			token.Value = "-"
+			l.advance()
+		}
+	case '?':
+		token.Type = TokenQuestion
+		token.Value = "?"
 		l.advance()
 	case '*':
 		token.Type = TokenMul
@@ -359,6 +425,23 @@ func (l *Lexer) current() byte {
 	return l.input[l.pos]
 }
 
+// isDocumentSeparator reports whether the lexer is at a "---" line, which
+// separates documents like in YAML
+func (l *Lexer) isDocumentSeparator() bool {
+	if l.pos > 0 && l.input[l.pos-1] != '\n' {
+		return false
+	}
+	if !strings.HasPrefix(l.input[l.pos:], "---") {
+		return false
+	}
+	rest := l.input[l.pos+3:]
+	end := strings.IndexByte(rest, '\n')
+	if end < 0 {
+		end = len(rest)
+	}
+	return strings.TrimSpace(rest[:end]) == ""
+}
+
 func (l *Lexer) peek() byte {
 	if l.pos+1 >= len(l.input) {
 		return 0
@@ -414,8 +497,6 @@ func (l *Lexer) readComment() Token {
 }
 
 // unescapeString processes escape sequences in a string.
-// Note: \$ is handled specially to prevent interpolation - it's kept as a
-// marker (\x00$) that will be replaced by $ after interpolation processing.
 func unescapeString(s string) string {
 	if !strings.Contains(s, "\\") {
 		return s
@@ -443,8 +524,8 @@ func unescapeString(s string) string {
 				result.WriteByte('\\')
 				i++
 			case '$':
-				// Escaped $ - use null byte as marker to prevent interpolation
-				result.WriteByte('\x00')
+				// Escaped $ - the lexer has already skipped it when looking
+				// for interpolations, so it is just a literal dollar sign
 				result.WriteByte('$')
 				i++
 			default:
@@ -460,34 +541,20 @@ func unescapeString(s string) string {
 }
 
 func (l *Lexer) readString() Token {
-	start := l.pos
 	startCol := l.col
+	startLine := l.line
 	l.advance() // skip opening "
 
-	for l.pos < len(l.input) && l.current() != '"' {
-		if l.current() == '\\' {
-			l.advance() // skip escape
-		}
-		l.advance()
-	}
+	parts := l.readStringContent(func() bool { return l.current() == '"' })
 
 	if l.pos < len(l.input) {
 		l.advance() // skip closing "
 	}
 
-	// Remove quotes from value and unescape
-	value := unescapeString(l.input[start+1 : l.pos-1])
-
-	return Token{
-		Type:  TokenString,
-		Value: value,
-		Line:  l.line,
-		Col:   startCol,
-	}
+	return stringToken(parts, startLine, startCol)
 }
 
 func (l *Lexer) readMultilineString() Token {
-	start := l.pos
 	startCol := l.col
 	startLine := l.line
 
@@ -497,32 +564,113 @@ func (l *Lexer) readMultilineString() Token {
 	l.advance()
 
 	// Read until we find closing """
-	for l.pos < len(l.input) {
-		if l.current() == '"' && l.peek() == '"' && l.peekN(2) == '"' {
-			// Found closing """
-			value := unescapeString(l.input[start+3 : l.pos])
-			// Skip closing """
-			l.advance()
+	parts := l.readStringContent(func() bool {
+		return l.current() == '"' && l.peek() == '"' && l.peekN(2) == '"'
+	})
+
+	// Skip closing """ (if we didn't run out of input)
+	for i := 0; i < 3 && l.pos < len(l.input); i++ {
+		l.advance()
+	}
+
+	return stringToken(parts, startLine, startCol)
+}
+
+// readStringContent reads string content up to the closing delimiter
+// (reported by atEnd) and splits it into literal and ${...} parts.
+// Escape sequences are skipped as a unit, so "\${" never starts an
+// interpolation.
+func (l *Lexer) readStringContent(atEnd func() bool) []StringPart {
+	var parts []StringPart
+	litStart := l.pos
+
+	flush := func(end int) {
+		if end > litStart {
+			parts = append(parts, StringPart{Value: unescapeString(l.input[litStart:end])})
+		}
+	}
+
+	for l.pos < len(l.input) && !atEnd() {
+		switch {
+		case l.current() == '\\':
+			l.advance() // skip escape
 			l.advance()
+		case l.current() == '$' && l.peek() == '{':
+			flush(l.pos)
+			l.advance() // skip $
+			l.advance() // skip {
+
+			part := StringPart{Expr: true, Line: l.line, Col: l.col, Offset: l.pos}
+			part.Closed = l.skipInterpolation()
+			end := l.pos
+			if part.Closed {
+				end-- // exclude the closing }
+			}
+			part.Value = l.input[part.Offset:end]
+			parts = append(parts, part)
+			litStart = l.pos
+		default:
 			l.advance()
-			return Token{
-				Type:  TokenString,
-				Value: value,
-				Line:  startLine,
-				Col:   startCol,
+		}
+	}
+
+	flush(l.pos)
+	return parts
+}
+
+// skipInterpolation advances past the body of a ${...} interpolation,
+// balancing braces and skipping over nested strings (which may contain
+// braces or interpolations of their own). It reports whether the closing
+// '}' was found.
+func (l *Lexer) skipInterpolation() bool {
+	depth := 1
+	for l.pos < len(l.input) {
+		switch l.current() {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				l.advance()
+				return true
 			}
+		case '"':
+			if l.peek() == '"' && l.peekN(2) == '"' {
+				l.readMultilineString()
+			} else {
+				l.readString()
+			}
+			continue
 		}
 		l.advance()
 	}
+	return false
+}
 
-	// If we get here, we didn't find closing """
-	value := unescapeString(l.input[start+3:])
-	return Token{
+// stringToken builds a string token from its parts. Plain strings carry
+// only their unescaped Value; interpolated strings also keep their Parts.
+func stringToken(parts []StringPart, line, col int) Token {
+	var value strings.Builder
+	interpolated := false
+	for _, part := range parts {
+		if part.Expr {
+			interpolated = true
+			value.WriteString("${" + part.Value + "}")
+		} else {
+			value.WriteString(part.Value)
+		}
+	}
+
+	tok := Token{
 		Type:  TokenString,
-		Value: value,
-		Line:  startLine,
-		Col:   startCol,
+		Value: value.String(),
+		Line:  line,
+		Col:   col,
+	}
+	if interpolated {
+		tok.Parts = parts
 	}
+	return tok
 }
 
 func (l *Lexer) readNumber() Token {
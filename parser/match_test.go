@@ -0,0 +1,81 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMatchStatement(t *testing.T) {
+	input := `type: match Values.service do
+  case "ClusterIP", "NodePort" => "internal"
+  case {type: "LoadBalancer", port: p} if p > 1024 => "high"
+  case _ => "other"
+end`
+
+	doc, err := New(input, "test.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	kv, ok := doc.Body[0].(*KeyValueStatement)
+	if !ok {
+		t.Fatalf("expected KeyValue, got %T", doc.Body[0])
+	}
+
+	m, ok := kv.Value.(*MatchStatement)
+	if !ok {
+		t.Fatalf("expected MatchStatement, got %T", kv.Value)
+	}
+
+	if len(m.Cases) != 3 {
+		t.Fatalf("expected 3 cases, got %d", len(m.Cases))
+	}
+
+	if len(m.Cases[0].Patterns) != 2 {
+		t.Errorf("expected 2 alternatives in first case, got %d", len(m.Cases[0].Patterns))
+	}
+
+	obj, ok := m.Cases[1].Patterns[0].(*ObjectPattern)
+	if !ok {
+		t.Fatalf("expected ObjectPattern, got %T", m.Cases[1].Patterns[0])
+	}
+	if len(obj.Fields) != 2 || obj.Fields[1].Key != "port" {
+		t.Errorf("unexpected object pattern fields: %+v", obj.Fields)
+	}
+	if m.Cases[1].Guard == nil {
+		t.Error("expected guard on second case")
+	}
+
+	wildcard, ok := m.Cases[2].Patterns[0].(*BindingPattern)
+	if !ok || !wildcard.IsWildcard() {
+		t.Errorf("expected wildcard pattern, got %#v", m.Cases[2].Patterns[0])
+	}
+
+	if len(doc.Warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", doc.Warnings)
+	}
+}
+
+func TestParseMatchNotExhaustive(t *testing.T) {
+	input := `config: {
+  match Values.mode do
+    case "debug" => logLevel: "debug"
+    case x if x == "trace" => logLevel: "trace"
+  end
+}`
+
+	doc, err := New(input, "test.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(doc.Warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %d", len(doc.Warnings))
+	}
+	if !strings.Contains(doc.Warnings[0].Message, "not exhaustive") {
+		t.Errorf("unexpected warning: %s", doc.Warnings[0])
+	}
+	if doc.Warnings[0].Pos.Line != 2 {
+		t.Errorf("expected warning on line 2, got %d", doc.Warnings[0].Pos.Line)
+	}
+}
@@ -63,6 +63,19 @@ func (e *ParseError) FormatWithContext() string {
 	return sb.String()
 }
 
+// Warning represents a non-fatal problem found while parsing
+type Warning struct {
+	Message string
+	Pos     Pos
+}
+
+func (w Warning) String() string {
+	if w.Pos.Filename != "" {
+		return fmt.Sprintf("%s:%d:%d: %s", w.Pos.Filename, w.Pos.Line, w.Pos.Col, w.Message)
+	}
+	return fmt.Sprintf("%d:%d: %s", w.Pos.Line, w.Pos.Col, w.Message)
+}
+
 // Parser represents a helmtk template parser
 type Parser struct {
 	lexer    *Lexer
@@ -70,6 +83,7 @@ type Parser struct {
 	peek     Token
 	source   string // Store source for error reporting
 	filename string // Source filename for position tracking
+	warnings []Warning
 }
 
 func New(source, filename string) *Parser {
@@ -129,6 +143,56 @@ func (p *Parser) error(message string) *ParseError {
 	}
 }
 
+// errorAt creates a ParseError at the given position
+func (p *Parser) errorAt(message string, line, col int) *ParseError {
+	return &ParseError{
+		Message: message,
+		Line:    line,
+		Col:     col,
+		Offset:  p.lexer.pos,
+		Source:  p.source,
+	}
+}
+
+// isContextual reports whether the current token is the given contextual
+// keyword. These are plain identifiers that only have meaning in certain
+// positions, so they remain usable as variable names and keys elsewhere.
+func (p *Parser) isContextual(word string) bool {
+	return p.currentIs(TokenIdent) && p.current.Value == word
+}
+
+// atCase reports whether the current token starts a case of a match
+// statement, rather than a key or variable named case
+func (p *Parser) atCase() bool {
+	return p.isContextual("case") && startsPattern(p.peek.Type)
+}
+
+// startsPattern reports whether a token of type t can start a match pattern
+func startsPattern(t TokenType) bool {
+	switch t {
+	case TokenIdent, TokenString, TokenNumber, TokenTrue, TokenFalse, TokenNull, TokenLBrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// startsExpression reports whether a token of type t can start an expression
+func startsExpression(t TokenType) bool {
+	switch t {
+	case TokenIdent, TokenString, TokenNumber, TokenTrue, TokenFalse, TokenNull,
+		TokenLBrace, TokenLBracket, TokenLParen, TokenInclude, TokenNot:
+		return true
+	default:
+		return false
+	}
+}
+
+// warn records a non-fatal warning at the given position
+func (p *Parser) warn(pos Pos, message string) {
+	p.warnings = append(p.warnings, Warning{Message: message, Pos: pos})
+}
+
 // Operator precedence levels (higher = tighter binding)
 const (
 	PREC_LOWEST     = iota
@@ -163,6 +227,10 @@ func (p *Parser) tokenPrecedence(t TokenType) int {
 }
 
 func (p *Parser) peekPrecedence() int {
+	// "is" binds like a comparison, and is only special after an operand
+	if p.peekIs(TokenIdent) && p.peek.Value == "is" {
+		return PREC_COMPARISON
+	}
 	return p.tokenPrecedence(p.peek.Type)
 }
 
@@ -177,16 +245,40 @@ func (p *Parser) Parse() (*Document, error) {
 			continue
 		}
 
-		if p.currentIs(TokenDefine) {
+		if p.currentIs(TokenDefine) || (p.isContextual("override") && p.peekIs(TokenDefine)) {
+			override := p.currentIs(TokenIdent)
+			if override {
+				p.nextToken() // skip 'override'
+			}
+
 			d, err := p.parseDefinition()
 			if err != nil {
 				return nil, err
 			}
+			d.Override = override
 			doc.Definitions = append(doc.Definitions, d)
 			p.skipNewlines()
 			continue
 		}
 
+		if p.isContextual("type") && p.peekIs(TokenIdent) {
+			decl, err := p.parseTypeDecl()
+			if err != nil {
+				return nil, err
+			}
+			doc.Types = append(doc.Types, decl)
+			p.nextToken()
+			p.skipNewlines()
+			continue
+		}
+
+		if p.currentIs(TokenSeparator) {
+			doc.Body = append(doc.Body, &DocumentSeparator{Pos: p.pos()})
+			p.nextToken()
+			p.skipNewlines()
+			continue
+		}
+
 		node, err := p.parseStatement()
 		if err != nil {
 			return nil, err
@@ -200,6 +292,7 @@ func (p *Parser) Parse() (*Document, error) {
 		p.skipNewlines()
 	}
 
+	doc.Warnings = p.warnings
 	return doc, nil
 }
 
@@ -219,6 +312,8 @@ func (p *Parser) parseStatement() (Statement, error) {
 		return p.parseSpread()
 	case TokenIf:
 		return p.parseIfStatement()
+	case TokenInclude:
+		return p.parseIncludeWithBlock()
 	case TokenComment:
 		// TODO
 		// return &Comment{Text: p.current.Value}, nil
@@ -229,14 +324,32 @@ func (p *Parser) parseStatement() (Statement, error) {
 			return p.parseKeyValue()
 		}
 	case TokenIdent:
-		// Check if this is an assignment statement
-		if p.peekIs(TokenAssign) {
-			return p.parseAssignmentStatement()
-		}
 		// Check if this is a key-value pair (for use in objects/conditionals)
 		if p.peekIs(TokenColon) {
 			return p.parseKeyValue()
 		}
+		// Statements introduced by contextual keywords, which are plain
+		// keys when followed by a colon
+		if p.isObjectStatement() {
+			switch p.current.Value {
+			case "hidden":
+				return p.parseHiddenKeyValue()
+			case "match":
+				return p.parseMatchStatement()
+			case "assert":
+				return p.parseAssertStatement()
+			case "fail":
+				return p.parseFailStatement()
+			case "yield":
+				return p.parseYieldStatement()
+			}
+		}
+		// An explicit document block
+		if p.isContextual("document") && (p.peekIs(TokenString) || p.peekIs(TokenIdent) || p.peekIs(TokenDo)) {
+			return p.parseDocumentBlock()
+		}
+		// Otherwise it's an expression, or an assignment to one
+		return p.parseExpressionOrAssignment()
 	case TokenEOF, TokenEnd:
 		return nil, nil
 	}
@@ -254,28 +367,102 @@ func (p *Parser) parseSpread() (*SpreadStatement, error) {
 	return &SpreadStatement{Operand: operand, Pos: pos}, nil
 }
 
-func (p *Parser) parseAssignmentStatement() (*AssignmentStatement, error) {
+// isAssignOperator reports whether t is an assignment operator
+func isAssignOperator(t TokenType) bool {
+	switch t {
+	case TokenAssign, TokenPlusAssign, TokenMinusAssign, TokenOrAssign:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseExpressionOrAssignment parses an expression, which becomes the target
+// of an assignment if an assignment operator follows it
+func (p *Parser) parseExpressionOrAssignment() (Statement, error) {
 	pos := p.pos()
 
-	if err := p.expectCurrent(TokenIdent); err != nil {
+	expr, err := p.parseExpression()
+	if err != nil {
 		return nil, err
 	}
 
-	name := p.current.Value
+	if !isAssignOperator(p.peek.Type) {
+		return expr, nil
+	}
+
+	switch expr.(type) {
+	case *Identifier, *MemberExpression, *IndexExpression:
+	default:
+		return nil, p.error("invalid assignment target")
+	}
+
+	p.nextToken() // move to operator
+	operator := p.current.Value
 	p.nextToken()
 
-	// Expect '='
-	if err := p.expectCurrent(TokenAssign); err != nil {
+	value, err := p.parseValueStatement()
+	if err != nil {
+		return nil, err
+	}
+
+	return &AssignmentStatement{
+		Target:   expr,
+		Operator: operator,
+		Value:    value,
+		Pos:      pos,
+	}, nil
+}
+
+func (p *Parser) parseAssertStatement() (*AssertStatement, error) {
+	pos := p.pos()
+	p.nextToken() // skip 'assert'
+
+	condition, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	// Optional message
+	var message Expression
+	if p.peekIs(TokenComma) {
+		p.nextToken() // move to ','
+		p.nextToken() // move to message
+		message, err = p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &AssertStatement{
+		Condition: condition,
+		Message:   message,
+		Pos:       pos,
+	}, nil
+}
+
+func (p *Parser) parseFailStatement() (*FailStatement, error) {
+	pos := p.pos()
+	p.nextToken() // skip 'fail'
+
+	// Expect '('
+	if err := p.expectCurrent(TokenLParen); err != nil {
 		return nil, err
 	}
 	p.nextToken()
 
-	value, err := p.parseValueStatement()
+	message, err := p.parseExpression()
 	if err != nil {
 		return nil, err
 	}
+	p.nextToken()
+
+	// Expect ')'
+	if err := p.expectCurrent(TokenRParen); err != nil {
+		return nil, err
+	}
 
-	return &AssignmentStatement{Name: name, Value: value, Pos: pos}, nil
+	return &FailStatement{Message: message, Pos: pos}, nil
 }
 
 func (p *Parser) parseLetStatement() (*LetStatement, error) {
@@ -289,6 +476,17 @@ func (p *Parser) parseLetStatement() (*LetStatement, error) {
 	name := p.current.Value
 	p.nextToken()
 
+	// Optional type annotation
+	var typ TypeExpr
+	if p.currentIs(TokenColon) {
+		p.nextToken() // skip ':'
+		var err error
+		if typ, err = p.parseType(); err != nil {
+			return nil, err
+		}
+		p.nextToken()
+	}
+
 	// Expect '='
 	if err := p.expectCurrent(TokenAssign); err != nil {
 		return nil, err
@@ -302,6 +500,7 @@ func (p *Parser) parseLetStatement() (*LetStatement, error) {
 
 	return &LetStatement{
 		Name:  name,
+		Type:  typ,
 		Value: value,
 		Pos:   pos,
 	}, nil
@@ -324,6 +523,23 @@ func (p *Parser) parseDefinition() (*Definition, error) {
 	name := p.current.Value
 	p.nextToken()
 
+	// Parse parameters
+	var params []*Parameter
+	for p.currentIs(TokenComma) {
+		p.nextToken() // skip comma
+
+		param, err := p.parseParameter()
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range params {
+			if other.Name == param.Name {
+				return nil, p.errorAt(fmt.Sprintf("duplicate parameter %q in template %q", param.Name, name), param.Pos.Line, param.Pos.Col)
+			}
+		}
+		params = append(params, param)
+	}
+
 	// Expect ')'
 	if err := p.expectCurrent(TokenRParen); err != nil {
 		return nil, err
@@ -377,12 +593,49 @@ func (p *Parser) parseDefinition() (*Definition, error) {
 	}
 
 	return &Definition{
-		Name: name,
-		Body: body,
-		Pos:  pos,
+		Name:   name,
+		Params: params,
+		Body:   body,
+		Pos:    pos,
 	}, nil
 }
 
+// parseParameter parses a template parameter with an optional type and
+// default value.
+// On return, the current token is the one after the parameter.
+func (p *Parser) parseParameter() (*Parameter, error) {
+	pos := p.pos()
+	if err := p.expectCurrent(TokenIdent); err != nil {
+		return nil, err
+	}
+	param := &Parameter{Name: p.current.Value, Pos: pos}
+	p.nextToken()
+
+	if p.currentIs(TokenColon) {
+		p.nextToken() // skip ':'
+
+		typ, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		param.Type = typ
+		p.nextToken()
+	}
+
+	if p.currentIs(TokenAssign) {
+		p.nextToken() // skip '='
+
+		def, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		param.Default = def
+		p.nextToken()
+	}
+
+	return param, nil
+}
+
 func (p *Parser) parseValueStatement() (ValueStatement, error) {
 	switch p.current.Type {
 	case TokenFor:
@@ -391,6 +644,12 @@ func (p *Parser) parseValueStatement() (ValueStatement, error) {
 		return p.parseWithStatement()
 	case TokenIf:
 		return p.parseIfStatement()
+	case TokenIdent:
+		if p.isContextual("match") && startsExpression(p.peek.Type) {
+			return p.parseMatchStatement()
+		}
+	case TokenInclude:
+		return p.parseIncludeWithBlock()
 	case TokenComment:
 		// TODO
 		// return &Comment{Text: p.current.Value}, nil
@@ -429,14 +688,139 @@ func (p *Parser) parseKeyValue() (*KeyValueStatement, error) {
 	}, nil
 }
 
+// isObjectStatement reports whether the current identifier starts a
+// statement that's allowed in objects without being followed by a colon
+func (p *Parser) isObjectStatement() bool {
+	switch {
+	case p.isContextual("hidden"):
+		return p.peekIs(TokenIdent) || p.peekIs(TokenString)
+	case p.isContextual("super"), p.isContextual("fail"):
+		return p.peekIs(TokenLParen)
+	case p.isContextual("match"), p.isContextual("assert"):
+		return startsExpression(p.peek.Type)
+	case p.isContextual("yield"):
+		return p.peekIs(TokenIdent) || endsStatement(p.peek)
+	default:
+		return false
+	}
+}
+
+// parseDocumentBlock parses an explicit document block, with an optional
+// output filename and options:
+//
+//	document "deployment.yaml", weight = 10 do
+//	  kind: "Deployment"
+//	end
+func (p *Parser) parseDocumentBlock() (*DocumentBlock, error) {
+	block := &DocumentBlock{Pos: p.pos()}
+	p.nextToken() // skip 'document'
+
+	if !p.currentIs(TokenDo) {
+		name, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		block.Name = name
+		p.nextToken()
+	}
+
+	// Options, as name = value pairs
+	for p.currentIs(TokenComma) {
+		p.nextToken() // skip comma
+
+		if err := p.expectCurrent(TokenIdent); err != nil {
+			return nil, err
+		}
+		option := p.current.Value
+		if option != "weight" {
+			return nil, p.error(fmt.Sprintf("unknown document option %q", option))
+		}
+		p.nextToken()
+
+		if err := p.expectCurrent(TokenAssign); err != nil {
+			return nil, err
+		}
+		p.nextToken()
+
+		val, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		block.Weight = val
+		p.nextToken()
+	}
+
+	if err := p.expectCurrent(TokenDo); err != nil {
+		return nil, err
+	}
+	p.nextToken() // skip 'do'
+	p.skipNewlines()
+
+	block.Body = []Node{}
+	for !p.currentIs(TokenEnd) && !p.currentIs(TokenEOF) {
+		// Skip comments and newlines
+		if p.currentIs(TokenComment) || p.currentIs(TokenNewline) {
+			p.nextToken()
+			continue
+		}
+
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		block.Body = append(block.Body, stmt)
+		p.nextToken()
+		p.skipNewlines()
+
+		// Optional comma
+		if p.currentIs(TokenComma) {
+			p.nextToken()
+			p.skipNewlines()
+		}
+	}
+
+	if err := p.expectCurrent(TokenEnd); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// parseHiddenKeyValue parses "hidden key: value"
+func (p *Parser) parseHiddenKeyValue() (*KeyValueStatement, error) {
+	pos := p.pos()
+	p.nextToken() // skip 'hidden'
+
+	if !p.peekIs(TokenColon) {
+		return nil, p.error(fmt.Sprintf("expected ':' after hidden key %q", p.current.Value))
+	}
+
+	kv, err := p.parseKeyValue()
+	if err != nil {
+		return nil, err
+	}
+	kv.Hidden = true
+	kv.Pos = pos
+	return kv, nil
+}
+
 // expectStatementEnd checks that the current position is a valid statement terminator
 func (p *Parser) expectStatementEnd() error {
-	// Valid terminators: newline, comma, closing brace/bracket, end, else, EOF
-	switch p.peek.Type {
-	case TokenNewline, TokenComma, TokenRBrace, TokenRBracket, TokenEnd, TokenElse, TokenEOF:
+	if endsStatement(p.peek) {
 		return nil
+	}
+	return p.error(fmt.Sprintf("unexpected token %v after expression", p.peek.Type))
+}
+
+// endsStatement reports whether a token can follow a statement: newline,
+// comma, closing brace/bracket, end, else, case or EOF
+func endsStatement(tok Token) bool {
+	switch tok.Type {
+	case TokenNewline, TokenComma, TokenRBrace, TokenRBracket, TokenEnd, TokenElse, TokenEOF:
+		return true
+	case TokenIdent:
+		return tok.Value == "case"
 	default:
-		return p.error(fmt.Sprintf("unexpected token %v after expression", p.peek.Type))
+		return false
 	}
 }
 
@@ -454,6 +838,19 @@ func (p *Parser) parseValueWithPrecedence(minPrecedence int) (Expression, error)
 	for p.peekPrecedence() > minPrecedence {
 		p.nextToken() // move to operator
 		pos := p.pos()
+
+		// Type checks take a single type, so unions need parentheses and
+		// don't clash with pipes
+		if p.isContextual("is") {
+			p.nextToken() // move to type
+			typ, err := p.parseTypeTerm()
+			if err != nil {
+				return nil, err
+			}
+			left = &IsExpression{Value: left, Type: typ, Pos: pos}
+			continue
+		}
+
 		operator := p.current.Value
 		precedence := p.tokenPrecedence(p.current.Type)
 
@@ -592,6 +989,12 @@ func (p *Parser) parsePrimaryValue() (Expression, error) {
 		return &NumberLiteral{Value: num, Pos: pos}, nil
 
 	case TokenIdent:
+		if p.current.Value == "super" && p.peekIs(TokenLParen) {
+			return p.parseSuperExpression()
+		}
+		if p.isContextual("try") && startsExpression(p.peek.Type) {
+			return p.parseTryExpression()
+		}
 		return p.parseIdentifier()
 
 	case TokenNot:
@@ -627,140 +1030,305 @@ func (p *Parser) parseIdentifier() (*Identifier, error) {
 	return &Identifier{Name: p.current.Value, Pos: p.pos()}, nil
 }
 
-func (p *Parser) parseIncludeExpression() (*IncludeExpression, error) {
-	pos := p.pos()
-	p.nextToken() // skip 'include'
-
-	// Expect '('
-	if err := p.expectCurrent(TokenLParen); err != nil {
+// parseIncludeWithBlock parses an include in statement or value position,
+// where it may be followed by a content block:
+//
+//	include("container", {name: "web"}) do
+//	  env: [...]
+//	  slot ports do ... end
+//	end
+//
+// Inside other expressions (e.g., an if condition) a following 'do'
+// belongs to the enclosing statement, so blocks are only parsed here.
+func (p *Parser) parseIncludeWithBlock() (Expression, error) {
+	expr, err := p.parseExpression()
+	if err != nil {
 		return nil, err
 	}
-	p.nextToken()
 
-	// Parse name (first argument - must be a string)
-	if err := p.expectCurrent(TokenString); err != nil {
-		return nil, err
+	inc, ok := expr.(*IncludeExpression)
+	if !ok || !p.peekIs(TokenDo) {
+		return expr, nil
 	}
-	name := p.current.Value
 	p.nextToken()
+	p.nextToken() // skip 'do'
+	p.skipNewlines()
 
-	// Parse arguments
-	var context Expression
-	if p.currentIs(TokenComma) {
-		p.nextToken() // skip comma
-
-		arg, err := p.parseExpression()
-		if err != nil {
-			return nil, err
+	inc.Block = []Node{}
+	for !p.currentIs(TokenEnd) && !p.currentIs(TokenEOF) {
+		// Skip comments and newlines
+		if p.currentIs(TokenComment) || p.currentIs(TokenNewline) {
+			p.nextToken()
+			continue
 		}
 
-		context = arg
-		p.nextToken()
-	}
-
-	// Expect ')'
-	if err := p.expectCurrent(TokenRParen); err != nil {
+		// Named slots, "slot" is only special when followed by a name
+		if p.isContextual("slot") && p.peekIs(TokenIdent) {
+			slot, err := p.parseSlot()
+			if err != nil {
+				return nil, err
+			}
+			for _, other := range inc.Slots {
+				if other.Name == slot.Name {
+					return nil, p.errorAt(fmt.Sprintf("duplicate slot %q", slot.Name), slot.Pos.Line, slot.Pos.Col)
+				}
+			}
+			inc.Slots = append(inc.Slots, slot)
+		} else {
+			stmt, err := p.parseStatement()
+			if err != nil {
+				return nil, err
+			}
+			inc.Block = append(inc.Block, stmt)
+		}
+		p.nextToken()
+		p.skipNewlines()
+
+		// Optional comma
+		if p.currentIs(TokenComma) {
+			p.nextToken()
+			p.skipNewlines()
+		}
+	}
+
+	if err := p.expectCurrent(TokenEnd); err != nil {
 		return nil, err
 	}
 
-	return &IncludeExpression{
-		Name:    name,
-		Context: context,
-		Pos:     pos,
-	}, nil
+	return inc, nil
 }
 
-func (p *Parser) parseStringLiteral() (Expression, error) {
-	stringPos := p.pos()
-	str := p.current.Value
-
-	// Check if string contains interpolation (but not escaped \x00${)
-	hasInterpolation := false
-	for i := 0; i < len(str)-1; i++ {
-		if str[i] == '$' && str[i+1] == '{' {
-			// Check if this ${ is escaped (preceded by \x00)
-			if i > 0 && str[i-1] == '\x00' {
-				continue
-			}
-			hasInterpolation = true
-			break
+// parseSlot parses a named content block, ending on its 'end' token
+func (p *Parser) parseSlot() (*Slot, error) {
+	pos := p.pos()
+	p.nextToken() // skip 'slot'
+
+	slot := &Slot{Name: p.current.Value, Body: []Node{}, Pos: pos}
+	p.nextToken()
+
+	if err := p.expectCurrent(TokenDo); err != nil {
+		return nil, err
+	}
+	p.nextToken() // skip 'do'
+	p.skipNewlines()
+
+	for !p.currentIs(TokenEnd) && !p.currentIs(TokenEOF) {
+		// Skip comments and newlines
+		if p.currentIs(TokenComment) || p.currentIs(TokenNewline) {
+			p.nextToken()
+			continue
+		}
+
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		slot.Body = append(slot.Body, stmt)
+		p.nextToken()
+		p.skipNewlines()
+
+		// Optional comma
+		if p.currentIs(TokenComma) {
+			p.nextToken()
+			p.skipNewlines()
 		}
 	}
 
-	if !hasInterpolation {
-		// No interpolation, just clean up escaped $ markers
-		cleanStr := strings.ReplaceAll(str, "\x00$", "$")
-		return &StringLiteral{Value: cleanStr, Pos: stringPos}, nil
+	if err := p.expectCurrent(TokenEnd); err != nil {
+		return nil, err
 	}
 
-	// Parse interpolated string
-	parts := []Expression{}
-	pos := 0
+	return slot, nil
+}
 
-	for {
-		// Find next interpolation (skip \x00$ which is escaped)
-		start := -1
-		searchPos := pos
-		for searchPos < len(str) {
-			idx := strings.Index(str[searchPos:], "${")
-			if idx == -1 {
-				break
-			}
-			// Check if this ${ is escaped (preceded by \x00)
-			absoluteIdx := searchPos + idx
-			if absoluteIdx > 0 && str[absoluteIdx-1] == '\x00' {
-				// This is an escaped ${, skip it
-				searchPos = absoluteIdx + 2
-				continue
-			}
-			start = idx
-			pos = searchPos
-			break
+// parseSuperExpression parses "super()" or "super(context)"
+func (p *Parser) parseSuperExpression() (*SuperExpression, error) {
+	sup := &SuperExpression{Pos: p.pos()}
+	p.nextToken() // skip 'super'
+	p.nextToken() // skip '('
+
+	if !p.currentIs(TokenRParen) {
+		ctx, err := p.parseExpression()
+		if err != nil {
+			return nil, err
 		}
+		sup.Context = ctx
+		p.nextToken()
+	}
 
-		if start == -1 {
-			// No more interpolations, add remaining string if non-empty
-			if pos < len(str) {
-				cleanStr := strings.ReplaceAll(str[pos:], "\x00$", "$")
-				parts = append(parts, &StringLiteral{Value: cleanStr, Pos: stringPos})
-			}
-			break
+	if err := p.expectCurrent(TokenRParen); err != nil {
+		return nil, err
+	}
+	return sup, nil
+}
+
+// parseYieldStatement parses "yield" or "yield name"
+func (p *Parser) parseYieldStatement() (*YieldStatement, error) {
+	y := &YieldStatement{Pos: p.pos()}
+	if p.peekIs(TokenIdent) {
+		p.nextToken()
+		y.Name = p.current.Value
+	}
+	return y, nil
+}
+
+func (p *Parser) parseIncludeExpression() (*IncludeExpression, error) {
+	pos := p.pos()
+	p.nextToken() // skip 'include'
+
+	// Expect '('
+	if err := p.expectCurrent(TokenLParen); err != nil {
+		return nil, err
+	}
+	p.nextToken()
+
+	// Parse name (first argument). Plain string literals are kept as the
+	// name, so the templates a document uses can be listed without evaluating
+	nameExpr, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	var name string
+	if lit, ok := nameExpr.(*StringLiteral); ok {
+		name, nameExpr = lit.Value, nil
+	}
+	p.nextToken()
+
+	// Parse arguments
+	var context Expression
+	if p.currentIs(TokenComma) {
+		p.nextToken() // skip comma
+
+		arg, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+
+		context = arg
+		p.nextToken()
+	}
+
+	// Expect ')'
+	if err := p.expectCurrent(TokenRParen); err != nil {
+		return nil, err
+	}
+
+	return &IncludeExpression{
+		Name:     name,
+		NameExpr: nameExpr,
+		Context:  context,
+		Pos:      pos,
+	}, nil
+}
+
+func (p *Parser) parseTryExpression() (*TryExpression, error) {
+	pos := p.pos()
+	p.nextToken() // skip 'try'
+
+	body, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	p.nextToken()
+	p.skipNewlines()
+
+	var errorVar string
+	switch {
+	case p.currentIs(TokenElse):
+		p.nextToken() // skip 'else'
+	case p.isContextual("catch"):
+		p.nextToken() // skip 'catch'
+		if err := p.expectCurrent(TokenIdent); err != nil {
+			return nil, err
 		}
+		errorVar = p.current.Value
+		p.nextToken()
 
-		// Add string before interpolation if non-empty
-		if start > 0 {
-			cleanStr := strings.ReplaceAll(str[pos:pos+start], "\x00$", "$")
-			parts = append(parts, &StringLiteral{Value: cleanStr, Pos: stringPos})
+		if err := p.expectCurrent(TokenArrow); err != nil {
+			return nil, err
 		}
+		p.nextToken() // skip '=>'
+	default:
+		return nil, p.error(fmt.Sprintf("expected 'else' or 'catch', got %v", p.current.Type))
+	}
+
+	fallback, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TryExpression{
+		Body:     body,
+		ErrorVar: errorVar,
+		Fallback: fallback,
+		Pos:      pos,
+	}, nil
+}
+
+func (p *Parser) parseStringLiteral() (Expression, error) {
+	stringPos := p.pos()
+
+	if p.current.Parts == nil {
+		return &StringLiteral{Value: p.current.Value, Pos: stringPos}, nil
+	}
 
-		// Find end of interpolation
-		pos += start + 2 // skip "${"
-		end := strings.Index(str[pos:], "}")
-		if end == -1 {
-			return nil, p.error("unclosed interpolation in string")
+	// Parse interpolated string
+	parts := []Expression{}
+	for _, part := range p.current.Parts {
+		if !part.Expr {
+			if part.Value != "" {
+				parts = append(parts, &StringLiteral{Value: part.Value, Pos: stringPos})
+			}
+			continue
 		}
 
-		// Parse the expression inside ${}
-		exprStr := str[pos : pos+end]
-		exprParser := &Parser{
-			lexer:    NewLexer(exprStr),
-			filename: p.filename,
+		if !part.Closed {
+			return nil, p.errorAt("unclosed interpolation in string", part.Line, part.Col)
 		}
-		exprParser.nextToken()
-		exprParser.nextToken()
 
-		expr, err := exprParser.parseExpression()
+		expr, err := p.parseInterpolation(part)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse interpolation expression %q: %w", exprStr, err)
+			return nil, err
 		}
-
 		parts = append(parts, expr)
-		pos += end + 1 // skip past "}"
 	}
 
 	return &InterpolatedString{Parts: parts, Pos: stringPos}, nil
 }
 
+// parseInterpolation parses the expression inside a ${...} string part.
+// The expression is lexed in place within the original input, so token
+// positions and error context refer to the real file line and column.
+func (p *Parser) parseInterpolation(part StringPart) (Expression, error) {
+	exprParser := &Parser{
+		lexer: &Lexer{
+			input: p.lexer.input[:part.Offset+len(part.Value)],
+			pos:   part.Offset,
+			line:  part.Line,
+			col:   part.Col,
+		},
+		source:   p.source,
+		filename: p.filename,
+	}
+	exprParser.nextToken()
+	exprParser.nextToken()
+	exprParser.skipNewlines()
+
+	expr, err := exprParser.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	exprParser.nextToken()
+	exprParser.skipNewlines()
+	if !exprParser.currentIs(TokenEOF) || exprParser.current.Value != "" {
+		return nil, exprParser.error(fmt.Sprintf("unexpected token %v in interpolation", exprParser.current.Type))
+	}
+
+	return expr, nil
+}
+
 func (p *Parser) parseObject() (*Object, error) {
 	obj := &Object{Pos: p.pos()}
 
@@ -774,8 +1342,9 @@ func (p *Parser) parseObject() (*Object, error) {
 			continue
 		}
 
-		// In object context, identifiers must be followed by colon (key-value pairs)
-		if p.currentIs(TokenIdent) && !p.peekIs(TokenColon) {
+		// In object context, identifiers must be followed by colon (key-value
+		// pairs), apart from hidden keys and super()
+		if p.currentIs(TokenIdent) && !p.peekIs(TokenColon) && !p.isObjectStatement() {
 			return nil, p.error(fmt.Sprintf("expected ':', got %v", p.peek.Type))
 		}
 		if p.currentIs(TokenString) && !p.peekIs(TokenColon) {
@@ -944,30 +1513,17 @@ func (p *Parser) parseIfStatement() (*IfStatement, error) {
 	}, nil
 }
 
-func (p *Parser) parseWithStatement() (*WithStatement, error) {
+func (p *Parser) parseMatchStatement() (*MatchStatement, error) {
 	pos := p.pos()
-	p.nextToken() // skip 'with'
+	p.nextToken() // skip 'match'
 
-	context, err := p.parseExpression()
+	subject, err := p.parseExpression()
 	if err != nil {
 		return nil, err
 	}
 
 	p.nextToken()
 
-	// Parse required "as <name>"
-	if err := p.expectCurrent(TokenAs); err != nil {
-		return nil, err
-	}
-	p.nextToken() // skip 'as'
-
-	if err := p.expectCurrent(TokenIdent); err != nil {
-		return nil, err
-	}
-
-	varName := p.current.Value
-	p.nextToken()
-
 	if err := p.expectCurrent(TokenDo); err != nil {
 		return nil, err
 	}
@@ -975,7 +1531,7 @@ func (p *Parser) parseWithStatement() (*WithStatement, error) {
 	p.nextToken() // skip 'do'
 	p.skipNewlines()
 
-	body := []Node{}
+	var cases []*MatchCase
 	for !p.currentIs(TokenEnd) && !p.currentIs(TokenEOF) {
 		// Skip comments and newlines
 		if p.currentIs(TokenComment) || p.currentIs(TokenNewline) {
@@ -983,78 +1539,85 @@ func (p *Parser) parseWithStatement() (*WithStatement, error) {
 			continue
 		}
 
-		stmt, err := p.parseStatement()
+		if !p.atCase() {
+			return nil, p.error(fmt.Sprintf("expected 'case', got %v", p.current.Type))
+		}
+
+		c, err := p.parseMatchCase()
 		if err != nil {
 			return nil, err
 		}
+		cases = append(cases, c)
+	}
 
-		body = append(body, stmt)
-		p.nextToken()
-		p.skipNewlines()
+	if !p.currentIs(TokenEnd) {
+		return nil, p.error(fmt.Sprintf("expected 'end', got %v", p.current.Type))
+	}
 
-		// Optional comma
-		if p.currentIs(TokenComma) {
-			p.nextToken()
-			p.skipNewlines()
+	// Without an unguarded binding or wildcard case, some values fall through
+	exhaustive := false
+	for _, c := range cases {
+		if c.Guard != nil {
+			continue
+		}
+		for _, pat := range c.Patterns {
+			if _, ok := pat.(*BindingPattern); ok {
+				exhaustive = true
+			}
 		}
 	}
-
-	if !p.currentIs(TokenEnd) {
-		return nil, fmt.Errorf("expected 'end', got %v", p.current.Type)
+	if !exhaustive {
+		p.warn(pos, "match is not exhaustive, add a default case (case _ => ...)")
 	}
 
-	return &WithStatement{
-		Context: context,
-		VarName: varName,
-		Body:    body,
+	return &MatchStatement{
+		Subject: subject,
+		Cases:   cases,
 		Pos:     pos,
 	}, nil
 }
 
-func (p *Parser) parseForStatement() (*ForStatement, error) {
+func (p *Parser) parseMatchCase() (*MatchCase, error) {
 	pos := p.pos()
-	p.nextToken() // skip 'for'
-
-	if err := p.expectCurrent(TokenIdent); err != nil {
-		return nil, err
-	}
-
-	keyVar := p.current.Value
-	p.nextToken()
-
-	if err := p.expectCurrent(TokenComma); err != nil {
-		return nil, err
-	}
-	p.nextToken()
-
-	if err := p.expectCurrent(TokenIdent); err != nil {
-		return nil, err
-	}
+	p.nextToken() // skip 'case'
 
-	valueVar := p.current.Value
-	p.nextToken()
+	// Parse comma-separated alternatives
+	var patterns []Pattern
+	for {
+		pat, err := p.parsePattern()
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pat)
+		p.nextToken()
 
-	if err := p.expectCurrent(TokenIn); err != nil {
-		return nil, err
+		if !p.currentIs(TokenComma) {
+			break
+		}
+		p.nextToken() // skip ','
 	}
-	p.nextToken()
 
-	iterable, err := p.parseExpression()
-	if err != nil {
-		return nil, err
+	// Optional guard
+	var guard Expression
+	if p.currentIs(TokenIf) {
+		p.nextToken() // skip 'if'
+		cond, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		guard = cond
+		p.nextToken()
 	}
 
-	p.nextToken()
-
-	if err := p.expectCurrent(TokenDo); err != nil {
+	if err := p.expectCurrent(TokenArrow); err != nil {
 		return nil, err
 	}
 
-	p.nextToken() // skip 'do'
+	p.nextToken() // skip '=>'
 	p.skipNewlines()
 
 	body := []Node{}
-	for !p.currentIs(TokenEnd) && !p.currentIs(TokenEOF) {
+	for !p.atCase() && !p.currentIs(TokenEnd) && !p.currentIs(TokenEOF) {
 		// Skip comments and newlines
 		if p.currentIs(TokenComment) || p.currentIs(TokenNewline) {
 			p.nextToken()
@@ -1077,15 +1640,511 @@ func (p *Parser) parseForStatement() (*ForStatement, error) {
 		}
 	}
 
-	if !p.currentIs(TokenEnd) {
-		return nil, fmt.Errorf("expected 'end', got %v", p.current.Type)
-	}
-
-	return &ForStatement{
-		KeyVar:   keyVar,
-		ValueVar: valueVar,
-		Iterable: iterable,
+	return &MatchCase{
+		Patterns: patterns,
+		Guard:    guard,
 		Body:     body,
 		Pos:      pos,
 	}, nil
 }
+
+// parsePattern parses a single match pattern, leaving the current token on
+// the last token of the pattern
+func (p *Parser) parsePattern() (Pattern, error) {
+	pos := p.pos()
+
+	switch p.current.Type {
+	case TokenString:
+		if p.current.Parts != nil {
+			return nil, p.error("interpolated strings cannot be used as patterns")
+		}
+		fallthrough
+	case TokenNumber, TokenTrue, TokenFalse, TokenNull:
+		value, err := p.parsePrimaryValue()
+		if err != nil {
+			return nil, err
+		}
+		return &LiteralPattern{Value: value, Pos: pos}, nil
+
+	case TokenIdent:
+		return &BindingPattern{Name: p.current.Value, Pos: pos}, nil
+
+	case TokenLBrace:
+		return p.parseObjectPattern()
+
+	default:
+		return nil, p.error(fmt.Sprintf("unexpected token %v in pattern", p.current.Type))
+	}
+}
+
+func (p *Parser) parseObjectPattern() (*ObjectPattern, error) {
+	obj := &ObjectPattern{Pos: p.pos()}
+
+	p.nextToken() // skip '{'
+	p.skipNewlines()
+
+	for !p.currentIs(TokenRBrace) && !p.currentIs(TokenEOF) {
+		// Skip comments and newlines
+		if p.currentIs(TokenComment) || p.currentIs(TokenNewline) {
+			p.nextToken()
+			continue
+		}
+
+		if !p.currentIs(TokenIdent) && !p.currentIs(TokenString) {
+			return nil, p.error(fmt.Sprintf("expected field name, got %v", p.current.Type))
+		}
+
+		pos := p.pos()
+		key := p.current.Value
+		p.nextToken()
+
+		if err := p.expectCurrent(TokenColon); err != nil {
+			return nil, err
+		}
+		p.nextToken()
+
+		pat, err := p.parsePattern()
+		if err != nil {
+			return nil, err
+		}
+
+		obj.Fields = append(obj.Fields, &FieldPattern{Key: key, Pattern: pat, Pos: pos})
+		p.nextToken()
+		p.skipNewlines()
+
+		// Optional comma
+		if p.currentIs(TokenComma) {
+			p.nextToken()
+			p.skipNewlines()
+		}
+	}
+
+	if !p.currentIs(TokenRBrace) {
+		return nil, p.error(fmt.Sprintf("expected '}', got %v", p.current.Type))
+	}
+
+	return obj, nil
+}
+
+func (p *Parser) parseWithStatement() (*WithStatement, error) {
+	pos := p.pos()
+	p.nextToken() // skip 'with'
+
+	context, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	p.nextToken()
+
+	// Parse required "as <name>"
+	if err := p.expectCurrent(TokenAs); err != nil {
+		return nil, err
+	}
+	p.nextToken() // skip 'as'
+
+	if err := p.expectCurrent(TokenIdent); err != nil {
+		return nil, err
+	}
+
+	varName := p.current.Value
+	p.nextToken()
+
+	// Optional "always" modifier binds the context even when it's falsy
+	always := false
+	if p.isContextual("always") {
+		always = true
+		p.nextToken()
+	}
+
+	if err := p.expectCurrent(TokenDo); err != nil {
+		return nil, err
+	}
+
+	p.nextToken() // skip 'do'
+	p.skipNewlines()
+
+	body := []Node{}
+	for !p.currentIs(TokenElse) && !p.currentIs(TokenEnd) && !p.currentIs(TokenEOF) {
+		// Skip comments and newlines
+		if p.currentIs(TokenComment) || p.currentIs(TokenNewline) {
+			p.nextToken()
+			continue
+		}
+
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+
+		body = append(body, stmt)
+		p.nextToken()
+		p.skipNewlines()
+
+		// Optional comma
+		if p.currentIs(TokenComma) {
+			p.nextToken()
+			p.skipNewlines()
+		}
+	}
+
+	// Check for optional else clause
+	elseBody := []Node{}
+	if p.currentIs(TokenElse) {
+		p.nextToken() // skip 'else'
+		p.skipNewlines()
+
+		for !p.currentIs(TokenEnd) && !p.currentIs(TokenEOF) {
+			// Skip comments and newlines
+			if p.currentIs(TokenComment) || p.currentIs(TokenNewline) {
+				p.nextToken()
+				continue
+			}
+
+			stmt, err := p.parseStatement()
+			if err != nil {
+				return nil, err
+			}
+
+			elseBody = append(elseBody, stmt)
+			p.nextToken()
+			p.skipNewlines()
+
+			// Optional comma
+			if p.currentIs(TokenComma) {
+				p.nextToken()
+				p.skipNewlines()
+			}
+		}
+	}
+
+	if !p.currentIs(TokenEnd) {
+		return nil, fmt.Errorf("expected 'end', got %v", p.current.Type)
+	}
+
+	if always && len(elseBody) > 0 {
+		return nil, p.errorAt("'else' is never taken in an 'always' with statement", pos.Line, pos.Col)
+	}
+
+	return &WithStatement{
+		Context: context,
+		VarName: varName,
+		Always:  always,
+		Body:    body,
+		Else:    elseBody,
+		Pos:     pos,
+	}, nil
+}
+
+func (p *Parser) parseForStatement() (*ForStatement, error) {
+	pos := p.pos()
+	p.nextToken() // skip 'for'
+
+	if err := p.expectCurrent(TokenIdent); err != nil {
+		return nil, err
+	}
+
+	keyVar := ""
+	valueVar := p.current.Value
+	p.nextToken()
+
+	// Optional second variable, the first one then binds the key
+	if p.currentIs(TokenComma) {
+		p.nextToken()
+
+		if err := p.expectCurrent(TokenIdent); err != nil {
+			return nil, err
+		}
+
+		keyVar = valueVar
+		valueVar = p.current.Value
+		p.nextToken()
+	}
+
+	if err := p.expectCurrent(TokenIn); err != nil {
+		return nil, err
+	}
+	p.nextToken()
+
+	// Optional "sorted" modifier, sorting by key
+	var sort *SortClause
+	if p.isContextual("sorted") && startsExpression(p.peek.Type) {
+		sort = &SortClause{Pos: p.pos()}
+		p.nextToken()
+	}
+
+	iterable, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	p.nextToken()
+
+	// Optional "sort by <expr>" clause
+	if sort == nil && p.isContextual("sort") {
+		sort = &SortClause{Pos: p.pos()}
+		p.nextToken() // skip 'sort'
+
+		if !p.isContextual("by") {
+			return nil, p.error(fmt.Sprintf("expected 'by', got %v", p.current.Type))
+		}
+		p.nextToken() // skip 'by'
+
+		sort.By, err = p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		p.nextToken()
+	}
+
+	// Optional "desc" modifier for either form
+	if sort != nil && p.isContextual("desc") {
+		sort.Desc = true
+		p.nextToken()
+	}
+
+	// Optional filter clause
+	var filter Expression
+	if p.currentIs(TokenIf) {
+		p.nextToken() // skip 'if'
+		filter, err = p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		p.nextToken()
+	}
+
+	if err := p.expectCurrent(TokenDo); err != nil {
+		return nil, err
+	}
+
+	p.nextToken() // skip 'do'
+	p.skipNewlines()
+
+	body := []Node{}
+	for !p.currentIs(TokenElse) && !p.currentIs(TokenEnd) && !p.currentIs(TokenEOF) {
+		// Skip comments and newlines
+		if p.currentIs(TokenComment) || p.currentIs(TokenNewline) {
+			p.nextToken()
+			continue
+		}
+
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+
+		body = append(body, stmt)
+		p.nextToken()
+		p.skipNewlines()
+
+		// Optional comma
+		if p.currentIs(TokenComma) {
+			p.nextToken()
+			p.skipNewlines()
+		}
+	}
+
+	// Check for optional else clause
+	elseBody := []Node{}
+	if p.currentIs(TokenElse) {
+		p.nextToken() // skip 'else'
+		p.skipNewlines()
+
+		for !p.currentIs(TokenEnd) && !p.currentIs(TokenEOF) {
+			// Skip comments and newlines
+			if p.currentIs(TokenComment) || p.currentIs(TokenNewline) {
+				p.nextToken()
+				continue
+			}
+
+			stmt, err := p.parseStatement()
+			if err != nil {
+				return nil, err
+			}
+
+			elseBody = append(elseBody, stmt)
+			p.nextToken()
+			p.skipNewlines()
+
+			// Optional comma
+			if p.currentIs(TokenComma) {
+				p.nextToken()
+				p.skipNewlines()
+			}
+		}
+	}
+
+	if !p.currentIs(TokenEnd) {
+		return nil, fmt.Errorf("expected 'end', got %v", p.current.Type)
+	}
+
+	return &ForStatement{
+		KeyVar:   keyVar,
+		ValueVar: valueVar,
+		Iterable: iterable,
+		Sort:     sort,
+		Filter:   filter,
+		Body:     body,
+		Else:     elseBody,
+		Pos:      pos,
+	}, nil
+}
+
+// parseTypeDecl parses a named type declaration (type Name = T). On return,
+// the current token is the last one of the type.
+func (p *Parser) parseTypeDecl() (*TypeDecl, error) {
+	pos := p.pos()
+	p.nextToken() // skip 'type'
+
+	name := p.current.Value
+	p.nextToken()
+
+	if err := p.expectCurrent(TokenAssign); err != nil {
+		return nil, err
+	}
+	p.nextToken()
+
+	typ, err := p.parseType()
+	if err != nil {
+		return nil, err
+	}
+	return &TypeDecl{Name: name, Type: typ, Pos: pos}, nil
+}
+
+// parseType parses a type, which may be a union of several types separated
+// by '|'. On return, the current token is the last one of the type.
+func (p *Parser) parseType() (TypeExpr, error) {
+	pos := p.pos()
+	first, err := p.parseTypeTerm()
+	if err != nil {
+		return nil, err
+	}
+	if !p.peekIs(TokenPipe) {
+		return first, nil
+	}
+
+	union := &UnionType{Types: []TypeExpr{first}, Pos: pos}
+	for p.peekIs(TokenPipe) {
+		p.nextToken() // move to '|'
+		p.nextToken() // move to type
+		typ, err := p.parseTypeTerm()
+		if err != nil {
+			return nil, err
+		}
+		union.Types = append(union.Types, typ)
+	}
+	return union, nil
+}
+
+// parseTypeTerm parses a single type: a name, a literal, an array or object
+// type, or a type in parentheses
+func (p *Parser) parseTypeTerm() (TypeExpr, error) {
+	pos := p.pos()
+
+	switch p.current.Type {
+	case TokenIdent:
+		return &NamedType{Name: p.current.Value, Pos: pos}, nil
+	case TokenNull:
+		return &NamedType{Name: "null", Pos: pos}, nil
+	case TokenString:
+		if len(p.current.Parts) > 0 {
+			return nil, p.error("string types can't be interpolated")
+		}
+		return &LiteralType{Value: &StringLiteral{Value: p.current.Value, Pos: pos}, Pos: pos}, nil
+	case TokenNumber:
+		num, err := strconv.ParseFloat(p.current.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q: %w", p.current.Value, err)
+		}
+		return &LiteralType{Value: &NumberLiteral{Value: num, Pos: pos}, Pos: pos}, nil
+	case TokenTrue, TokenFalse:
+		return &LiteralType{Value: &BooleanLiteral{Value: p.currentIs(TokenTrue), Pos: pos}, Pos: pos}, nil
+	case TokenLBracket:
+		p.nextToken() // skip '['
+		p.skipNewlines()
+		elem, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		p.nextToken()
+		p.skipNewlines()
+		if err := p.expectCurrent(TokenRBracket); err != nil {
+			return nil, err
+		}
+		return &ArrayType{Elem: elem, Pos: pos}, nil
+	case TokenLBrace:
+		return p.parseObjectType()
+	case TokenLParen:
+		p.nextToken() // skip '('
+		typ, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		p.nextToken()
+		if err := p.expectCurrent(TokenRParen); err != nil {
+			return nil, err
+		}
+		return typ, nil
+	default:
+		return nil, p.error(fmt.Sprintf("expected a type, got %v", p.current.Type))
+	}
+}
+
+// parseObjectType parses the fields of an object type, where a '?' after
+// the name makes a field optional
+func (p *Parser) parseObjectType() (*ObjectType, error) {
+	obj := &ObjectType{Pos: p.pos()}
+
+	p.nextToken() // skip '{'
+	p.skipNewlines()
+
+	for !p.currentIs(TokenRBrace) && !p.currentIs(TokenEOF) {
+		if p.currentIs(TokenComment) || p.currentIs(TokenNewline) {
+			p.nextToken()
+			continue
+		}
+
+		field := &FieldType{Name: p.current.Value, Pos: p.pos()}
+		if !p.currentIs(TokenIdent) && !(p.currentIs(TokenString) && len(p.current.Parts) == 0) {
+			return nil, p.error(fmt.Sprintf("expected field name, got %v", p.current.Type))
+		}
+		for _, other := range obj.Fields {
+			if other.Name == field.Name {
+				return nil, p.error(fmt.Sprintf("duplicate field %q in object type", field.Name))
+			}
+		}
+		p.nextToken()
+
+		if p.currentIs(TokenQuestion) {
+			field.Optional = true
+			p.nextToken()
+		}
+		if err := p.expectCurrent(TokenColon); err != nil {
+			return nil, err
+		}
+		p.nextToken()
+
+		typ, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		field.Type = typ
+		obj.Fields = append(obj.Fields, field)
+
+		p.nextToken()
+		p.skipNewlines()
+
+		// Optional comma
+		if p.currentIs(TokenComma) {
+			p.nextToken()
+			p.skipNewlines()
+		}
+	}
+
+	if !p.currentIs(TokenRBrace) {
+		return nil, p.error(fmt.Sprintf("expected '}', got %v", p.current.Type))
+	}
+
+	return obj, nil
+}
@@ -1,6 +1,9 @@
 package parser
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -462,3 +465,573 @@ func TestParseInterpolatedStringEscaping(t *testing.T) {
 		})
 	}
 }
+
+func TestParseNestedInterpolation(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string // printed type of the single expression part
+	}{
+		{
+			name:  "object literal",
+			input: `text: "port ${ {port: 80}.port }"`,
+			want:  "*parser.MemberExpression",
+		},
+		{
+			name:  "nested string with brace",
+			input: `text: "${ "}" + name }"`,
+			want:  "*parser.BinaryOp",
+		},
+		{
+			name:  "nested interpolated string",
+			input: `text: "${ "inner-${name}" }"`,
+			want:  "*parser.InterpolatedString",
+		},
+		{
+			name:  "multiline string",
+			input: "text: \"\"\"a ${ {x: \"}\"}.x } b\"\"\"",
+			want:  "*parser.MemberExpression",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := New(tt.input, "").Parse()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			kv, ok := doc.Body[0].(*KeyValueStatement)
+			if !ok {
+				t.Fatalf("expected KeyValue, got %T", doc.Body[0])
+			}
+
+			interp, ok := kv.Value.(*InterpolatedString)
+			if !ok {
+				t.Fatalf("expected InterpolatedString value, got %T", kv.Value)
+			}
+
+			var exprs []string
+			for _, part := range interp.Parts {
+				if _, ok := part.(*StringLiteral); !ok {
+					exprs = append(exprs, fmt.Sprintf("%T", part))
+				}
+			}
+			if len(exprs) != 1 || exprs[0] != tt.want {
+				t.Errorf("expected a single %s part, got %v", tt.want, exprs)
+			}
+		})
+	}
+}
+
+func TestParseInterpolationPositions(t *testing.T) {
+	input := "a: 1\nb: \"x ${ name }\""
+
+	doc, err := New(input, "test.helmtk").Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	interp := doc.Body[1].(*KeyValueStatement).Value.(*InterpolatedString)
+	ident, ok := interp.Parts[1].(*Identifier)
+	if !ok {
+		t.Fatalf("expected Identifier, got %T", interp.Parts[1])
+	}
+	if ident.Pos.Line != 2 || ident.Pos.Col != 10 {
+		t.Errorf("expected identifier at 2:10, got %d:%d", ident.Pos.Line, ident.Pos.Col)
+	}
+
+	_, err = New("a: 1\nb: \"x ${ name + }\"", "test.helmtk").Parse()
+	parseErr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("expected *ParseError, got %T (%v)", err, err)
+	}
+	if parseErr.Line != 2 || parseErr.Col != 17 {
+		t.Errorf("expected error at 2:17, got %d:%d", parseErr.Line, parseErr.Col)
+	}
+	if parseErr.Source == "" {
+		t.Error("expected error to include source context")
+	}
+
+	_, err = New(`b: "x ${ name"`, "test.helmtk").Parse()
+	if err == nil || !strings.Contains(err.Error(), "unclosed interpolation") {
+		t.Errorf("expected unclosed interpolation error, got %v", err)
+	}
+}
+
+func TestParseAssignmentTargets(t *testing.T) {
+	tests := []struct {
+		input    string
+		target   string
+		operator string
+	}{
+		{`x = 1`, "*parser.Identifier", "="},
+		{`config.server.port = 80`, "*parser.MemberExpression", "="},
+		{`ports[0] = 80`, "*parser.IndexExpression", "="},
+		{`count += 1`, "*parser.Identifier", "+="},
+		{`count -= 1`, "*parser.Identifier", "-="},
+		{`cfg.name ||= "default"`, "*parser.MemberExpression", "||="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			doc, err := New(tt.input, "").Parse()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			assign, ok := doc.Body[0].(*AssignmentStatement)
+			if !ok {
+				t.Fatalf("expected AssignmentStatement, got %T", doc.Body[0])
+			}
+			if got := fmt.Sprintf("%T", assign.Target); got != tt.target {
+				t.Errorf("expected target %s, got %s", tt.target, got)
+			}
+			if assign.Operator != tt.operator {
+				t.Errorf("expected operator %q, got %q", tt.operator, assign.Operator)
+			}
+		})
+	}
+
+	if _, err := New(`f(x) = 1`, "").Parse(); err == nil {
+		t.Error("expected error for invalid assignment target")
+	}
+}
+
+func TestParseForStatementClauses(t *testing.T) {
+	input := `ports: [
+  for p in Values.ports if p.enabled do
+    p.port
+  else
+    80
+  end
+]`
+
+	doc, err := New(input, "").Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	arr := doc.Body[0].(*KeyValueStatement).Value.(*Array)
+	forStmt, ok := arr.Body[0].(*ForStatement)
+	if !ok {
+		t.Fatalf("expected ForStatement, got %T", arr.Body[0])
+	}
+
+	if forStmt.KeyVar != "" || forStmt.ValueVar != "p" {
+		t.Errorf("expected only value var 'p', got key %q value %q", forStmt.KeyVar, forStmt.ValueVar)
+	}
+	if forStmt.Filter == nil {
+		t.Error("expected filter clause")
+	}
+	if len(forStmt.Body) != 1 || len(forStmt.Else) != 1 {
+		t.Errorf("expected 1 body and 1 else node, got %d and %d", len(forStmt.Body), len(forStmt.Else))
+	}
+}
+
+func TestParseForSortClause(t *testing.T) {
+	tests := []struct {
+		input  string
+		by     bool
+		desc   bool
+		filter bool
+	}{
+		{`for k, v in sorted obj do k end`, false, false, false},
+		{`for k, v in sorted obj desc do k end`, false, true, false},
+		{`for p in ports sort by p.port do p end`, true, false, false},
+		{`for p in ports sort by p.port desc if p.enabled do p end`, true, true, true},
+	}
+
+	for _, tt := range tests {
+		doc, err := New("items: ["+tt.input+"]", "").Parse()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.input, err)
+		}
+
+		arr := doc.Body[0].(*KeyValueStatement).Value.(*Array)
+		forStmt := arr.Body[0].(*ForStatement)
+		if forStmt.Sort == nil {
+			t.Fatalf("%s: expected sort clause", tt.input)
+		}
+		if (forStmt.Sort.By != nil) != tt.by || forStmt.Sort.Desc != tt.desc || (forStmt.Filter != nil) != tt.filter {
+			t.Errorf("%s: got by=%v desc=%v filter=%v", tt.input,
+				forStmt.Sort.By != nil, forStmt.Sort.Desc, forStmt.Filter != nil)
+		}
+	}
+
+	// "sorted" is only a modifier when followed by an expression
+	doc, err := New("items: [for sorted in list do sorted end]", "").Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	forStmt := doc.Body[0].(*KeyValueStatement).Value.(*Array).Body[0].(*ForStatement)
+	if forStmt.Sort != nil || forStmt.ValueVar != "sorted" {
+		t.Errorf("expected plain loop over 'sorted', got %+v", forStmt)
+	}
+}
+
+func TestParseDefinitionParams(t *testing.T) {
+	doc, err := New(`define("makeLabel", app, version = "1.0") do
+  app: app
+end`, "").Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	def := doc.Definitions[0]
+	if len(def.Params) != 2 {
+		t.Fatalf("expected 2 params, got %d", len(def.Params))
+	}
+	if def.Params[0].Name != "app" || def.Params[0].Default != nil {
+		t.Errorf("expected required param 'app', got %+v", def.Params[0])
+	}
+	if def.Params[1].Name != "version" || def.Params[1].Default == nil {
+		t.Errorf("expected param 'version' with a default, got %+v", def.Params[1])
+	}
+
+	// Definitions without a parameter list take any context
+	doc, err = New(`define("double") x * 2`, "").Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if doc.Definitions[0].Params != nil {
+		t.Errorf("expected nil params, got %v", doc.Definitions[0].Params)
+	}
+}
+
+func TestParseIncludeName(t *testing.T) {
+	doc, err := New(`a: include("static")
+b: include("probe." + kind)`, "").Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	static := doc.Body[0].(*KeyValueStatement).Value.(*IncludeExpression)
+	if static.Name != "static" || static.NameExpr != nil {
+		t.Errorf("expected literal name 'static', got %q and %v", static.Name, static.NameExpr)
+	}
+
+	dynamic := doc.Body[1].(*KeyValueStatement).Value.(*IncludeExpression)
+	if dynamic.Name != "" || dynamic.NameExpr == nil {
+		t.Errorf("expected a name expression, got %q and %v", dynamic.Name, dynamic.NameExpr)
+	}
+}
+
+func TestParseIncludeBlock(t *testing.T) {
+	doc, err := New(`spec: {
+  include("container", {name: "web"}) do
+    env: []
+    slot ports do
+      80
+    end
+  end
+}
+enabled: [if include("flag") do 1 end]`, "").Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	obj := doc.Body[0].(*KeyValueStatement).Value.(*Object)
+	inc, ok := obj.Body[0].(*IncludeExpression)
+	if !ok {
+		t.Fatalf("expected IncludeExpression, got %T", obj.Body[0])
+	}
+	if len(inc.Block) != 1 {
+		t.Errorf("expected 1 block statement, got %d", len(inc.Block))
+	}
+	if len(inc.Slots) != 1 || inc.Slots[0].Name != "ports" || len(inc.Slots[0].Body) != 1 {
+		t.Errorf("expected slot 'ports' with 1 statement, got %+v", inc.Slots)
+	}
+
+	// An include in a condition doesn't take the if's block
+	arr := doc.Body[1].(*KeyValueStatement).Value.(*Array)
+	ifStmt := arr.Body[0].(*IfStatement)
+	if _, ok := ifStmt.Condition.(*IncludeExpression); !ok {
+		t.Errorf("expected include condition, got %T", ifStmt.Condition)
+	}
+}
+
+func TestParseOverrideDefinition(t *testing.T) {
+	doc, err := New(`define("labels") do
+  app: "web"
+end
+
+override define("labels") do
+  super()
+  team: "platform"
+end
+
+override: "still a key"`, "").Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(doc.Definitions) != 2 {
+		t.Fatalf("expected 2 definitions, got %d", len(doc.Definitions))
+	}
+	if doc.Definitions[0].Override || !doc.Definitions[1].Override {
+		t.Errorf("expected only the second definition to be an override")
+	}
+	if _, ok := doc.Definitions[1].Body[0].(*SuperExpression); !ok {
+		t.Errorf("expected SuperExpression, got %T", doc.Definitions[1].Body[0])
+	}
+	if len(doc.Body) != 1 {
+		t.Errorf("expected 'override' key to parse as a key/value, got %d statements", len(doc.Body))
+	}
+}
+
+func TestParseHiddenKeyValue(t *testing.T) {
+	doc, err := New(`config: {
+  hidden base: "web"
+  hidden "with-dash": 1
+  hidden: "plain key"
+  name: base
+}`, "").Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	obj := doc.Body[0].(*KeyValueStatement).Value.(*Object)
+	want := []struct {
+		key    string
+		hidden bool
+	}{
+		{"base", true},
+		{"with-dash", true},
+		{"hidden", false},
+		{"name", false},
+	}
+	for i, w := range want {
+		kv := obj.Body[i].(*KeyValueStatement)
+		if kv.Key != w.key || kv.Hidden != w.hidden {
+			t.Errorf("field %d: got key %q hidden %v, want key %q hidden %v", i, kv.Key, kv.Hidden, w.key, w.hidden)
+		}
+	}
+}
+
+func TestParseContextualKeywords(t *testing.T) {
+	// Keywords of statements and expressions are plain keys before a
+	// colon, like the match field of an Istio VirtualService
+	doc, err := New(`spec: {
+  http: [{
+    match: [{uri: {prefix: "/api"}}]
+    route: [{destination: {host: "api"}}]
+  }]
+}
+let try = {case: 1, catch: 2}
+config: {
+  assert: try.case
+  fail: false
+  yield: try.catch
+  mode: match try.case do
+    case 1 => {case: "one"}
+    case _ => "other"
+  end
+  safe: try try.missing.field catch err => "none"
+}`, "").Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	http := doc.Body[0].(*KeyValueStatement).Value.(*Object).Body[0].(*KeyValueStatement).Value.(*Array)
+	route := http.Body[0].(*Object)
+	if kv := route.Body[0].(*KeyValueStatement); kv.Key != "match" {
+		t.Errorf("expected key 'match', got %q", kv.Key)
+	}
+
+	config := doc.Body[2].(*KeyValueStatement).Value.(*Object)
+	var keys []string
+	for _, node := range config.Body {
+		keys = append(keys, node.(*KeyValueStatement).Key)
+	}
+	if want := []string{"assert", "fail", "yield", "mode", "safe"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("got keys %v, want %v", keys, want)
+	}
+	if m, ok := config.Body[3].(*KeyValueStatement).Value.(*MatchStatement); !ok || len(m.Cases) != 2 {
+		t.Errorf("expected a match statement with 2 cases, got %#v", config.Body[3].(*KeyValueStatement).Value)
+	}
+	if try, ok := config.Body[4].(*KeyValueStatement).Value.(*TryExpression); !ok || try.ErrorVar != "err" {
+		t.Errorf("expected a try expression binding err, got %#v", config.Body[4].(*KeyValueStatement).Value)
+	}
+}
+
+func TestParseCaseVariable(t *testing.T) {
+	// A variable named case can be used as a value in the arms of a match
+	doc, err := New(`let case = 1
+a: match 1 do
+  case 1 => case
+  case _ => 0
+end
+b: match 2 do
+  case 2 =>
+    case
+  case _ => 0
+end`, "").Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i, key := range []string{"a", "b"} {
+		m := doc.Body[i+1].(*KeyValueStatement).Value.(*MatchStatement)
+		if len(m.Cases) != 2 {
+			t.Fatalf("%s: expected 2 cases, got %d", key, len(m.Cases))
+		}
+		if id, ok := m.Cases[0].Body[0].(*Identifier); !ok || id.Name != "case" {
+			t.Errorf("%s: expected the first arm to be the variable case, got %#v", key, m.Cases[0].Body[0])
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	doc, err := New(`define("t", a = x) a
+config: {
+  name: "${self.app}"
+  ports: [for p in ports if p.enabled do p.port end]
+}`, "").Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var idents []string
+	InspectDocument(doc, func(n Node) bool {
+		if id, ok := n.(*Identifier); ok {
+			idents = append(idents, id.Name)
+		}
+		return true
+	})
+
+	want := "self ports p p x a"
+	if got := strings.Join(idents, " "); got != want {
+		t.Errorf("identifiers: got %q, want %q", got, want)
+	}
+
+	// Returning false skips the children of a node
+	count := 0
+	InspectDocument(doc, func(n Node) bool {
+		count++
+		_, isObject := n.(*Object)
+		return !isObject
+	})
+	if count != 5 {
+		t.Errorf("expected 5 nodes outside the object, got %d", count)
+	}
+}
+
+func TestCopyDocument(t *testing.T) {
+	doc, err := New(`define("t", a = x) a
+let n = 1 + 2
+ports: [for p in ports do p.port end]`, "").Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dup := CopyDocument(doc)
+	if !reflect.DeepEqual(doc, dup) {
+		t.Fatalf("copy differs from the original")
+	}
+
+	// Changing the copy leaves the original alone
+	dup.Body[0].(*LetStatement).Value = &NumberLiteral{Value: 3}
+	dup.Definitions[0].Params[0].Name = "b"
+	dup.Body[1].(*KeyValueStatement).Value.(*Array).Body[0].(*ForStatement).Frame = &Frame{Names: []string{"p"}}
+
+	if _, ok := doc.Body[0].(*LetStatement).Value.(*BinaryOp); !ok {
+		t.Errorf("let value of the original was replaced")
+	}
+	if name := doc.Definitions[0].Params[0].Name; name != "a" {
+		t.Errorf("parameter of the original was renamed to %q", name)
+	}
+	if frame := doc.Body[1].(*KeyValueStatement).Value.(*Array).Body[0].(*ForStatement).Frame; frame != nil {
+		t.Errorf("for statement of the original got a frame")
+	}
+}
+
+func TestParseDocumentSeparators(t *testing.T) {
+	doc, err := New(`a: 1 - -1
+---
+b: 2
+---  
+document "c.yaml", weight = 5 do
+  c: 3
+end
+document: "plain key"`, "").Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var kinds []string
+	for _, stmt := range doc.Body {
+		kinds = append(kinds, fmt.Sprintf("%T", stmt))
+	}
+	want := "*parser.KeyValueStatement *parser.DocumentSeparator *parser.KeyValueStatement " +
+		"*parser.DocumentSeparator *parser.DocumentBlock *parser.KeyValueStatement"
+	if got := strings.Join(kinds, " "); got != want {
+		t.Errorf("statements: got %q, want %q", got, want)
+	}
+
+	block := doc.Body[4].(*DocumentBlock)
+	if block.Name == nil || block.Weight == nil || len(block.Body) != 1 {
+		t.Errorf("expected named document with a weight and 1 statement, got %+v", block)
+	}
+}
+
+func TestParseTypes(t *testing.T) {
+	doc, err := New(`type Port = {name: string, "target-port"?: int, protocol?: "TCP" | "UDP"}
+type Ports = [Port] | null
+
+define("svc", ports: [Port], name = "web") do
+  name: name
+end
+
+let port: Port = {name: "http"}
+ok: port is (Port | null)
+type: "still a key"`, "").Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{
+		`{name: string, "target-port"?: int, protocol?: "TCP" | "UDP"}`,
+		`[Port] | null`,
+	}
+	if len(doc.Types) != len(want) {
+		t.Fatalf("expected %d type declarations, got %d", len(want), len(doc.Types))
+	}
+	for i, w := range want {
+		if got := FormatType(doc.Types[i].Type); got != w {
+			t.Errorf("type %s: got %s, want %s", doc.Types[i].Name, got, w)
+		}
+	}
+
+	params := doc.Definitions[0].Params
+	if FormatType(params[0].Type) != "[Port]" || params[1].Type != nil {
+		t.Errorf("unexpected parameter types: %v, %v", params[0].Type, params[1].Type)
+	}
+	if let := doc.Body[0].(*LetStatement); FormatType(let.Type) != "Port" {
+		t.Errorf("let type: got %s, want Port", FormatType(let.Type))
+	}
+	is, ok := doc.Body[1].(*KeyValueStatement).Value.(*IsExpression)
+	if !ok {
+		t.Fatalf("expected IsExpression, got %T", doc.Body[1].(*KeyValueStatement).Value)
+	}
+	if got := FormatType(is.Type); got != "Port | null" {
+		t.Errorf("is type: got %s, want Port | null", got)
+	}
+	if len(doc.Body) != 3 {
+		t.Errorf("expected 'type' key to parse as a key/value, got %d statements", len(doc.Body))
+	}
+}
+
+func TestParseTypeErrors(t *testing.T) {
+	tests := map[string]string{
+		`type Port = {name: string, name: int}`: `duplicate field "name"`,
+		`type Port = {name: }`:                  `expected a type, got '}'`,
+		`x: 1 is "${a}"`:                        `string types can't be interpolated`,
+	}
+	for input, want := range tests {
+		_, err := New(input, "").Parse()
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got error %v, want %q", input, err, want)
+		}
+	}
+}
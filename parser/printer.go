@@ -3,7 +3,9 @@ package parser
 import (
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
+	"unicode"
 )
 
 // Printer formats an AST for display
@@ -26,8 +28,18 @@ func (p *Printer) PrintDocument(doc *Document) {
 	p.println("Document")
 	p.indent++
 
-	// Print definitions first, then body statements
+	// Print type declarations and definitions first, then body statements
 	stmtIdx := 0
+	for _, decl := range doc.Types {
+		p.println("Statement[%d]:", stmtIdx)
+		p.indent++
+		p.println("TypeDecl")
+		p.indent++
+		p.println("Name: %q", decl.Name)
+		p.println("Type: %s", FormatType(decl.Type))
+		p.indent -= 2
+		stmtIdx++
+	}
 	for _, def := range doc.Definitions {
 		p.println("Statement[%d]:", stmtIdx)
 		p.indent++
@@ -62,6 +74,9 @@ func (p *Printer) PrintKeyValue(kv *KeyValueStatement) {
 	p.println("KeyValue")
 	p.indent++
 	p.println("Key: %q", kv.Key)
+	if kv.Hidden {
+		p.println("Hidden: true")
+	}
 	p.println("Value:")
 	p.indent++
 	p.PrintValueStatement(kv.Value)
@@ -74,6 +89,9 @@ func (p *Printer) PrintLetStatement(let *LetStatement) {
 	p.println("LetStatement")
 	p.indent++
 	p.println("Name: %q", let.Name)
+	if let.Type != nil {
+		p.println("Type: %s", FormatType(let.Type))
+	}
 	p.println("Value:")
 	p.indent++
 	p.PrintValueStatement(let.Value)
@@ -81,11 +99,49 @@ func (p *Printer) PrintLetStatement(let *LetStatement) {
 	p.indent--
 }
 
+// PrintAssignmentStatement prints an AssignmentStatement node
+func (p *Printer) PrintAssignmentStatement(a *AssignmentStatement) {
+	p.println("AssignmentStatement")
+	p.indent++
+	p.println("Operator: %q", a.Operator)
+	p.println("Target:")
+	p.indent++
+	p.PrintValue(a.Target)
+	p.indent--
+	p.println("Value:")
+	p.indent++
+	p.PrintValueStatement(a.Value)
+	p.indent--
+	p.indent--
+}
+
 // PrintDefineStatement prints a DefineStatement node
 func (p *Printer) PrintDefineStatement(def *Definition) {
 	p.println("DefineStatement")
 	p.indent++
 	p.println("Name: %q", def.Name)
+	if def.Override {
+		p.println("Override: true")
+	}
+	if def.Params != nil {
+		p.println("Params:")
+		p.indent++
+		for _, param := range def.Params {
+			if param.Type != nil {
+				p.println("Param: %s: %s", param.Name, FormatType(param.Type))
+			} else {
+				p.println("Param: %s", param.Name)
+			}
+			if param.Default != nil {
+				p.indent++
+				p.println("Default:")
+				p.indent++
+				p.PrintValue(param.Default)
+				p.indent -= 2
+			}
+		}
+		p.indent--
+	}
 	p.println("Body:")
 	p.indent++
 	for i, val := range def.Body {
@@ -98,6 +154,34 @@ func (p *Printer) PrintDefineStatement(def *Definition) {
 	p.indent--
 }
 
+// PrintAssertStatement prints an AssertStatement node
+func (p *Printer) PrintAssertStatement(a *AssertStatement) {
+	p.println("AssertStatement")
+	p.indent++
+	p.println("Condition:")
+	p.indent++
+	p.PrintValue(a.Condition)
+	p.indent--
+	if a.Message != nil {
+		p.println("Message:")
+		p.indent++
+		p.PrintValue(a.Message)
+		p.indent--
+	}
+	p.indent--
+}
+
+// PrintFailStatement prints a FailStatement node
+func (p *Printer) PrintFailStatement(f *FailStatement) {
+	p.println("FailStatement")
+	p.indent++
+	p.println("Message:")
+	p.indent++
+	p.PrintValue(f.Message)
+	p.indent--
+	p.indent--
+}
+
 // PrintComment prints a Comment node
 func (p *Printer) PrintComment(c *Comment) {
 	p.println("Comment: %q", c.Text)
@@ -175,7 +259,14 @@ func (p *Printer) PrintInterpolatedString(s *InterpolatedString) {
 func (p *Printer) PrintIncludeExpression(inc *IncludeExpression) {
 	p.println("IncludeExpression")
 	p.indent++
-	p.println("Name: %q", inc.Name)
+	if inc.NameExpr != nil {
+		p.println("Name:")
+		p.indent++
+		p.PrintValue(inc.NameExpr)
+		p.indent--
+	} else {
+		p.println("Name: %q", inc.Name)
+	}
 	if inc.Context != nil {
 		p.println("Content:")
 		p.indent++
@@ -184,9 +275,71 @@ func (p *Printer) PrintIncludeExpression(inc *IncludeExpression) {
 	} else {
 		p.println("Args: []")
 	}
+	if inc.Block != nil {
+		p.println("Block:")
+		p.indent++
+		for i, node := range inc.Block {
+			p.println("Value[%d]:", i)
+			p.indent++
+			p.PrintNode(node)
+			p.indent--
+		}
+		p.indent--
+	}
+	for _, slot := range inc.Slots {
+		p.println("Slot: %s", slot.Name)
+		p.indent++
+		for i, node := range slot.Body {
+			p.println("Value[%d]:", i)
+			p.indent++
+			p.PrintNode(node)
+			p.indent--
+		}
+		p.indent--
+	}
+	p.indent--
+}
+
+// PrintDocumentBlock prints a DocumentBlock node
+func (p *Printer) PrintDocumentBlock(d *DocumentBlock) {
+	p.println("DocumentBlock")
+	p.indent++
+	if d.Name != nil {
+		p.println("Name:")
+		p.indent++
+		p.PrintValue(d.Name)
+		p.indent--
+	}
+	if d.Weight != nil {
+		p.println("Weight:")
+		p.indent++
+		p.PrintValue(d.Weight)
+		p.indent--
+	}
+	p.println("Body:")
+	p.indent++
+	for i, node := range d.Body {
+		p.println("Value[%d]:", i)
+		p.indent++
+		p.PrintNode(node)
+		p.indent--
+	}
+	p.indent--
 	p.indent--
 }
 
+// PrintSuperExpression prints a SuperExpression node
+func (p *Printer) PrintSuperExpression(sup *SuperExpression) {
+	p.println("SuperExpression")
+	if sup.Context != nil {
+		p.indent++
+		p.println("Context:")
+		p.indent++
+		p.PrintValue(sup.Context)
+		p.indent -= 2
+	}
+}
+
 // PrintCallExpression prints a CallExpression node
 func (p *Printer) PrintCallExpression(call *CallExpression) {
 	p.println("CallExpression")
@@ -211,16 +364,38 @@ func (p *Printer) PrintCallExpression(call *CallExpression) {
 	p.indent--
 }
 
+// PrintTryExpression prints a TryExpression node
+func (p *Printer) PrintTryExpression(t *TryExpression) {
+	p.println("TryExpression")
+	p.indent++
+	p.println("Body:")
+	p.indent++
+	p.PrintValue(t.Body)
+	p.indent--
+	if t.ErrorVar != "" {
+		p.println("ErrorVar: %q", t.ErrorVar)
+	}
+	p.println("Fallback:")
+	p.indent++
+	p.PrintValue(t.Fallback)
+	p.indent--
+	p.indent--
+}
+
 func (p *Printer) PrintValueStatement(vs ValueStatement) {
 	switch v := vs.(type) {
 	case *IfStatement:
 		p.PrintIfStatement(v)
+	case *MatchStatement:
+		p.PrintMatchStatement(v)
 	case *ForStatement:
 		p.PrintForStatement(v)
 	case *WithStatement:
 		p.PrintWithStatement(v)
 	case *IncludeExpression:
 		p.PrintIncludeExpression(v)
+	case *SuperExpression:
+		p.PrintSuperExpression(v)
 	case Expression:
 		p.PrintValue(v)
 	}
@@ -253,6 +428,16 @@ func (p *Printer) PrintValue(val Expression) {
 		p.PrintUnaryOp(v)
 	case *CallExpression:
 		p.PrintCallExpression(v)
+	case *TryExpression:
+		p.PrintTryExpression(v)
+	case *IsExpression:
+		p.println("IsExpression")
+		p.indent++
+		p.println("Type: %s", FormatType(v.Type))
+		p.println("Value:")
+		p.indent++
+		p.PrintValue(v.Value)
+		p.indent -= 2
 	case *Object:
 		p.PrintObject(v)
 	case *Array:
@@ -280,18 +465,36 @@ func (p *Printer) PrintNode(node Node) {
 		p.PrintKeyValue(n)
 	case *LetStatement:
 		p.PrintLetStatement(n)
+	case *AssignmentStatement:
+		p.PrintAssignmentStatement(n)
 	case *SpreadStatement:
 		p.PrintSpreadElement(n)
 	case *IfStatement:
 		p.PrintIfStatement(n)
+	case *MatchStatement:
+		p.PrintMatchStatement(n)
 	case *ForStatement:
 		p.PrintForStatement(n)
 	case *WithStatement:
 		p.PrintWithStatement(n)
+	case *AssertStatement:
+		p.PrintAssertStatement(n)
+	case *FailStatement:
+		p.PrintFailStatement(n)
 	case *BreakStatement:
 		p.println("BreakStatement")
 	case *ContinueStatement:
 		p.println("ContinueStatement")
+	case *DocumentBlock:
+		p.PrintDocumentBlock(n)
+	case *DocumentSeparator:
+		p.println("DocumentSeparator")
+	case *YieldStatement:
+		if n.Name != "" {
+			p.println("YieldStatement: %s", n.Name)
+		} else {
+			p.println("YieldStatement")
+		}
 	case Expression:
 		p.PrintValue(n)
 	default:
@@ -356,6 +559,68 @@ func (p *Printer) PrintIfStatement(ifStmt *IfStatement) {
 	p.indent--
 }
 
+// PrintMatchStatement prints a MatchStatement node
+func (p *Printer) PrintMatchStatement(m *MatchStatement) {
+	p.println("MatchStatement")
+	p.indent++
+	p.println("Subject:")
+	p.indent++
+	p.PrintValue(m.Subject)
+	p.indent--
+	for i, c := range m.Cases {
+		p.println("Case[%d]:", i)
+		p.indent++
+		for j, pat := range c.Patterns {
+			p.println("Pattern[%d]:", j)
+			p.indent++
+			p.PrintPattern(pat)
+			p.indent--
+		}
+		if c.Guard != nil {
+			p.println("Guard:")
+			p.indent++
+			p.PrintValue(c.Guard)
+			p.indent--
+		}
+		p.println("Body:")
+		p.indent++
+		for j, val := range c.Body {
+			p.println("Value[%d]:", j)
+			p.indent++
+			p.PrintNode(val)
+			p.indent--
+		}
+		p.indent--
+		p.indent--
+	}
+	p.indent--
+}
+
+// PrintPattern prints a match Pattern node
+func (p *Printer) PrintPattern(pat Pattern) {
+	switch v := pat.(type) {
+	case *LiteralPattern:
+		p.println("LiteralPattern")
+		p.indent++
+		p.PrintValue(v.Value)
+		p.indent--
+	case *BindingPattern:
+		p.println("BindingPattern: %s", v.Name)
+	case *ObjectPattern:
+		p.println("ObjectPattern")
+		p.indent++
+		for _, field := range v.Fields {
+			p.println("Field %q:", field.Key)
+			p.indent++
+			p.PrintPattern(field.Pattern)
+			p.indent--
+		}
+		p.indent--
+	default:
+		p.println("Unknown pattern: %T", v)
+	}
+}
+
 // PrintWithStatement prints a WithStatement node
 func (p *Printer) PrintWithStatement(withStmt *WithStatement) {
 	p.println("WithStatement")
@@ -364,6 +629,10 @@ func (p *Printer) PrintWithStatement(withStmt *WithStatement) {
 	p.indent++
 	p.PrintValue(withStmt.Context)
 	p.indent--
+	p.println("VarName: %q", withStmt.VarName)
+	if withStmt.Always {
+		p.println("Always: true")
+	}
 	p.println("Body:")
 	p.indent++
 	for i, val := range withStmt.Body {
@@ -373,6 +642,17 @@ func (p *Printer) PrintWithStatement(withStmt *WithStatement) {
 		p.indent--
 	}
 	p.indent--
+	if len(withStmt.Else) > 0 {
+		p.println("Else:")
+		p.indent++
+		for i, val := range withStmt.Else {
+			p.println("Value[%d]:", i)
+			p.indent++
+			p.PrintNode(val)
+			p.indent--
+		}
+		p.indent--
+	}
 	p.indent--
 }
 
@@ -386,6 +666,25 @@ func (p *Printer) PrintForStatement(forStmt *ForStatement) {
 	p.indent++
 	p.PrintValue(forStmt.Iterable)
 	p.indent--
+	if forStmt.Sort != nil {
+		p.println("Sort:")
+		p.indent++
+		if forStmt.Sort.By != nil {
+			p.PrintValue(forStmt.Sort.By)
+		} else {
+			p.println("Key")
+		}
+		if forStmt.Sort.Desc {
+			p.println("Desc: true")
+		}
+		p.indent--
+	}
+	if forStmt.Filter != nil {
+		p.println("Filter:")
+		p.indent++
+		p.PrintValue(forStmt.Filter)
+		p.indent--
+	}
 	p.println("Body:")
 	p.indent++
 	for i, val := range forStmt.Body {
@@ -395,5 +694,70 @@ func (p *Printer) PrintForStatement(forStmt *ForStatement) {
 		p.indent--
 	}
 	p.indent--
+	if len(forStmt.Else) > 0 {
+		p.println("Else:")
+		p.indent++
+		for i, val := range forStmt.Else {
+			p.println("Value[%d]:", i)
+			p.indent++
+			p.PrintNode(val)
+			p.indent--
+		}
+		p.indent--
+	}
 	p.indent--
 }
+
+// FormatType formats a type the way it's written in source
+func FormatType(t TypeExpr) string {
+	switch t := t.(type) {
+	case *NamedType:
+		return t.Name
+	case *LiteralType:
+		switch v := t.Value.(type) {
+		case *StringLiteral:
+			return strconv.Quote(v.Value)
+		case *NumberLiteral:
+			return strconv.FormatFloat(v.Value, 'f', -1, 64)
+		case *BooleanLiteral:
+			return strconv.FormatBool(v.Value)
+		}
+		return "null"
+	case *ArrayType:
+		return "[" + FormatType(t.Elem) + "]"
+	case *ObjectType:
+		fields := make([]string, len(t.Fields))
+		for i, field := range t.Fields {
+			name := field.Name
+			if !isIdentifier(name) {
+				name = strconv.Quote(name)
+			}
+			if field.Optional {
+				name += "?"
+			}
+			fields[i] = name + ": " + FormatType(field.Type)
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	case *UnionType:
+		types := make([]string, len(t.Types))
+		for i, typ := range t.Types {
+			types[i] = FormatType(typ)
+			if _, ok := typ.(*UnionType); ok {
+				types[i] = "(" + types[i] + ")"
+			}
+		}
+		return strings.Join(types, " | ")
+	default:
+		return fmt.Sprintf("%T", t)
+	}
+}
+
+// isIdentifier reports whether s can be written without quotes as a key
+func isIdentifier(s string) bool {
+	for i, ch := range s {
+		if !unicode.IsLetter(ch) && ch != '_' && (i == 0 || !unicode.IsDigit(ch)) {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,142 @@
+package parser
+
+// Inspect traverses the AST rooted at node in depth-first order. It calls
+// f for each node, and visits the node's children when f returns true.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *KeyValueStatement:
+		Inspect(n.Value, f)
+	case *InterpolatedString:
+		for _, part := range n.Parts {
+			Inspect(part, f)
+		}
+	case *MemberExpression:
+		Inspect(n.Object, f)
+	case *IndexExpression:
+		Inspect(n.Object, f)
+		Inspect(n.Index, f)
+	case *BinaryOp:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *UnaryOp:
+		Inspect(n.Operand, f)
+	case *CallExpression:
+		Inspect(n.Function, f)
+		for _, arg := range n.Args {
+			Inspect(arg, f)
+		}
+	case *Object:
+		inspectList(n.Body, f)
+	case *Array:
+		inspectList(n.Body, f)
+	case *SpreadStatement:
+		Inspect(n.Operand, f)
+	case *IfStatement:
+		Inspect(n.Condition, f)
+		inspectList(n.Body, f)
+		inspectList(n.Else, f)
+	case *MatchStatement:
+		Inspect(n.Subject, f)
+		for _, c := range n.Cases {
+			Inspect(c, f)
+		}
+	case *MatchCase:
+		for _, pattern := range n.Patterns {
+			Inspect(pattern, f)
+		}
+		Inspect(n.Guard, f)
+		inspectList(n.Body, f)
+	case *LiteralPattern:
+		Inspect(n.Value, f)
+	case *ObjectPattern:
+		for _, field := range n.Fields {
+			Inspect(field.Pattern, f)
+		}
+	case *ForStatement:
+		Inspect(n.Iterable, f)
+		if n.Sort != nil {
+			Inspect(n.Sort.By, f)
+		}
+		Inspect(n.Filter, f)
+		inspectList(n.Body, f)
+		inspectList(n.Else, f)
+	case *WithStatement:
+		Inspect(n.Context, f)
+		inspectList(n.Body, f)
+		inspectList(n.Else, f)
+	case *LetStatement:
+		Inspect(n.Type, f)
+		Inspect(n.Value, f)
+	case *AssignmentStatement:
+		Inspect(n.Target, f)
+		Inspect(n.Value, f)
+	case *AssertStatement:
+		Inspect(n.Condition, f)
+		Inspect(n.Message, f)
+	case *FailStatement:
+		Inspect(n.Message, f)
+	case *Definition:
+		for _, param := range n.Params {
+			Inspect(param.Type, f)
+			Inspect(param.Default, f)
+		}
+		inspectList(n.Body, f)
+	case *IncludeExpression:
+		Inspect(n.NameExpr, f)
+		Inspect(n.Context, f)
+		inspectList(n.Block, f)
+		for _, slot := range n.Slots {
+			inspectList(slot.Body, f)
+		}
+	case *SuperExpression:
+		Inspect(n.Context, f)
+	case *DocumentBlock:
+		Inspect(n.Name, f)
+		Inspect(n.Weight, f)
+		inspectList(n.Body, f)
+	case *TryExpression:
+		Inspect(n.Body, f)
+		Inspect(n.Fallback, f)
+	case *IsExpression:
+		Inspect(n.Value, f)
+		Inspect(n.Type, f)
+	case *TypeDecl:
+		Inspect(n.Type, f)
+	case *LiteralType:
+		Inspect(n.Value, f)
+	case *ArrayType:
+		Inspect(n.Elem, f)
+	case *ObjectType:
+		for _, field := range n.Fields {
+			Inspect(field.Type, f)
+		}
+	case *UnionType:
+		for _, typ := range n.Types {
+			Inspect(typ, f)
+		}
+	}
+}
+
+// InspectDocument traverses the body, definitions and type declarations of
+// a document
+func InspectDocument(doc *Document, f func(Node) bool) {
+	for _, stmt := range doc.Body {
+		Inspect(stmt, f)
+	}
+	for _, def := range doc.Definitions {
+		Inspect(def, f)
+	}
+	for _, decl := range doc.Types {
+		Inspect(decl, f)
+	}
+}
+
+func inspectList(nodes []Node, f func(Node) bool) {
+	for _, node := range nodes {
+		Inspect(node, f)
+	}
+}
@@ -0,0 +1,274 @@
+package htkl
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+
+	"go.yaml.in/yaml/v3"
+
+	"helmtk.dev/code/htkl/eval"
+	"helmtk.dev/code/htkl/parser"
+	"helmtk.dev/code/htkl/runtime"
+)
+
+// Render evaluates helmtk source and encodes each document it produces.
+// Undefined variables, functions and templates are reported before
+// anything is evaluated (see eval.Resolve), along with type errors when
+// WithTypeCheck is given, and Values are validated against the schema given
+// to WithValuesSchema. Rendering stops when ctx is cancelled.
+//
+// When rendering fails, the returned Result still holds the diagnostics
+// describing what went wrong.
+func Render(ctx context.Context, src string, opts ...Option) (*Result, error) {
+	c := newConfig(opts)
+	res := &Result{Format: c.format}
+
+	doc, err := parser.New(src, c.filename).Parse()
+	if err != nil {
+		res.Diagnostics = errorDiagnostics(err, c.filename)
+		return res, err
+	}
+	res.Diagnostics = warningDiagnostics(doc)
+
+	if err := c.applyValuesSchema(res); err != nil {
+		return res, err
+	}
+	scope := c.scope()
+	diags, err := c.resolve(doc, scope)
+	res.Diagnostics = append(res.Diagnostics, diags...)
+	if err != nil {
+		return res, err
+	}
+
+	return res, c.render(ctx, res, func(fn func(*eval.Document) error) error {
+		return eval.EvalDocumentsFunc(doc, scope, fn, append(slices.Clip(c.evalOpts), eval.Context(ctx))...)
+	})
+}
+
+// Program is a template compiled once, to be rendered many times with
+// different values. It's safe for concurrent use by multiple goroutines.
+type Program struct {
+	prog     *eval.Program
+	config   *config
+	warnings []Diagnostic
+}
+
+// Compile parses and prepares helmtk source for rendering with Render.
+// Options apply to every render, and values given to WithValues are used
+// when a render isn't given any.
+func Compile(src string, opts ...Option) (*Program, error) {
+	c := newConfig(opts)
+
+	doc, err := parser.New(src, c.filename).Parse()
+	if err != nil {
+		return nil, err
+	}
+	// Values are only known when the program is rendered, but the ones
+	// given here are used by default, so they're validated now
+	if _, ok := c.globals["Values"]; ok {
+		if err := c.applyValuesSchema(&Result{}); err != nil {
+			return nil, err
+		}
+	}
+	scope := c.scope()
+	diags, err := c.resolve(doc, scope, "Values")
+	if err != nil {
+		return nil, err
+	}
+	prog, err := eval.Compile(doc, scope, c.evalOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{prog: prog, config: c, warnings: append(warningDiagnostics(doc), diags...)}, nil
+}
+
+// Render evaluates the program with the given values, like Render
+func (p *Program) Render(ctx context.Context, values any) (*Result, error) {
+	res := &Result{Format: p.config.format, Diagnostics: slices.Clone(p.warnings)}
+
+	val, ok := values.(runtime.Value)
+	if !ok && values != nil {
+		val = runtime.NewValue(values)
+	}
+	if p.config.schema != nil {
+		if val == nil {
+			val = p.config.globals["Values"]
+		}
+		checked, diags, err := p.config.applySchema(val)
+		res.Diagnostics = append(res.Diagnostics, diags...)
+		if err != nil {
+			return res, err
+		}
+		val = checked
+	}
+	return res, p.config.render(ctx, res, func(fn func(*eval.Document) error) error {
+		return p.prog.RunFunc(val, fn, eval.Context(ctx))
+	})
+}
+
+// RenderFiles parses every file in fsys matching the patterns, and renders
+// them together as if they were one file, with each file starting a new
+// document. Templates and types defined in any file can be used from the
+// others. Files are read in the order of the patterns that matched them
+// first, and in lexical order for each pattern.
+func RenderFiles(fsys fs.FS, patterns []string, opts ...Option) (*Result, error) {
+	c := newConfig(opts)
+	res := &Result{Format: c.format}
+
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return res, fmt.Errorf("bad pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			res.Diagnostics = append(res.Diagnostics, Diagnostic{
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("pattern %q matched no files", pattern),
+			})
+		}
+		for _, name := range matches {
+			if !slices.Contains(files, name) {
+				files = append(files, name)
+			}
+		}
+	}
+
+	doc := &parser.Document{}
+	for _, name := range files {
+		src, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return res, err
+		}
+		file, err := parser.New(string(src), name).Parse()
+		if err != nil {
+			res.Diagnostics = append(res.Diagnostics, errorDiagnostics(err, name)...)
+			return res, fmt.Errorf("%s: %w", name, err)
+		}
+		if len(doc.Body) > 0 {
+			doc.Body = append(doc.Body, &parser.DocumentSeparator{Pos: parser.Pos{Filename: name}})
+		}
+		doc.Body = append(doc.Body, file.Body...)
+		doc.Definitions = append(doc.Definitions, file.Definitions...)
+		doc.Types = append(doc.Types, file.Types...)
+		doc.Warnings = append(doc.Warnings, file.Warnings...)
+	}
+	res.Diagnostics = append(res.Diagnostics, warningDiagnostics(doc)...)
+
+	if err := c.applyValuesSchema(res); err != nil {
+		return res, err
+	}
+	scope := c.scope()
+	diags, err := c.resolve(doc, scope)
+	res.Diagnostics = append(res.Diagnostics, diags...)
+	if err != nil {
+		return res, err
+	}
+
+	return res, c.render(context.Background(), res, func(fn func(*eval.Document) error) error {
+		return eval.EvalDocumentsFunc(doc, scope, fn, c.evalOpts...)
+	})
+}
+
+// render runs an evaluation into res, encoding documents as they're
+// produced
+func (c *config) render(ctx context.Context, res *Result, run func(func(*eval.Document) error) error) error {
+	var docs []*Document
+	var invalid []error
+	err := run(func(d *eval.Document) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if c.maxDocuments > 0 && len(docs) == c.maxDocuments {
+			return fmt.Errorf("templates produced more than the maximum of %d documents", c.maxDocuments)
+		}
+
+		data, err := encode(d.Value, c.format)
+		if err != nil {
+			return fmt.Errorf("encoding %s document: %w", c.format, err)
+		}
+		docs = append(docs, &Document{
+			Name:   d.Filename,
+			Source: d.Source,
+			Weight: d.Weight,
+			Value:  d.Value,
+			Data:   data,
+		})
+		if c.kube != nil {
+			invalid = append(invalid, c.validate(res, d)...)
+		}
+		return nil
+	})
+	if err != nil {
+		res.Diagnostics = append(res.Diagnostics, errorDiagnostics(err, "")...)
+		return err
+	}
+	if len(invalid) > 0 {
+		return errors.Join(invalid...)
+	}
+
+	slices.SortStableFunc(docs, func(a, b *Document) int {
+		return cmp.Compare(a.Weight, b.Weight)
+	})
+	res.Documents = docs
+	return nil
+}
+
+// validate checks a document with the validator given to
+// WithKubernetesValidation, adding what it finds to res. Findings without a
+// position are reported against the document's source file.
+func (c *config) validate(res *Result, d *eval.Document) []error {
+	var errs []error
+	for _, f := range c.kube.Validate(d.Value) {
+		diag := Diagnostic{
+			Severity: SeverityError,
+			Message:  f.String(),
+			Filename: f.Pos.Filename,
+			Line:     f.Pos.Line,
+			Col:      f.Pos.Col,
+		}
+		if diag.Filename == "" {
+			diag.Filename = d.Source
+		}
+		if f.Warning {
+			diag.Severity = SeverityWarning
+		} else {
+			errs = append(errs, errors.New(diag.String()))
+		}
+		res.Diagnostics = append(res.Diagnostics, diag)
+	}
+	return errs
+}
+
+// encode writes a value in the given format
+func encode(val runtime.Value, format Format) ([]byte, error) {
+	native := runtime.ToNative(val)
+
+	switch format {
+	case FormatYAML:
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(native); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case FormatJSON:
+		data, err := json.MarshalIndent(native, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	default:
+		return nil, fmt.Errorf("unknown format %d", format)
+	}
+}
@@ -0,0 +1,223 @@
+package htkl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"helmtk.dev/code/htkl/eval"
+	"helmtk.dev/code/htkl/parser"
+	"helmtk.dev/code/htkl/runtime"
+	"helmtk.dev/code/htkl/schema"
+)
+
+// Result holds the documents rendered from templates, along with any
+// warnings and errors reported while rendering them
+type Result struct {
+	Documents   []*Document
+	Diagnostics []Diagnostic
+	Format      Format
+}
+
+// Document is a single rendered document
+type Document struct {
+	Name   string        // Output filename given by a document block, if any
+	Source string        // Template file the document came from
+	Weight int           // Ordering weight, documents are sorted by it
+	Value  runtime.Value // The evaluated value, without hidden fields
+	Data   []byte        // The value encoded in the result's format
+}
+
+// Bytes returns all documents as one stream, separated by "---" lines for
+// YAML and newlines for JSON
+func (r *Result) Bytes() []byte {
+	var buf bytes.Buffer
+	for i, doc := range r.Documents {
+		if i > 0 && r.Format == FormatYAML {
+			buf.WriteString("---\n")
+		}
+		buf.Write(doc.Data)
+	}
+	return buf.Bytes()
+}
+
+// Warnings returns the diagnostics that didn't stop rendering
+func (r *Result) Warnings() []Diagnostic {
+	return r.filter(SeverityWarning)
+}
+
+// Errors returns the diagnostics that made rendering fail
+func (r *Result) Errors() []Diagnostic {
+	return r.filter(SeverityError)
+}
+
+func (r *Result) filter(severity Severity) []Diagnostic {
+	var diags []Diagnostic
+	for _, d := range r.Diagnostics {
+		if d.Severity == severity {
+			diags = append(diags, d)
+		}
+	}
+	return diags
+}
+
+// Severity says whether a diagnostic is an error or a warning
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "unknown"
+	}
+}
+
+// Diagnostic is a problem found in a template, with its position if known
+type Diagnostic struct {
+	Severity Severity
+	Message  string
+	Filename string
+	Line     int
+	Col      int
+}
+
+func (d Diagnostic) String() string {
+	switch {
+	case d.Filename != "" && d.Line > 0:
+		return fmt.Sprintf("%s:%d:%d: %s: %s", d.Filename, d.Line, d.Col, d.Severity, d.Message)
+	case d.Filename != "":
+		return fmt.Sprintf("%s: %s: %s", d.Filename, d.Severity, d.Message)
+	default:
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+}
+
+// warningDiagnostics describes the warnings reported by the parser
+func warningDiagnostics(doc *parser.Document) []Diagnostic {
+	var diags []Diagnostic
+	for _, w := range doc.Warnings {
+		diags = append(diags, Diagnostic{
+			Severity: SeverityWarning,
+			Message:  w.Message,
+			Filename: w.Pos.Filename,
+			Line:     w.Pos.Line,
+			Col:      w.Pos.Col,
+		})
+	}
+	return diags
+}
+
+// resolve checks the names a document refers to before it's evaluated,
+// and its types when type checking is enabled. It returns an error along
+// with the diagnostics when any problems are found.
+func (c *config) resolve(doc *parser.Document, scope *runtime.Scope, globals ...string) ([]Diagnostic, error) {
+	found := eval.Resolve(doc, scope, globals...)
+	if c.typeCheck {
+		found = append(found, eval.Check(doc, scope, c.checkOpts...)...)
+	}
+
+	var diags []Diagnostic
+	var errs []error
+	for _, d := range found {
+		diag := Diagnostic{
+			Severity: SeverityError,
+			Message:  d.Message,
+			Filename: d.Pos.Filename,
+			Line:     d.Pos.Line,
+			Col:      d.Pos.Col,
+		}
+		if d.Warning {
+			diag.Severity = SeverityWarning
+		} else {
+			errs = append(errs, errors.New(d.String()))
+		}
+		diags = append(diags, diag)
+	}
+	return diags, errors.Join(errs...)
+}
+
+// applySchema validates values against the schema given to
+// WithValuesSchema, and returns them with its defaults filled in. Missing
+// values are validated as an empty object.
+func (c *config) applySchema(values runtime.Value) (runtime.Value, []Diagnostic, error) {
+	if c.schema == nil {
+		return values, nil, nil
+	}
+	if values == nil {
+		values = runtime.NewObject()
+	}
+	values, err := c.schema.Apply(values)
+
+	var errs schema.Errors
+	if !errors.As(err, &errs) {
+		return values, nil, err
+	}
+	var diags []Diagnostic
+	for _, e := range errs {
+		diags = append(diags, Diagnostic{
+			Severity: SeverityError,
+			Message:  "invalid values: " + e.Error(),
+		})
+	}
+	return values, diags, fmt.Errorf("invalid values:\n%w", err)
+}
+
+// applyValuesSchema replaces the values given to WithValues with the ones
+// applySchema returns, adding any mismatches to res
+func (c *config) applyValuesSchema(res *Result) error {
+	values, diags, err := c.applySchema(c.globals["Values"])
+	res.Diagnostics = append(res.Diagnostics, diags...)
+	if err != nil {
+		return err
+	}
+	if values != nil {
+		c.globals["Values"] = values
+	}
+	return nil
+}
+
+// errorDiagnostics describes an error as diagnostics, one per failure when
+// failures were collected. Parse errors don't carry their filename, so it's
+// passed in.
+func errorDiagnostics(err error, filename string) []Diagnostic {
+	var failures eval.AssertionErrors
+	if errors.As(err, &failures) {
+		var diags []Diagnostic
+		for _, f := range failures {
+			diags = append(diags, errorDiagnostics(f, filename)...)
+		}
+		return diags
+	}
+
+	var parseErr *parser.ParseError
+	if errors.As(err, &parseErr) {
+		return []Diagnostic{{
+			Severity: SeverityError,
+			Message:  parseErr.Message,
+			Filename: filename,
+			Line:     parseErr.Line,
+			Col:      parseErr.Col,
+		}}
+	}
+
+	var evalErr *eval.EvalError
+	if errors.As(err, &evalErr) {
+		return []Diagnostic{{
+			Severity: SeverityError,
+			Message:  evalErr.Message,
+			Filename: evalErr.Filename,
+			Line:     evalErr.Line,
+			Col:      evalErr.Col,
+		}}
+	}
+
+	return []Diagnostic{{Severity: SeverityError, Message: err.Error()}}
+}
@@ -1,6 +1,9 @@
 package runtime
 
-import "fmt"
+import (
+	"cmp"
+	"fmt"
+)
 
 // Equal returns true if two values are equal
 func Equal(left, right Value) bool {
@@ -84,3 +87,43 @@ func GreaterEqual(left, right Value) (bool, error) {
 	}
 	return leftNum >= rightNum, nil
 }
+
+// Compare orders two values, returning -1, 0 or 1. Null sorts before any
+// other value; otherwise both values must be numbers, strings or bools of
+// the same type.
+func Compare(left, right Value) (int, error) {
+	leftNull, rightNull := IsNull(left), IsNull(right)
+	if leftNull || rightNull {
+		switch {
+		case leftNull && rightNull:
+			return 0, nil
+		case leftNull:
+			return -1, nil
+		default:
+			return 1, nil
+		}
+	}
+
+	if left.Type() != right.Type() {
+		return 0, fmt.Errorf("cannot compare %s and %s", left.Type(), right.Type())
+	}
+
+	switch l := left.(type) {
+	case *NumberValue:
+		return cmp.Compare(l.Value, right.(*NumberValue).Value), nil
+	case *StringValue:
+		return cmp.Compare(l.Value, right.(*StringValue).Value), nil
+	case *BoolValue:
+		r := right.(*BoolValue)
+		switch {
+		case l.Value == r.Value:
+			return 0, nil
+		case !l.Value:
+			return -1, nil
+		default:
+			return 1, nil
+		}
+	default:
+		return 0, fmt.Errorf("cannot compare %s and %s", left.Type(), right.Type())
+	}
+}
@@ -282,3 +282,40 @@ func TestGreaterEqual(t *testing.T) {
 		})
 	}
 }
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name     string
+		left     Value
+		right    Value
+		expected int
+		wantErr  bool
+	}{
+		{name: "numbers less", left: NewNumber(1), right: NewNumber(2), expected: -1},
+		{name: "numbers equal", left: NewNumber(2), right: NewNumber(2), expected: 0},
+		{name: "strings greater", left: NewString("b"), right: NewString("a"), expected: 1},
+		{name: "bools", left: NewBool(false), right: NewBool(true), expected: -1},
+		{name: "null first", left: NewNull(), right: NewString("a"), expected: -1},
+		{name: "null last", left: NewNumber(0), right: NewNull(), expected: 1},
+		{name: "mixed types", left: NewString("1"), right: NewNumber(1), wantErr: true},
+		{name: "arrays", left: NewArray(), right: NewArray(), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Compare(tt.left, tt.right)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Compare() expected error, got %d", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Compare() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Compare() = %d, want %d", result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package runtime
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"helmtk.dev/code/htkl/parser"
+)
+
+// Env is the environment that evaluations run in: the globals, functions,
+// templates and types they share. An environment is set up before it's used and
+// isn't changed by evaluations, which extend it with a layer of their own
+// instead, so one environment can serve any number of concurrent
+// evaluations.
+type Env struct {
+	outer     *Env
+	globals   map[string]Value
+	funcs     map[string]Func
+	templates map[string]*Template
+	types     map[string]*parser.TypeDecl
+}
+
+// NewEnv creates an empty environment
+func NewEnv() *Env {
+	return &Env{}
+}
+
+// Extend creates a new layer on top of this environment. Everything in
+// this environment is visible from the new layer, and what's added to the
+// layer is only visible from it.
+func (e *Env) Extend() *Env {
+	return &Env{outer: e}
+}
+
+// Global retrieves a global value from this environment or the ones it extends
+func (e *Env) Global(name string) (Value, bool) {
+	for env := e; env != nil; env = env.outer {
+		if val, ok := env.globals[name]; ok {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+// GlobalNames returns the names of the globals visible from this
+// environment, sorted
+func (e *Env) GlobalNames() []string {
+	names := make(map[string]bool)
+	for env := e; env != nil; env = env.outer {
+		for name := range env.globals {
+			names[name] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(names))
+}
+
+func (e *Env) SetGlobal(name string, val Value) {
+	if e.globals == nil {
+		e.globals = make(map[string]Value)
+	}
+	e.globals[name] = val
+}
+
+// Function retrieves a function from this environment or the ones it extends
+func (e *Env) Function(name string) (Func, bool) {
+	for env := e; env != nil; env = env.outer {
+		if f, ok := env.funcs[name]; ok {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// FunctionNames returns the names of the functions visible from this
+// environment, sorted
+func (e *Env) FunctionNames() []string {
+	names := make(map[string]bool)
+	for env := e; env != nil; env = env.outer {
+		for name := range env.funcs {
+			names[name] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(names))
+}
+
+func (e *Env) SetFunction(name string, f Func) {
+	if e.funcs == nil {
+		e.funcs = make(map[string]Func)
+	}
+	e.funcs[name] = f
+}
+
+// Template retrieves a template from this environment or the ones it extends
+func (e *Env) Template(name string) (*Template, error) {
+	for env := e; env != nil; env = env.outer {
+		if tmpl, ok := env.templates[name]; ok {
+			return tmpl, nil
+		}
+	}
+	return nil, fmt.Errorf("undefined template: %s", name)
+}
+
+// DefineTemplate registers a template in this environment. Defining a name
+// that's already visible is an error, unless the template is an override,
+// in which case the previous definition becomes its super template.
+func (e *Env) DefineTemplate(name string, tmpl *Template) error {
+	prev, _ := e.Template(name)
+
+	if tmpl.Override {
+		if prev == nil {
+			return fmt.Errorf("cannot override undefined template %q", name)
+		}
+		tmpl.Super = prev
+	} else if prev != nil {
+		return fmt.Errorf("template %q is already defined at %s, use override to replace it", name, prev.Location())
+	}
+
+	if e.templates == nil {
+		e.templates = make(map[string]*Template)
+	}
+	e.templates[name] = tmpl
+	return nil
+}
+
+// Templates returns the templates visible from this environment, sorted by name
+func (e *Env) Templates() []*Template {
+	visible := make(map[string]*Template)
+	for env := e; env != nil; env = env.outer {
+		for name, tmpl := range env.templates {
+			if _, ok := visible[name]; !ok {
+				visible[name] = tmpl
+			}
+		}
+	}
+
+	var templates []*Template
+	for _, name := range slices.Sorted(maps.Keys(visible)) {
+		templates = append(templates, visible[name])
+	}
+	return templates
+}
+
+// Type retrieves a type declaration from this environment or the ones it
+// extends
+func (e *Env) Type(name string) (*parser.TypeDecl, bool) {
+	for env := e; env != nil; env = env.outer {
+		if decl, ok := env.types[name]; ok {
+			return decl, true
+		}
+	}
+	return nil, false
+}
+
+// TypeNames returns the names of the types declared in this environment
+// and the ones it extends, sorted
+func (e *Env) TypeNames() []string {
+	names := make(map[string]bool)
+	for env := e; env != nil; env = env.outer {
+		for name := range env.types {
+			names[name] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(names))
+}
+
+// DefineType registers a type declaration in this environment. Declaring a
+// name that's already visible is an error.
+func (e *Env) DefineType(decl *parser.TypeDecl) error {
+	if prev, ok := e.Type(decl.Name); ok {
+		return fmt.Errorf("type %q is already declared at %s", decl.Name, location(prev.Pos))
+	}
+
+	if e.types == nil {
+		e.types = make(map[string]*parser.TypeDecl)
+	}
+	e.types[decl.Name] = decl
+	return nil
+}
+
+// location formats a source position for messages
+func location(pos parser.Pos) string {
+	filename := pos.Filename
+	if filename == "" {
+		filename = "<unknown>"
+	}
+	return fmt.Sprintf("%s:%d:%d", filename, pos.Line, pos.Col)
+}
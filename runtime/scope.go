@@ -2,103 +2,260 @@ package runtime
 
 import (
 	"fmt"
+	"maps"
+	"slices"
 
 	"helmtk.dev/code/htkl/parser"
 )
 
-// Scope manages variable bindings and template definitions
+// Scope manages variable bindings, and gives access to the environment of
+// globals, functions and templates they're evaluated in
 type Scope struct {
-	parent    *Scope
-	vars      map[string]Value
-	globals   map[string]Value
-	funcs     map[string]Func
-	templates map[string]*Template
+	parent   *Scope
+	vars     map[string]Value
+	types    map[string]parser.TypeExpr // Declared types of the scope's variables
+	frame    *parser.Frame              // Variables kept in slots rather than in vars
+	slots    []Value                    // Values of the frame's variables, nil until set
+	env      *Env
+	ownsEnv  bool // Whether the environment can be changed through this scope
+	isolated bool // Whether variables of parent scopes are read-only
 }
 
-// NewScope creates a new scope with an optional parent
+// NewScope creates a new scope with an optional parent. Child scopes share
+// the environment of their parent, and only allocate a map for their own
+// variables once something is stored in them.
 func NewScope(parent *Scope) *Scope {
-	s := &Scope{
-		parent:    parent,
-		vars:      make(map[string]Value),
-		globals:   make(map[string]Value),
-		funcs:     make(map[string]Func),
-		templates: make(map[string]*Template),
-	}
 	if parent != nil {
-		s.Link(parent)
+		return &Scope{parent: parent, env: parent.env}
 	}
+	return &Scope{env: NewEnv(), ownsEnv: true}
+}
+
+// Isolated creates a child scope for a separate evaluation. It can read the
+// variables of this scope but not assign to them, and globals, functions
+// and templates added through it go into a layer of its own, so this scope
+// is never changed through it.
+func (s *Scope) Isolated() *Scope {
+	return &Scope{parent: s, env: s.env, isolated: true}
+}
+
+// NewFrame creates a child scope that keeps the variables listed in frame
+// in slots, so that identifiers bound to them are found without a lookup
+// by name. A nil frame gives a plain child scope, like NewScope.
+func NewFrame(parent *Scope, frame *parser.Frame) *Scope {
+	s := NewScope(parent)
+	s.setFrame(frame)
 	return s
 }
 
+// IsolatedFrame is like Isolated, and keeps the variables listed in frame
+// in slots like NewFrame
+func (s *Scope) IsolatedFrame(frame *parser.Frame) *Scope {
+	scope := s.Isolated()
+	scope.setFrame(frame)
+	return scope
+}
+
+func (s *Scope) setFrame(frame *parser.Frame) {
+	if frame != nil && len(frame.Names) > 0 {
+		s.frame = frame
+		s.slots = make([]Value, len(frame.Names))
+	}
+}
+
+// Env returns the environment of globals, functions and templates the
+// scope is evaluated in
+func (s *Scope) Env() *Env {
+	return s.env
+}
+
+// ownEnv returns the environment to add globals, functions and templates
+// to. A scope that shares its environment with other scopes gets a layer of
+// its own first, so the shared environment is never changed.
+func (s *Scope) ownEnv() *Env {
+	if !s.ownsEnv {
+		s.env = s.env.Extend()
+		s.ownsEnv = true
+	}
+	return s.env
+}
+
 func (s *Scope) GetFunction(name string) (Func, bool) {
-	f, ok := s.funcs[name]
-	return f, ok
+	return s.env.Function(name)
 }
 
 func (s *Scope) SetFunction(name string, f Func) {
-	s.funcs[name] = f
+	s.ownEnv().SetFunction(name, f)
 }
 
-// Get retrieves a variable value from this scope or parent scopes
+// Get retrieves a variable value from this scope or parent scopes, or a
+// global value from the environment
 func (s *Scope) Get(name string) (Value, error) {
-	// Check this scope
-	if val, ok := s.vars[name]; ok {
+	for scope := s; scope != nil; scope = scope.parent {
+		if val, ok := scope.local(name); ok {
+			return val, nil
+		}
+	}
+
+	if val, ok := s.env.Global(name); ok {
 		return val, nil
 	}
 
-	// Check parent scope
-	if s.parent != nil {
-		return s.parent.Get(name)
+	return nil, fmt.Errorf("undefined variable: %s", name)
+}
+
+// Lookup retrieves the variable an identifier is bound to, from the
+// nearest scope with the binding's frame. It reports false when the
+// variable hasn't been set yet, and the identifier should then be looked up
+// by name with Get, since it may refer to a variable of an enclosing scope.
+func (s *Scope) Lookup(b *parser.Binding) (Value, bool) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if scope.frame == b.Frame {
+			val := scope.slots[b.Index]
+			return val, val != nil
+		}
 	}
+	return nil, false
+}
 
-	// Check this scope
-	if val, ok := s.globals[name]; ok {
-		return val, nil
+// local retrieves a variable declared in this scope itself
+func (s *Scope) local(name string) (Value, bool) {
+	if s.frame != nil {
+		if i := s.frame.Index(name); i >= 0 && s.slots[i] != nil {
+			return s.slots[i], true
+		}
 	}
+	val, ok := s.vars[name]
+	return val, ok
+}
 
-	return nil, fmt.Errorf("undefined variable: %s", name)
+// Names returns the names of the variables and globals visible from this
+// scope, sorted
+func (s *Scope) Names() []string {
+	names := make(map[string]bool)
+	for scope := s; scope != nil; scope = scope.parent {
+		for name := range scope.vars {
+			names[name] = true
+		}
+		for i, val := range scope.slots {
+			if val != nil {
+				names[scope.frame.Names[i]] = true
+			}
+		}
+	}
+	for _, name := range s.env.GlobalNames() {
+		names[name] = true
+	}
+	return slices.Sorted(maps.Keys(names))
 }
 
 // Set binds a variable to a value in the current scope
 func (s *Scope) Set(name string, val Value) {
+	if s.frame != nil {
+		if i := s.frame.Index(name); i >= 0 {
+			s.slots[i] = val
+			return
+		}
+	}
+	if s.vars == nil {
+		s.vars = make(map[string]Value)
+	}
 	s.vars[name] = val
 }
 
+// Annotate records the type a variable of this scope is declared with,
+// which the values assigned to it must keep to. A nil type removes it.
+func (s *Scope) Annotate(name string, typ parser.TypeExpr) {
+	if typ == nil {
+		delete(s.types, name)
+		return
+	}
+	if s.types == nil {
+		s.types = make(map[string]parser.TypeExpr)
+	}
+	s.types[name] = typ
+}
+
+// Annotation returns the type that the variable a name refers to is
+// declared with, if any
+func (s *Scope) Annotation(name string) (parser.TypeExpr, bool) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if _, ok := scope.local(name); ok {
+			typ, ok := scope.types[name]
+			return typ, ok
+		}
+	}
+	return nil, false
+}
+
+// Assign updates an existing variable in the scope where it was declared.
+// Variables declared outside of an isolated scope can't be assigned from
+// within it.
+func (s *Scope) Assign(name string, val Value) error {
+	for scope := s; scope != nil; scope = scope.parent {
+		if _, ok := scope.local(name); ok {
+			scope.Set(name, val)
+			return nil
+		}
+		if scope.isolated {
+			if _, err := scope.parent.Get(name); err == nil {
+				return fmt.Errorf("cannot assign to read-only variable: %s", name)
+			}
+			break
+		}
+	}
+	return fmt.Errorf("cannot assign to undeclared variable: %s", name)
+}
+
 func (s *Scope) SetGlobal(name string, val Value) {
-	s.globals[name] = val
+	s.ownEnv().SetGlobal(name, val)
 }
 
-// DefineTemplate registers a template in the current scope
-func (s *Scope) DefineTemplate(name string, tmpl *Template) {
-	s.templates[name] = tmpl
+// DefineTemplate registers a template in the scope's environment (see
+// Env.DefineTemplate). Templates defined in a child scope aren't visible
+// from its parent.
+func (s *Scope) DefineTemplate(name string, tmpl *Template) error {
+	return s.ownEnv().DefineTemplate(name, tmpl)
 }
 
+// Link makes the globals, functions and templates of another scope visible
+// from this one, without its variables
 func (s *Scope) Link(other *Scope) {
-	s.templates = other.templates
-	s.globals = other.globals
-	s.funcs = other.funcs
+	s.env = other.env
+	s.ownsEnv = false
 }
 
-// GetTemplate retrieves a template from this scope or parent scopes
-func (s *Scope) GetTemplate(name string) (*Template, error) {
-	// Check this scope
-	if tmpl, ok := s.templates[name]; ok {
-		return tmpl, nil
-	}
+// DefineType registers a type declaration in the scope's environment (see
+// Env.DefineType)
+func (s *Scope) DefineType(decl *parser.TypeDecl) error {
+	return s.ownEnv().DefineType(decl)
+}
 
-	// Check parent scope
-	if s.parent != nil {
-		return s.parent.GetTemplate(name)
-	}
+// GetType retrieves a type declaration from the scope's environment
+func (s *Scope) GetType(name string) (*parser.TypeDecl, bool) {
+	return s.env.Type(name)
+}
+
+// GetTemplate retrieves a template from the scope's environment
+func (s *Scope) GetTemplate(name string) (*Template, error) {
+	return s.env.Template(name)
+}
 
-	return nil, fmt.Errorf("undefined template: %s", name)
+// Templates returns the templates visible from this scope, sorted by name
+func (s *Scope) Templates() []*Template {
+	return s.env.Templates()
 }
 
 // Template represents a user-defined template
 type Template struct {
 	Name     string
-	Body     []parser.Node // The AST nodes to evaluate
-	Filename string        // Source file where template was defined
+	Params   []*parser.Parameter // Declared parameters, nil if the template takes any context
+	Body     []parser.Node       // The AST nodes to evaluate
+	Frame    *parser.Frame       // Variables of the body kept in slots, nil if they aren't
+	Filename string              // Source file where template was defined
+	Pos      parser.Pos          // Position of the definition
+	Override bool                // Whether the template replaces an earlier definition
+	Super    *Template           // The definition an override replaced
 }
 
 // NewTemplate creates a new template with source file information
@@ -110,4 +267,19 @@ func NewTemplate(name string, body []parser.Node, filename string) *Template {
 	}
 }
 
+// Location describes where the template was defined
+func (t *Template) Location() string {
+	filename := t.Filename
+	if t.Pos.Filename != "" {
+		filename = t.Pos.Filename
+	}
+	if filename == "" {
+		filename = "<unknown>"
+	}
+	if t.Pos.Line == 0 {
+		return filename
+	}
+	return fmt.Sprintf("%s:%d:%d", filename, t.Pos.Line, t.Pos.Col)
+}
+
 type Func func(args ...Value) (Value, error)
@@ -1,7 +1,10 @@
 package runtime
 
 import (
+	"strings"
 	"testing"
+
+	"helmtk.dev/code/htkl/parser"
 )
 
 func TestScopeVariables(t *testing.T) {
@@ -126,3 +129,147 @@ func TestScopeTemplateInheritance(t *testing.T) {
 		t.Error("expected error when parent tries to access child template")
 	}
 }
+
+func TestScopeTemplateOverride(t *testing.T) {
+	parent := NewScope(nil)
+	base := NewTemplate("labels", nil, "lib.helmtk")
+	base.Pos = parser.Pos{Filename: "lib.helmtk", Line: 3, Col: 1}
+	if err := parent.DefineTemplate("labels", base); err != nil {
+		t.Fatalf("DefineTemplate error = %v", err)
+	}
+
+	child := NewScope(parent)
+	err := child.DefineTemplate("labels", NewTemplate("labels", nil, "app.helmtk"))
+	if err == nil || !strings.Contains(err.Error(), "already defined at lib.helmtk:3:1") {
+		t.Errorf("redefinition error = %v, want the original location", err)
+	}
+
+	override := NewTemplate("labels", nil, "app.helmtk")
+	override.Override = true
+	if err := child.DefineTemplate("labels", override); err != nil {
+		t.Fatalf("override error = %v", err)
+	}
+	if override.Super != base {
+		t.Error("override should keep the previous definition as its super template")
+	}
+
+	if err := child.DefineTemplate("other", &Template{Name: "other", Override: true}); err == nil {
+		t.Error("expected error when overriding an undefined template")
+	}
+
+	// The child sees its override, the parent keeps the original
+	if got := child.Templates(); len(got) != 1 || got[0] != override {
+		t.Errorf("child.Templates() = %v, want the override", got)
+	}
+	if got := parent.Templates(); len(got) != 1 || got[0] != base {
+		t.Errorf("parent.Templates() = %v, want the original", got)
+	}
+}
+
+func TestScopeAssign(t *testing.T) {
+	parent := NewScope(nil)
+	parent.Set("x", NewNumber(1))
+
+	child := NewScope(parent)
+	if err := child.Assign("x", NewNumber(2)); err != nil {
+		t.Fatalf("child.Assign(x) error = %v", err)
+	}
+
+	// The update is visible in the declaring scope
+	val, err := parent.Get("x")
+	if err != nil {
+		t.Fatalf("parent.Get(x) error = %v", err)
+	}
+	if num, ok := val.(*NumberValue); !ok || num.Value != 2 {
+		t.Errorf("parent.Get(x) = %v, want 2", val)
+	}
+
+	// Assigning an undeclared variable is an error
+	if err := child.Assign("y", NewNumber(3)); err == nil {
+		t.Error("expected error when assigning undeclared variable")
+	}
+}
+
+func TestScopeIsolated(t *testing.T) {
+	root := NewScope(nil)
+	root.Set("x", NewNumber(1))
+	root.SetGlobal("g", NewString("root"))
+	root.DefineTemplate("base", NewTemplate("base", nil, "lib.helmtk"))
+
+	iso := root.Isolated()
+	if val, err := iso.Get("x"); err != nil || val.String() != "1" {
+		t.Errorf("iso.Get(x) = %v, %v, want 1", val, err)
+	}
+	if err := iso.Assign("x", NewNumber(2)); err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("iso.Assign(x) error = %v, want read-only error", err)
+	}
+	if err := iso.Assign("y", NewNumber(2)); err == nil || !strings.Contains(err.Error(), "undeclared") {
+		t.Errorf("iso.Assign(y) error = %v, want undeclared error", err)
+	}
+
+	// Children of the isolated scope can assign its variables
+	iso.Set("y", NewNumber(1))
+	if err := NewScope(iso).Assign("y", NewNumber(2)); err != nil {
+		t.Errorf("child.Assign(y) error = %v", err)
+	}
+
+	// Globals and templates added through the isolated scope stay in it
+	iso.SetGlobal("g", NewString("iso"))
+	iso.DefineTemplate("local", NewTemplate("local", nil, "app.helmtk"))
+	if val, _ := iso.Get("g"); val.String() != "iso" {
+		t.Errorf("iso.Get(g) = %v, want iso", val)
+	}
+	if val, _ := root.Get("g"); val.String() != "root" {
+		t.Errorf("root.Get(g) = %v, want root", val)
+	}
+	if _, err := root.GetTemplate("local"); err == nil {
+		t.Error("expected the isolated scope's template to be hidden from root")
+	}
+	if got := len(iso.Templates()); got != 2 {
+		t.Errorf("len(iso.Templates()) = %d, want 2", got)
+	}
+	if iso.Env() == root.Env() {
+		t.Error("expected the isolated scope to have its own environment layer")
+	}
+}
+
+func TestScopeFrame(t *testing.T) {
+	frame := &parser.Frame{Names: []string{"x", "y"}}
+	parent := NewScope(nil)
+	parent.Set("x", NewNumber(1))
+
+	scope := NewFrame(parent, frame)
+	x := &parser.Binding{Frame: frame, Index: 0}
+
+	// A slot that hasn't been set falls back to a lookup by name
+	if _, ok := scope.Lookup(x); ok {
+		t.Error("scope.Lookup(x) found a variable before it was set")
+	}
+	if val, err := scope.Get("x"); err != nil || val.String() != "1" {
+		t.Errorf("scope.Get(x) = %v, %v, want 1", val, err)
+	}
+
+	// Variables of the frame go into slots, others are kept by name
+	scope.Set("x", NewNumber(2))
+	scope.Set("z", NewNumber(3))
+	if val, ok := NewScope(scope).Lookup(x); !ok || val.String() != "2" {
+		t.Errorf("child.Lookup(x) = %v, %v, want 2", val, ok)
+	}
+	if val, err := scope.Get("z"); err != nil || val.String() != "3" {
+		t.Errorf("scope.Get(z) = %v, %v, want 3", val, err)
+	}
+	if got := strings.Join(scope.Names(), " "); got != "x z" {
+		t.Errorf("scope.Names() = %q, want \"x z\"", got)
+	}
+
+	// Assignments update the slot
+	if err := NewScope(scope).Assign("x", NewNumber(4)); err != nil {
+		t.Fatalf("child.Assign(x) error = %v", err)
+	}
+	if val, ok := scope.Lookup(x); !ok || val.String() != "4" {
+		t.Errorf("scope.Lookup(x) = %v, %v, want 4", val, ok)
+	}
+	if val, _ := parent.Get("x"); val.String() != "1" {
+		t.Errorf("parent.Get(x) = %v, want 1", val)
+	}
+}
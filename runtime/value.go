@@ -2,9 +2,14 @@ package runtime
 
 import (
 	"fmt"
+	"maps"
+	"math"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
+
+	"helmtk.dev/code/htkl/parser"
 )
 
 // ValueType represents the type of a runtime value
@@ -99,15 +104,27 @@ func (a *ArrayValue) String() string {
 }
 func (a *ArrayValue) IsTruthy() bool { return len(a.Elements) > 0 }
 
+// Copy returns a shallow copy of the array
+func (a *ArrayValue) Copy() *ArrayValue {
+	elements := make([]Value, len(a.Elements))
+	copy(elements, a.Elements)
+	return &ArrayValue{Elements: elements}
+}
+
 // ObjectValue represents an object (map of string keys to values)
 type ObjectValue struct {
-	Fields map[string]Value
+	Fields    map[string]Value
+	Hidden    map[string]bool       // Fields that are readable but left out of the output
+	Positions map[string]parser.Pos // Where fields were set in the source, when tracked
 }
 
 func (o *ObjectValue) Type() ValueType { return ObjectType }
 func (o *ObjectValue) String() string {
 	var parts []string
 	for k, v := range o.Fields {
+		if o.Hidden[k] {
+			continue
+		}
 		parts = append(parts, fmt.Sprintf("%s: %s", k, v.String()))
 	}
 	return "{" + strings.Join(parts, ", ") + "}"
@@ -120,12 +137,105 @@ func (o *ObjectValue) Get(key string) (Value, bool) {
 	return val, ok
 }
 
-// Set sets a field in the object
+// Set sets a field in the object. A hidden field of the same key is
+// replaced by a visible one.
 func (o *ObjectValue) Set(key string, val Value) {
 	if o.Fields == nil {
 		o.Fields = make(map[string]Value)
 	}
 	o.Fields[key] = val
+	delete(o.Hidden, key)
+}
+
+// SetHidden sets a field that can be read, but is left out of the output
+func (o *ObjectValue) SetHidden(key string, val Value) {
+	o.Set(key, val)
+	if o.Hidden == nil {
+		o.Hidden = make(map[string]bool)
+	}
+	o.Hidden[key] = true
+}
+
+// IsHidden reports whether a field is hidden from the output
+func (o *ObjectValue) IsHidden(key string) bool {
+	return o.Hidden[key]
+}
+
+// SetPos records where a field was set in the source
+func (o *ObjectValue) SetPos(key string, pos parser.Pos) {
+	if o.Positions == nil {
+		o.Positions = make(map[string]parser.Pos)
+	}
+	o.Positions[key] = pos
+}
+
+// Pos returns where a field was set in the source, if it was recorded
+func (o *ObjectValue) Pos(key string) (parser.Pos, bool) {
+	pos, ok := o.Positions[key]
+	return pos, ok
+}
+
+// Copy returns a shallow copy of the object
+func (o *ObjectValue) Copy() *ObjectValue {
+	fields := make(map[string]Value, len(o.Fields))
+	for k, v := range o.Fields {
+		fields[k] = v
+	}
+	var hidden map[string]bool
+	if len(o.Hidden) > 0 {
+		hidden = make(map[string]bool, len(o.Hidden))
+		for k := range o.Hidden {
+			hidden[k] = true
+		}
+	}
+	return &ObjectValue{Fields: fields, Hidden: hidden, Positions: maps.Clone(o.Positions)}
+}
+
+// StripHidden returns the value with hidden fields removed from all nested
+// objects. Only the objects and arrays that contain hidden fields are
+// copied, the rest of the value is shared with the original.
+func StripHidden(v Value) Value {
+	switch val := v.(type) {
+	case *ObjectValue:
+		var out *ObjectValue
+		for k, field := range val.Fields {
+			stripped := StripHidden(field)
+			if out == nil && (stripped != field || val.Hidden[k]) {
+				out = &ObjectValue{Fields: make(map[string]Value, len(val.Fields)), Positions: maps.Clone(val.Positions)}
+				for k, field := range val.Fields {
+					if !val.Hidden[k] {
+						out.Fields[k] = field
+					}
+				}
+			}
+			if out != nil && !val.Hidden[k] {
+				out.Fields[k] = stripped
+			}
+		}
+		if out == nil {
+			return v
+		}
+		return out
+
+	case *ArrayValue:
+		var out *ArrayValue
+		for i, elem := range val.Elements {
+			stripped := StripHidden(elem)
+			if out == nil && stripped != elem {
+				out = &ArrayValue{Elements: slices.Clone(val.Elements)}
+			}
+			if out != nil {
+				out.Elements[i] = stripped
+			}
+		}
+		if out == nil {
+			return v
+		}
+		return out
+
+	default:
+		return v
+	}
 }
 
 // Helper functions for type checking
@@ -202,16 +312,21 @@ func ToBool(v Value) bool {
 // ToNative converts a Value to a native Go value.
 // Returns:
 //   - string for StringValue
-//   - float64 for NumberValue
+//   - int64 for NumberValue holding a whole number that float64 represents
+//     exactly, so it's encoded without a fraction or exponent
+//   - float64 for other NumberValue
 //   - bool for BoolValue
 //   - nil for NullValue
 //   - []any for ArrayValue
-//   - map[string]any for ObjectValue
+//   - map[string]any for ObjectValue, without its hidden fields
 func ToNative(v Value) any {
 	switch val := v.(type) {
 	case *StringValue:
 		return val.Value
 	case *NumberValue:
+		if val.Value == math.Trunc(val.Value) && math.Abs(val.Value) < 1<<53 {
+			return int64(val.Value)
+		}
 		return val.Value
 	case *BoolValue:
 		return val.Value
@@ -226,7 +341,9 @@ func ToNative(v Value) any {
 	case *ObjectValue:
 		result := make(map[string]any, len(val.Fields))
 		for k, v := range val.Fields {
-			result[k] = ToNative(v)
+			if !val.Hidden[k] {
+				result[k] = ToNative(v)
+			}
 		}
 		return result
 	default:
@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"encoding/json"
 	"testing"
 )
 
@@ -69,3 +70,75 @@ func TestObjectValue(t *testing.T) {
 		t.Error("empty object should be falsy")
 	}
 }
+
+func TestStripHidden(t *testing.T) {
+	inner := NewObject()
+	inner.Set("visible", NewNumber(1))
+	inner.SetHidden("secret", NewNumber(2))
+
+	obj := NewObject()
+	obj.SetHidden("helper", NewString("x"))
+	obj.Set("items", &ArrayValue{Elements: []Value{inner}})
+
+	stripped := StripHidden(obj).(*ObjectValue)
+	if _, ok := stripped.Get("helper"); ok {
+		t.Error("expected hidden field 'helper' to be stripped")
+	}
+	items, _ := stripped.Get("items")
+	if got := items.String(); got != "[{visible: 1}]" {
+		t.Errorf("items = %s, want [{visible: 1}]", got)
+	}
+
+	// The original keeps its hidden fields
+	if _, ok := obj.Get("helper"); !ok || !obj.IsHidden("helper") {
+		t.Error("expected the original object to keep its hidden field")
+	}
+	if !obj.Copy().IsHidden("helper") {
+		t.Error("expected Copy to keep hidden fields")
+	}
+
+	// Encoders leave hidden fields out as well
+	if got := obj.String(); got != "{items: [{visible: 1}]}" {
+		t.Errorf("String() = %s, want {items: [{visible: 1}]}", got)
+	}
+	data, err := json.Marshal(ToNative(obj))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != `{"items":[{"visible":1}]}` {
+		t.Errorf("ToNative() encodes to %s, want {\"items\":[{\"visible\":1}]}", got)
+	}
+
+	// Setting a hidden field again makes it visible
+	obj.Set("helper", NewString("y"))
+	if obj.IsHidden("helper") {
+		t.Error("expected Set to make the field visible")
+	}
+}
+
+func TestToNativeNumbers(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  any
+	}{
+		{3, int64(3)},
+		{-2, int64(-2)},
+		{1e15, int64(1e15)},
+		{2.5, 2.5},
+		{1 << 53, float64(1 << 53)},
+	}
+	for _, tt := range tests {
+		if got := ToNative(NewNumber(tt.value)); got != tt.want {
+			t.Errorf("ToNative(%v) = %#v, want %#v", tt.value, got, tt.want)
+		}
+	}
+
+	// Whole numbers are encoded without an exponent
+	data, err := json.Marshal(ToNative(NewArray(NewNumber(1e15), NewNumber(0.5))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "[1000000000000000,0.5]" {
+		t.Errorf("ToNative() encodes to %s, want [1000000000000000,0.5]", got)
+	}
+}
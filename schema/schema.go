@@ -0,0 +1,438 @@
+// Package schema validates values against JSON Schemas, like the
+// values.schema.json files that Helm charts ship, and fills in the defaults
+// they declare.
+//
+// A subset of draft 2020-12 is supported: type, enum, const, the numeric,
+// string, array and object constraints, the allOf, anyOf, oneOf, not and
+// if/then/else applicators, and $ref to other parts of the same schema.
+// Schemas using the other keywords that constrain values, such as
+// unevaluatedProperties or dependentSchemas, are rejected rather than
+// letting through values they forbid. Annotations, such as format, title
+// and description, are accepted and ignored, and so are unknown keywords.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"helmtk.dev/code/htkl/runtime"
+)
+
+// Schema is a compiled JSON Schema
+type Schema struct {
+	root *node
+}
+
+// node is a compiled schema or subschema. Keywords that aren't set are
+// left nil.
+type node struct {
+	always *bool  // Set for the boolean schemas true and false
+	ptr    string // Where the schema is in the document
+
+	ref   *node // Target of $ref, filled in once it's compiled
+	types []string
+	enum  []runtime.Value
+	konst runtime.Value // Value required by const
+	def   runtime.Value // Value given by default
+
+	minimum, maximum                   *float64
+	exclusiveMinimum, exclusiveMaximum *float64
+	multipleOf                         *float64
+
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+
+	items                *node
+	prefixItems          []*node
+	minItems, maxItems   *int
+	uniqueItems          bool
+	properties           map[string]*node
+	patternProperties    []patternProperty
+	additionalProperties *node
+	required             []string
+	dependentRequired    map[string][]string
+	minProps, maxProps   *int
+
+	allOf, anyOf, oneOf []*node
+	not                 *node
+	ifNode              *node
+	thenNode, elseNode  *node
+}
+
+// unsupported lists the keywords of draft 2020-12, and of the earlier
+// drafts charts still use, that constrain values but aren't implemented
+var unsupported = []string{
+	"$dynamicRef", "$recursiveRef", "additionalItems", "contains",
+	"dependencies", "dependentSchemas", "maxContains", "minContains",
+	"propertyNames", "unevaluatedItems", "unevaluatedProperties",
+}
+
+type patternProperty struct {
+	pattern *regexp.Regexp
+	schema  *node
+}
+
+// Parse compiles a JSON Schema
+func Parse(data []byte) (*Schema, error) {
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing schema: %w", err)
+	}
+	c := &compiler{root: raw, refs: make(map[string]*node)}
+	root, err := c.resolve("#")
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCycles(root); err != nil {
+		return nil, err
+	}
+	return &Schema{root: root}, nil
+}
+
+// compiler turns decoded JSON into nodes, keeping the nodes that $refs
+// point to so that recursive schemas only compile once
+type compiler struct {
+	root any
+	refs map[string]*node
+}
+
+func (c *compiler) compile(raw any, ptr string) (*node, error) {
+	switch s := raw.(type) {
+	case bool:
+		return &node{always: &s}, nil
+	case map[string]any:
+		return c.compileObject(s, ptr)
+	default:
+		return nil, fmt.Errorf("schema at %q must be an object or a boolean", displayPointer(ptr))
+	}
+}
+
+func (c *compiler) compileObject(s map[string]any, ptr string) (*node, error) {
+	for _, key := range unsupported {
+		if _, ok := s[key]; ok {
+			return nil, fmt.Errorf("unsupported keyword %s at %q", key, displayPointer(ptr))
+		}
+	}
+
+	n := &node{ptr: ptr}
+	// Errors from the helpers are collected here, so the keywords below
+	// read in a list
+	var err error
+	fail := func(e error) {
+		if err == nil && e != nil {
+			err = e
+		}
+	}
+	sub := func(key string) *node {
+		raw, ok := s[key]
+		if !ok {
+			return nil
+		}
+		child, e := c.compile(raw, ptr+"/"+escape(key))
+		fail(e)
+		return child
+	}
+	list := func(key string) []*node {
+		raw, ok := s[key]
+		if !ok {
+			return nil
+		}
+		items, ok := raw.([]any)
+		if !ok {
+			fail(fmt.Errorf("%s at %q must be an array", key, displayPointer(ptr)))
+			return nil
+		}
+		nodes := make([]*node, len(items))
+		for i, item := range items {
+			child, e := c.compile(item, fmt.Sprintf("%s/%s/%d", ptr, key, i))
+			fail(e)
+			nodes[i] = child
+		}
+		return nodes
+	}
+	number := func(key string) *float64 {
+		raw, ok := s[key]
+		if !ok {
+			return nil
+		}
+		f, ok := raw.(float64)
+		if !ok {
+			fail(fmt.Errorf("%s at %q must be a number", key, displayPointer(ptr)))
+			return nil
+		}
+		return &f
+	}
+	count := func(key string) *int {
+		f := number(key)
+		if f == nil {
+			return nil
+		}
+		i := int(*f)
+		return &i
+	}
+	regex := func(key, expr string) *regexp.Regexp {
+		re, e := regexp.Compile(expr)
+		if e != nil {
+			fail(fmt.Errorf("%s at %q: %w", key, displayPointer(ptr), e))
+		}
+		return re
+	}
+
+	if ref, ok := s["$ref"].(string); ok {
+		target, e := c.resolve(ref)
+		fail(e)
+		n.ref = target
+	}
+
+	switch t := s["type"].(type) {
+	case string:
+		n.types = []string{t}
+	case []any:
+		for _, name := range t {
+			if name, ok := name.(string); ok {
+				n.types = append(n.types, name)
+			}
+		}
+	}
+	for _, name := range n.types {
+		if !slices.Contains(typeNames, name) {
+			fail(fmt.Errorf("unknown type %q at %q", name, displayPointer(ptr)))
+		}
+	}
+
+	if enum, ok := s["enum"].([]any); ok {
+		for _, v := range enum {
+			n.enum = append(n.enum, runtime.NewValue(v))
+		}
+	}
+	if v, ok := s["const"]; ok {
+		n.konst = runtime.NewValue(v)
+	}
+	if v, ok := s["default"]; ok {
+		n.def = runtime.NewValue(v)
+	}
+
+	n.minimum = number("minimum")
+	n.maximum = number("maximum")
+	n.exclusiveMinimum = number("exclusiveMinimum")
+	n.exclusiveMaximum = number("exclusiveMaximum")
+	n.multipleOf = number("multipleOf")
+
+	n.minLength = count("minLength")
+	n.maxLength = count("maxLength")
+	if expr, ok := s["pattern"].(string); ok {
+		n.pattern = regex("pattern", expr)
+	}
+
+	n.items = sub("items")
+	n.prefixItems = list("prefixItems")
+	n.minItems = count("minItems")
+	n.maxItems = count("maxItems")
+	n.uniqueItems, _ = s["uniqueItems"].(bool)
+
+	if props, ok := s["properties"].(map[string]any); ok {
+		n.properties = make(map[string]*node, len(props))
+		for name, raw := range props {
+			child, e := c.compile(raw, ptr+"/properties/"+escape(name))
+			fail(e)
+			n.properties[name] = child
+		}
+	}
+	if props, ok := s["patternProperties"].(map[string]any); ok {
+		for _, expr := range slices.Sorted(maps.Keys(props)) {
+			child, e := c.compile(props[expr], ptr+"/patternProperties/"+escape(expr))
+			fail(e)
+			n.patternProperties = append(n.patternProperties, patternProperty{regex("patternProperties", expr), child})
+		}
+	}
+	n.additionalProperties = sub("additionalProperties")
+	n.required = stringList(s["required"])
+	if deps, ok := s["dependentRequired"].(map[string]any); ok {
+		n.dependentRequired = make(map[string][]string, len(deps))
+		for name, raw := range deps {
+			n.dependentRequired[name] = stringList(raw)
+		}
+	}
+	n.minProps = count("minProperties")
+	n.maxProps = count("maxProperties")
+
+	n.allOf = list("allOf")
+	n.anyOf = list("anyOf")
+	n.oneOf = list("oneOf")
+	n.not = sub("not")
+	n.ifNode = sub("if")
+	n.thenNode = sub("then")
+	n.elseNode = sub("else")
+
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// resolve compiles the part of the schema a $ref points to. Only pointers
+// within the same document are supported.
+func (c *compiler) resolve(ref string) (*node, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported $ref %q, only references within the schema are allowed", ref)
+	}
+	ptr := strings.TrimPrefix(ref, "#")
+	if n, ok := c.refs[ptr]; ok {
+		return n, nil
+	}
+
+	raw := c.root
+	if ptr != "" {
+		for _, token := range strings.Split(strings.TrimPrefix(ptr, "/"), "/") {
+			token = unescape(token)
+			switch v := raw.(type) {
+			case map[string]any:
+				raw = v[token]
+			case []any:
+				i, err := strconv.Atoi(token)
+				if err != nil || i < 0 || i >= len(v) {
+					return nil, fmt.Errorf("$ref %q not found", ref)
+				}
+				raw = v[i]
+			default:
+				raw = nil
+			}
+			if raw == nil {
+				return nil, fmt.Errorf("$ref %q not found", ref)
+			}
+		}
+	}
+
+	// Register the node before compiling it, so references back to it
+	// from inside find it
+	n := &node{}
+	c.refs[ptr] = n
+	compiled, err := c.compile(raw, ptr)
+	if err != nil {
+		return nil, err
+	}
+	*n = *compiled
+	return n, nil
+}
+
+// checkCycles reports a schema that refers back to itself without going
+// through a property or an item, like {"$ref": "#"}. Validating it would
+// apply it to the same value forever.
+func checkCycles(root *node) error {
+	// Collect the nodes first, so that the search below only follows the
+	// keywords that apply to the same value
+	var nodes []*node
+	seen := make(map[*node]bool)
+	var collect func(n *node)
+	collect = func(n *node) {
+		if n == nil || seen[n] {
+			return
+		}
+		seen[n] = true
+		nodes = append(nodes, n)
+		for _, next := range n.inPlace() {
+			collect(next)
+		}
+		for _, child := range n.children() {
+			collect(child)
+		}
+	}
+	collect(root)
+
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[*node]int)
+	var visit func(n *node) error
+	visit = func(n *node) error {
+		switch state[n] {
+		case visiting:
+			return fmt.Errorf("schema at %q refers to itself without a property or item in between", displayPointer(n.ptr))
+		case visited:
+			return nil
+		}
+		state[n] = visiting
+		for _, next := range n.inPlace() {
+			if err := visit(next); err != nil {
+				return err
+			}
+		}
+		state[n] = visited
+		return nil
+	}
+	for _, n := range nodes {
+		if err := visit(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inPlace returns the subschemas that apply to the same value as the node
+func (n *node) inPlace() []*node {
+	var nodes []*node
+	for _, next := range []*node{n.ref, n.not, n.ifNode, n.thenNode, n.elseNode} {
+		if next != nil {
+			nodes = append(nodes, next)
+		}
+	}
+	nodes = append(nodes, n.allOf...)
+	nodes = append(nodes, n.anyOf...)
+	return append(nodes, n.oneOf...)
+}
+
+// children returns the subschemas that apply to the items and properties
+// of the value
+func (n *node) children() []*node {
+	nodes := slices.Clone(n.prefixItems)
+	for _, next := range []*node{n.items, n.additionalProperties} {
+		if next != nil {
+			nodes = append(nodes, next)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(n.properties)) {
+		nodes = append(nodes, n.properties[name])
+	}
+	for _, prop := range n.patternProperties {
+		nodes = append(nodes, prop.schema)
+	}
+	return nodes
+}
+
+// typeNames are the types that the type keyword can name
+var typeNames = []string{"null", "boolean", "object", "array", "number", "integer", "string"}
+
+// stringList returns the strings in a decoded JSON array
+func stringList(raw any) []string {
+	items, _ := raw.([]any)
+	var result []string
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// escape encodes a property name as a JSON pointer token
+func escape(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+func unescape(token string) string {
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+}
+
+// displayPointer shows the pointer to the root as "/" rather than nothing
+func displayPointer(ptr string) string {
+	if ptr == "" {
+		return "/"
+	}
+	return ptr
+}
@@ -0,0 +1,197 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+
+	"helmtk.dev/code/htkl/runtime"
+)
+
+const valuesSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"required": ["name"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "pattern": "^[a-z][a-z0-9-]*$", "maxLength": 20},
+		"replicas": {"type": "integer", "minimum": 1, "default": 1},
+		"image": {
+			"type": "object",
+			"default": {},
+			"properties": {
+				"repository": {"type": "string", "default": "nginx"},
+				"tag": {"type": "string", "default": "latest"},
+				"pullPolicy": {"enum": ["Always", "IfNotPresent", "Never"]}
+			}
+		},
+		"ports": {"type": "array", "items": {"$ref": "#/$defs/port"}, "uniqueItems": true},
+		"labels": {"type": "object", "additionalProperties": {"type": "string"}},
+		"tree": {"$ref": "#/$defs/tree"}
+	},
+	"$defs": {
+		"port": {
+			"type": "object",
+			"required": ["port"],
+			"properties": {
+				"name": {"type": "string"},
+				"port": {"type": "integer", "exclusiveMinimum": 0, "maximum": 65535},
+				"protocol": {"enum": ["TCP", "UDP"], "default": "TCP"}
+			}
+		},
+		"tree": {
+			"type": "object",
+			"properties": {
+				"children": {"type": "array", "items": {"$ref": "#/$defs/tree"}}
+			}
+		}
+	}
+}`
+
+func TestApply(t *testing.T) {
+	s, err := Parse([]byte(valuesSchema))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	values := runtime.NewValue(map[string]any{
+		"name":  "web",
+		"ports": []any{map[string]any{"port": 80}, map[string]any{"port": 53, "protocol": "UDP"}},
+	})
+	got, err := s.Apply(values)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := runtime.NewValue(map[string]any{
+		"name":     "web",
+		"replicas": 1,
+		"image":    map[string]any{"repository": "nginx", "tag": "latest"},
+		"ports": []any{
+			map[string]any{"port": 80, "protocol": "TCP"},
+			map[string]any{"port": 53, "protocol": "UDP"},
+		},
+	})
+	if !equal(got, want) {
+		t.Errorf("got %s, want %s", format(got), format(want))
+	}
+
+	// The values given aren't modified
+	if _, ok := values.(*runtime.ObjectValue).Get("replicas"); ok {
+		t.Errorf("values were modified: %s", format(values))
+	}
+	ports := values.(*runtime.ObjectValue).Fields["ports"].(*runtime.ArrayValue)
+	if _, ok := ports.Elements[0].(*runtime.ObjectValue).Get("protocol"); ok {
+		t.Errorf("ports were modified: %s", format(values))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s, err := Parse([]byte(valuesSchema))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	values := runtime.NewValue(map[string]any{
+		"name":     "Web_1",
+		"replicas": 1.5,
+		"image":    map[string]any{"tag": 3, "pullPolicy": "Sometimes"},
+		"ports":    []any{map[string]any{"port": 80}, map[string]any{"port": "8080"}, map[string]any{"port": 0}, map[string]any{"name": "dns"}},
+		"labels":   map[string]any{"app": "web", "tier": 1},
+		"tree":     map[string]any{"children": []any{map[string]any{"children": "none"}}},
+		"extra":    true,
+	})
+	err = s.Validate(values)
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+
+	want := []string{
+		`/extra: property is not allowed`,
+		`/image/pullPolicy: must be one of "Always", "IfNotPresent", "Never", got "Sometimes"`,
+		`/image/tag: expected string, got number`,
+		`/labels/tier: expected string, got number`,
+		`/name: must match pattern "^[a-z][a-z0-9-]*$"`,
+		`/ports/1/port: expected integer, got string`,
+		`/ports/2/port: must be > 0`,
+		`/ports/3/port: required property is missing`,
+		`/replicas: expected integer, got 1.5`,
+		`/tree/children/0/children: expected array, got string`,
+	}
+	if got := err.Error(); got != strings.Join(want, "\n") {
+		t.Errorf("got errors:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+
+	if err := s.Validate(runtime.NewValue("web")); err == nil || err.Error() != "/: expected object, got string" {
+		t.Errorf("got %v, want a type error for the root", err)
+	}
+}
+
+func TestValidateKeywords(t *testing.T) {
+	tests := []struct {
+		schema string
+		value  any
+		want   string
+	}{
+		{`{"const": "v1"}`, "v2", `/: must be "v1", got "v2"`},
+		{`{"type": ["string", "null"]}`, 1, `/: expected string or null, got number`},
+		{`{"type": ["string", "null"]}`, nil, ``},
+		{`{"multipleOf": 0.5}`, 1.25, `/: must be a multiple of 0.5`},
+		{`{"minLength": 2}`, "é", `/: must be at least 2 characters long`},
+		{`{"minItems": 1}`, []any{}, `/: must have at least 1 items`},
+		{`{"uniqueItems": true}`, []any{1, 2, 1}, `/: items 0 and 2 are equal`},
+		{`{"uniqueItems": true}`, []any{map[string]any{"a": 1}, map[string]any{"a": 1}}, `/: items 0 and 1 are equal`},
+		{`{"prefixItems": [{"type": "string"}], "items": false}`, []any{"a", 1}, `/1: no value is allowed`},
+		{`{"maxProperties": 1}`, map[string]any{"a": 1, "b": 2}, `/: must have at most 1 properties`},
+		{`{"patternProperties": {"^x-": {"type": "string"}}}`, map[string]any{"x-a": 1, "b": 2}, `/x-a: expected string, got number`},
+		{`{"dependentRequired": {"tls": ["cert"]}}`, map[string]any{"tls": true}, `/cert: required property is missing, since "tls" is set`},
+		{`{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, 1.5, `/: must match at least one schema in anyOf`},
+		{`{"oneOf": [{"type": "number"}, {"type": "integer"}]}`, 1, `/: must match exactly one schema in oneOf, matched 2`},
+		{`{"not": {"type": "null"}}`, nil, `/: must not match the schema in not`},
+		{`{"allOf": [{"minimum": 1}, {"maximum": 3}]}`, 4, `/: must be <= 3`},
+		{`{"if": {"properties": {"kind": {"const": "tls"}}}, "then": {"required": ["cert"]}}`, map[string]any{"kind": "tls"}, `/cert: required property is missing`},
+		{`{"properties": {"a~b/c": {"type": "string"}}}`, map[string]any{"a~b/c": 1}, `/a~0b~1c: expected string, got number`},
+		{`{"format": "email"}`, "not an email", ``},
+	}
+
+	for _, tt := range tests {
+		s, err := Parse([]byte(tt.schema))
+		if err != nil {
+			t.Errorf("%s: parse error: %v", tt.schema, err)
+			continue
+		}
+		got := ""
+		if err := s.Validate(runtime.NewValue(tt.value)); err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("%s with %v: got %q, want %q", tt.schema, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		`[1]`:                         `schema at "/" must be an object or a boolean`,
+		`{"type": "text"}`:            `unknown type "text" at "/"`,
+		`{"$ref": "other.json#/a"}`:   `unsupported $ref "other.json#/a"`,
+		`{"$ref": "#/$defs/missing"}`: `$ref "#/$defs/missing" not found`,
+		`{"properties": {"a": {"pattern": "("}}}`:                   `pattern at "/properties/a"`,
+		`{"minimum": "1"}`:                                          `minimum at "/" must be a number`,
+		`{"unevaluatedProperties": false, "properties": {"a": {}}}`: `unsupported keyword unevaluatedProperties at "/"`,
+		`{"properties": {"a": {"dependentSchemas": {"b": {}}}}}`:    `unsupported keyword dependentSchemas at "/properties/a"`,
+		`{"$ref": "#"}`: `schema at "/" refers to itself`,
+		`{"$defs": {"a": {"$ref": "#/$defs/a"}}, "properties": {"x": {"$ref": "#/$defs/a"}}}`:                            `schema at "/$defs/a" refers to itself`,
+		`{"$defs": {"a": {"allOf": [{"$ref": "#/$defs/b"}]}, "b": {"not": {"$ref": "#/$defs/a"}}}, "$ref": "#/$defs/a"}`: `refers to itself`,
+	}
+	for input, want := range tests {
+		_, err := Parse([]byte(input))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got error %v, want %q", input, err, want)
+		}
+	}
+
+	// Annotations don't constrain values, so they're ignored
+	if _, err := Parse([]byte(`{"type": "string", "title": "Name", "description": "d", "format": "email", "examples": ["a"]}`)); err != nil {
+		t.Errorf("annotations: unexpected error %v", err)
+	}
+}
@@ -0,0 +1,364 @@
+package schema
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"helmtk.dev/code/htkl/runtime"
+)
+
+// Error describes a part of a value that doesn't match the schema
+type Error struct {
+	Pointer string // JSON pointer to the value, like /ports/2/port
+	Message string
+}
+
+func (e *Error) Error() string {
+	return displayPointer(e.Pointer) + ": " + e.Message
+}
+
+// Errors lists every part of a value that doesn't match the schema
+type Errors []*Error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Validate checks a value against the schema. It returns Errors listing
+// each mismatch, sorted by pointer, or nil if the value matches.
+func (s *Schema) Validate(v runtime.Value) error {
+	var errs Errors
+	s.root.validate(v, "", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	slices.SortStableFunc(errs, func(a, b *Error) int {
+		return cmp.Compare(a.Pointer, b.Pointer)
+	})
+	return errs
+}
+
+// Apply fills in the defaults the schema declares for properties missing
+// from a value, and validates the result. The value given isn't modified,
+// objects and arrays that get defaults are copied.
+func (s *Schema) Apply(v runtime.Value) (runtime.Value, error) {
+	v = s.root.withDefaults(v)
+	return v, s.Validate(v)
+}
+
+func (n *node) validate(v runtime.Value, ptr string, errs *Errors) {
+	report := func(format string, args ...any) {
+		*errs = append(*errs, &Error{Pointer: ptr, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if n.always != nil {
+		if !*n.always {
+			report("no value is allowed")
+		}
+		return
+	}
+	if n.ref != nil {
+		n.ref.validate(v, ptr, errs)
+	}
+
+	if n.types != nil && !slices.ContainsFunc(n.types, func(name string) bool { return hasType(v, name) }) {
+		got := typeName(v)
+		if slices.Contains(n.types, "integer") && got == "number" {
+			got = v.String()
+		}
+		report("expected %s, got %s", strings.Join(n.types, " or "), got)
+		// The other keywords would only repeat the mismatch
+		return
+	}
+	if n.enum != nil && !slices.ContainsFunc(n.enum, func(e runtime.Value) bool { return equal(v, e) }) {
+		options := make([]string, len(n.enum))
+		for i, e := range n.enum {
+			options[i] = format(e)
+		}
+		report("must be one of %s, got %s", strings.Join(options, ", "), format(v))
+	}
+	if n.konst != nil && !equal(v, n.konst) {
+		report("must be %s, got %s", format(n.konst), format(v))
+	}
+
+	switch val := v.(type) {
+	case *runtime.NumberValue:
+		n.validateNumber(val.Value, report)
+	case *runtime.StringValue:
+		length := utf8.RuneCountInString(val.Value)
+		if n.minLength != nil && length < *n.minLength {
+			report("must be at least %d characters long", *n.minLength)
+		}
+		if n.maxLength != nil && length > *n.maxLength {
+			report("must be at most %d characters long", *n.maxLength)
+		}
+		if n.pattern != nil && !n.pattern.MatchString(val.Value) {
+			report("must match pattern %q", n.pattern)
+		}
+	case *runtime.ArrayValue:
+		n.validateArray(val, ptr, errs, report)
+	case *runtime.ObjectValue:
+		n.validateObject(val, ptr, errs, report)
+	}
+
+	for _, sub := range n.allOf {
+		sub.validate(v, ptr, errs)
+	}
+	if n.anyOf != nil && !slices.ContainsFunc(n.anyOf, func(sub *node) bool { return sub.matches(v) }) {
+		report("must match at least one schema in anyOf")
+	}
+	if n.oneOf != nil {
+		matched := 0
+		for _, sub := range n.oneOf {
+			if sub.matches(v) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			report("must match exactly one schema in oneOf, matched %d", matched)
+		}
+	}
+	if n.not != nil && n.not.matches(v) {
+		report("must not match the schema in not")
+	}
+	if n.ifNode != nil {
+		if n.ifNode.matches(v) {
+			if n.thenNode != nil {
+				n.thenNode.validate(v, ptr, errs)
+			}
+		} else if n.elseNode != nil {
+			n.elseNode.validate(v, ptr, errs)
+		}
+	}
+}
+
+func (n *node) validateNumber(f float64, report func(string, ...any)) {
+	if n.minimum != nil && f < *n.minimum {
+		report("must be >= %s", formatNumber(*n.minimum))
+	}
+	if n.maximum != nil && f > *n.maximum {
+		report("must be <= %s", formatNumber(*n.maximum))
+	}
+	if n.exclusiveMinimum != nil && f <= *n.exclusiveMinimum {
+		report("must be > %s", formatNumber(*n.exclusiveMinimum))
+	}
+	if n.exclusiveMaximum != nil && f >= *n.exclusiveMaximum {
+		report("must be < %s", formatNumber(*n.exclusiveMaximum))
+	}
+	if n.multipleOf != nil && *n.multipleOf > 0 {
+		if q := f / *n.multipleOf; q != math.Trunc(q) {
+			report("must be a multiple of %s", formatNumber(*n.multipleOf))
+		}
+	}
+}
+
+func (n *node) validateArray(arr *runtime.ArrayValue, ptr string, errs *Errors, report func(string, ...any)) {
+	if n.minItems != nil && len(arr.Elements) < *n.minItems {
+		report("must have at least %d items", *n.minItems)
+	}
+	if n.maxItems != nil && len(arr.Elements) > *n.maxItems {
+		report("must have at most %d items", *n.maxItems)
+	}
+	if n.uniqueItems {
+		for i, elem := range arr.Elements {
+			if j := slices.IndexFunc(arr.Elements[:i], func(e runtime.Value) bool { return equal(e, elem) }); j >= 0 {
+				report("items %d and %d are equal", j, i)
+				break
+			}
+		}
+	}
+	for i, elem := range arr.Elements {
+		if item := n.itemSchema(i); item != nil {
+			item.validate(elem, ptr+"/"+strconv.Itoa(i), errs)
+		}
+	}
+}
+
+func (n *node) validateObject(obj *runtime.ObjectValue, ptr string, errs *Errors, report func(string, ...any)) {
+	if n.minProps != nil && len(obj.Fields) < *n.minProps {
+		report("must have at least %d properties", *n.minProps)
+	}
+	if n.maxProps != nil && len(obj.Fields) > *n.maxProps {
+		report("must have at most %d properties", *n.maxProps)
+	}
+	for _, name := range n.required {
+		if _, ok := obj.Get(name); !ok {
+			*errs = append(*errs, &Error{Pointer: ptr + "/" + escape(name), Message: "required property is missing"})
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(n.dependentRequired)) {
+		if _, ok := obj.Get(name); !ok {
+			continue
+		}
+		for _, dep := range n.dependentRequired[name] {
+			if _, ok := obj.Get(dep); !ok {
+				*errs = append(*errs, &Error{Pointer: ptr + "/" + escape(dep), Message: fmt.Sprintf("required property is missing, since %q is set", name)})
+			}
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(obj.Fields)) {
+		field := obj.Fields[name]
+		fieldPtr := ptr + "/" + escape(name)
+		known := false
+		if prop, ok := n.properties[name]; ok {
+			prop.validate(field, fieldPtr, errs)
+			known = true
+		}
+		for _, pp := range n.patternProperties {
+			if pp.pattern.MatchString(name) {
+				pp.schema.validate(field, fieldPtr, errs)
+				known = true
+			}
+		}
+		if known || n.additionalProperties == nil {
+			continue
+		}
+		if a := n.additionalProperties.always; a != nil && !*a {
+			*errs = append(*errs, &Error{Pointer: fieldPtr, Message: "property is not allowed"})
+			continue
+		}
+		n.additionalProperties.validate(field, fieldPtr, errs)
+	}
+}
+
+// matches reports whether a value matches the schema
+func (n *node) matches(v runtime.Value) bool {
+	var errs Errors
+	n.validate(v, "", &errs)
+	return len(errs) == 0
+}
+
+// itemSchema returns the schema of the array element at the given index
+func (n *node) itemSchema(i int) *node {
+	if i < len(n.prefixItems) {
+		return n.prefixItems[i]
+	}
+	return n.items
+}
+
+// withDefaults returns the value with the defaults of missing properties
+// filled in, through nested objects and arrays
+func (n *node) withDefaults(v runtime.Value) runtime.Value {
+	if n == nil || n.always != nil {
+		return v
+	}
+	if n.ref != nil {
+		v = n.ref.withDefaults(v)
+	}
+	for _, sub := range n.allOf {
+		v = sub.withDefaults(v)
+	}
+
+	switch val := v.(type) {
+	case *runtime.ObjectValue:
+		var copied *runtime.ObjectValue
+		for _, name := range slices.Sorted(maps.Keys(n.properties)) {
+			prop := n.properties[name]
+			field, ok := val.Get(name)
+			if !ok {
+				field = prop.defaultValue()
+				if field == nil {
+					continue
+				}
+			}
+			updated := prop.withDefaults(field)
+			if ok && updated == field {
+				continue
+			}
+			if copied == nil {
+				copied = val.Copy()
+			}
+			copied.Set(name, updated)
+		}
+		if copied != nil {
+			return copied
+		}
+	case *runtime.ArrayValue:
+		var copied *runtime.ArrayValue
+		for i, elem := range val.Elements {
+			updated := n.itemSchema(i).withDefaults(elem)
+			if updated == elem {
+				continue
+			}
+			if copied == nil {
+				copied = val.Copy()
+			}
+			copied.Elements[i] = updated
+		}
+		if copied != nil {
+			return copied
+		}
+	}
+	return v
+}
+
+// defaultValue returns a new copy of the schema's default, or nil when it
+// doesn't have one
+func (n *node) defaultValue() runtime.Value {
+	switch {
+	case n.def != nil:
+		return runtime.NewValue(runtime.ToNative(n.def))
+	case n.ref != nil:
+		return n.ref.defaultValue()
+	default:
+		return nil
+	}
+}
+
+// hasType reports whether a value has the JSON type with the given name
+func hasType(v runtime.Value, name string) bool {
+	if name == "integer" {
+		num, ok := v.(*runtime.NumberValue)
+		return ok && num.Value == math.Trunc(num.Value)
+	}
+	return typeName(v) == name
+}
+
+// typeName returns the name of a value's JSON type
+func typeName(v runtime.Value) string {
+	switch v.Type() {
+	case runtime.BoolType:
+		return "boolean"
+	default:
+		return v.Type().String()
+	}
+}
+
+// equal compares values deeply, unlike runtime.Equal
+func equal(a, b runtime.Value) bool {
+	switch a := a.(type) {
+	case *runtime.ArrayValue:
+		b, ok := b.(*runtime.ArrayValue)
+		return ok && slices.EqualFunc(a.Elements, b.Elements, equal)
+	case *runtime.ObjectValue:
+		b, ok := b.(*runtime.ObjectValue)
+		return ok && maps.EqualFunc(a.Fields, b.Fields, equal)
+	default:
+		return runtime.Equal(a, b)
+	}
+}
+
+// format shows a value as JSON
+func format(v runtime.Value) string {
+	data, err := json.Marshal(runtime.ToNative(v))
+	if err != nil {
+		return v.String()
+	}
+	return string(data)
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,129 @@
+package types
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"helmtk.dev/code/htkl/parser"
+	"helmtk.dev/code/htkl/runtime"
+)
+
+// Lookup finds the declaration of a named type
+type Lookup func(name string) (*parser.TypeDecl, bool)
+
+// FromExpr converts a type written in a document into a Type, looking up
+// the types it refers to by name. Declared types may refer to themselves.
+func FromExpr(expr parser.TypeExpr, lookup Lookup) (*Type, error) {
+	c := &converter{lookup: lookup, named: make(map[string]*Type)}
+	return c.convert(expr)
+}
+
+type converter struct {
+	lookup Lookup
+	named  map[string]*Type // Declared types converted so far
+	direct []string         // Declared types being converted, since the last array or object
+}
+
+func (c *converter) convert(expr parser.TypeExpr) (*Type, error) {
+	switch t := expr.(type) {
+	case *parser.NamedType:
+		return c.convertNamed(t)
+
+	case *parser.LiteralType:
+		switch v := t.Value.(type) {
+		case *parser.StringLiteral:
+			return LiteralOf(runtime.NewString(v.Value)), nil
+		case *parser.NumberLiteral:
+			return LiteralOf(runtime.NewNumber(v.Value)), nil
+		case *parser.BooleanLiteral:
+			return LiteralOf(runtime.NewBool(v.Value)), nil
+		default:
+			return Null, nil
+		}
+
+	case *parser.ArrayType:
+		defer c.indirect()()
+		elem, err := c.convert(t.Elem)
+		if err != nil {
+			return nil, err
+		}
+		return ArrayOf(elem), nil
+
+	case *parser.ObjectType:
+		defer c.indirect()()
+		obj := ObjectOf(make(map[string]*Type, len(t.Fields)))
+		for _, field := range t.Fields {
+			ft, err := c.convert(field.Type)
+			if err != nil {
+				return nil, err
+			}
+			obj.Fields[field.Name] = ft
+			if field.Optional {
+				if obj.Optional == nil {
+					obj.Optional = make(map[string]bool)
+				}
+				obj.Optional[field.Name] = true
+			}
+		}
+		return obj, nil
+
+	case *parser.UnionType:
+		members := make([]*Type, len(t.Types))
+		for i, member := range t.Types {
+			mt, err := c.convert(member)
+			if err != nil {
+				return nil, err
+			}
+			members[i] = mt
+		}
+		return UnionOf(members...), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported type: %T", expr)
+	}
+}
+
+// indirect starts converting the elements of an array or the fields of an
+// object, which may refer to the declared types around them. It returns a
+// function that ends it.
+func (c *converter) indirect() func() {
+	direct := c.direct
+	c.direct = nil
+	return func() { c.direct = direct }
+}
+
+// convertNamed resolves a builtin or declared type. A declared type is
+// registered before its definition is converted, so references to it from
+// inside the definition share the same Type. Those references must be in
+// an array or an object: a type that is an alias of itself (e.g.,
+// type A = B and type B = A) has no definition.
+func (c *converter) convertNamed(t *parser.NamedType) (*Type, error) {
+	if builtin, ok := Builtin(t.Name); ok {
+		return builtin, nil
+	}
+	if i := slices.Index(c.direct, t.Name); i >= 0 {
+		cycle := append(slices.Clone(c.direct[i:]), t.Name)
+		return nil, fmt.Errorf("circular type: %s", strings.Join(cycle, " -> "))
+	}
+	if named, ok := c.named[t.Name]; ok {
+		return named, nil
+	}
+
+	decl, ok := c.lookup(t.Name)
+	if !ok {
+		return nil, fmt.Errorf("undefined type: %s", t.Name)
+	}
+
+	named := &Type{Name: t.Name}
+	c.named[t.Name] = named
+	c.direct = append(c.direct, t.Name)
+	def, err := c.convert(decl.Type)
+	c.direct = c.direct[:len(c.direct)-1]
+	if err != nil {
+		return nil, err
+	}
+	*named = *def
+	named.Name = t.Name
+	return named, nil
+}
@@ -0,0 +1,266 @@
+// Package types describes the static types of helmtk values, which are
+// used to check documents for type errors before they're evaluated.
+package types
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"helmtk.dev/code/htkl/runtime"
+)
+
+// Kind is the basic kind of a type
+type Kind int
+
+const (
+	KindAny Kind = iota // Unknown, any value is allowed
+	KindNull
+	KindBool
+	KindNumber
+	KindString
+	KindArray
+	KindObject
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindAny:
+		return "any"
+	case KindNull:
+		return "null"
+	case KindBool:
+		return "bool"
+	case KindNumber:
+		return "number"
+	case KindString:
+		return "string"
+	case KindArray:
+		return "array"
+	case KindObject:
+		return "object"
+	default:
+		return "unknown"
+	}
+}
+
+// Type is the static type of a value. Types inferred from a document only
+// have a kind, and element and field types. Declared types can be refined
+// further, to literal values, whole numbers, unions and optional fields.
+type Type struct {
+	Kind     Kind
+	Elem     *Type            // Element type of arrays, nil if unknown
+	Fields   map[string]*Type // Known fields of objects, others may exist
+	Optional map[string]bool  // Fields that may be missing from an object
+	Integer  bool             // Numbers must be whole
+	Literal  runtime.Value    // The only value allowed, if set
+	Union    []*Type          // Values must match one of these types, if set
+	Name     string           // Name the type was declared with, for messages
+}
+
+var (
+	Any    = &Type{Kind: KindAny}
+	Null   = &Type{Kind: KindNull}
+	Bool   = &Type{Kind: KindBool}
+	Number = &Type{Kind: KindNumber}
+	Int    = &Type{Kind: KindNumber, Integer: true}
+	String = &Type{Kind: KindString}
+)
+
+// builtins are the types that can be referred to by name in declarations
+var builtins = map[string]*Type{
+	"any":    Any,
+	"null":   Null,
+	"bool":   Bool,
+	"number": Number,
+	"int":    Int,
+	"string": String,
+	"array":  ArrayOf(nil),
+	"object": ObjectOf(nil),
+}
+
+// Builtin returns the builtin type with the given name
+func Builtin(name string) (*Type, bool) {
+	t, ok := builtins[name]
+	return t, ok
+}
+
+// BuiltinNames returns the names of the builtin types, sorted
+func BuiltinNames() []string {
+	return slices.Sorted(maps.Keys(builtins))
+}
+
+// ArrayOf returns the type of arrays with elements of the given type
+func ArrayOf(elem *Type) *Type {
+	return &Type{Kind: KindArray, Elem: elem}
+}
+
+// ObjectOf returns the type of objects with the given known fields
+func ObjectOf(fields map[string]*Type) *Type {
+	return &Type{Kind: KindObject, Fields: fields}
+}
+
+// LiteralOf returns the type that only allows the given value
+func LiteralOf(v runtime.Value) *Type {
+	t := *Of(v)
+	t.Literal = v
+	return &t
+}
+
+// UnionOf returns the type of values that match any of the given types.
+// When they all have the same kind, so does the union.
+func UnionOf(types ...*Type) *Type {
+	u := &Type{Kind: types[0].Kind, Union: types}
+	for _, t := range types[1:] {
+		if t.Kind != u.Kind {
+			u.Kind = KindAny
+		}
+	}
+	return u
+}
+
+func (t *Type) String() string {
+	switch {
+	case t.Name != "":
+		return t.Name
+	case t.Union != nil:
+		members := make([]string, len(t.Union))
+		for i, member := range t.Union {
+			members[i] = member.String()
+			if member.Union != nil && member.Name == "" {
+				members[i] = "(" + members[i] + ")"
+			}
+		}
+		return strings.Join(members, " | ")
+	case t.Literal != nil:
+		if str, ok := t.Literal.(*runtime.StringValue); ok {
+			return strconv.Quote(str.Value)
+		}
+		return t.Literal.String()
+	case t.Integer:
+		return "int"
+	}
+
+	switch t.Kind {
+	case KindArray:
+		if t.Elem == nil || t.Elem.Kind == KindAny {
+			return "array"
+		}
+		return "[]" + t.Elem.String()
+	case KindObject:
+		if len(t.Fields) == 0 {
+			return "object"
+		}
+		var fields []string
+		for _, name := range slices.Sorted(maps.Keys(t.Fields)) {
+			key := name
+			if t.Optional[name] {
+				key += "?"
+			}
+			fields = append(fields, fmt.Sprintf("%s: %s", key, t.Fields[name]))
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	default:
+		return t.Kind.String()
+	}
+}
+
+// IsAny reports whether nothing is known about the kind of the type
+func (t *Type) IsAny() bool {
+	return t == nil || t.Kind == KindAny
+}
+
+// Element returns the element type of an array type, or Any if unknown
+func (t *Type) Element() *Type {
+	if t.Kind != KindArray || t.Elem == nil {
+		return Any
+	}
+	return t.Elem
+}
+
+// Field returns the type of an object's field, or Any if it isn't known
+func (t *Type) Field(name string) *Type {
+	if field, ok := t.Fields[name]; ok {
+		return field
+	}
+	return Any
+}
+
+// Of returns the type of a value
+func Of(v runtime.Value) *Type {
+	switch val := v.(type) {
+	case *runtime.NullValue:
+		return Null
+	case *runtime.BoolValue:
+		return Bool
+	case *runtime.NumberValue:
+		return Number
+	case *runtime.StringValue:
+		return String
+	case *runtime.ArrayValue:
+		var elem *Type
+		for i, e := range val.Elements {
+			if i == 0 {
+				elem = Of(e)
+			} else {
+				elem = Join(elem, Of(e))
+			}
+		}
+		return ArrayOf(elem)
+	case *runtime.ObjectValue:
+		fields := make(map[string]*Type, len(val.Fields))
+		for name, field := range val.Fields {
+			fields[name] = Of(field)
+		}
+		return ObjectOf(fields)
+	default:
+		return Any
+	}
+}
+
+// Join returns a type that describes values of either type. Null joins
+// with any type to give that type, as missing values are often null. The
+// result only keeps what is known about kinds, elements and fields.
+func Join(a, b *Type) *Type {
+	switch {
+	case a.IsAny() || b.IsAny():
+		return Any
+	case a.Kind == KindNull:
+		return b
+	case b.Kind == KindNull:
+		return a
+	case a.Kind != b.Kind:
+		return Any
+	}
+
+	switch a.Kind {
+	case KindArray:
+		if a.Elem == nil || b.Elem == nil {
+			return ArrayOf(nil)
+		}
+		return ArrayOf(Join(a.Elem, b.Elem))
+	case KindObject:
+		// Only fields both types have are known
+		fields := make(map[string]*Type)
+		for name, field := range a.Fields {
+			if other, ok := b.Fields[name]; ok {
+				fields[name] = Join(field, other)
+			}
+		}
+		return ObjectOf(fields)
+	default:
+		if a.Integer && b.Integer && a.Literal == nil && b.Literal == nil {
+			return Int
+		}
+		return &Type{Kind: a.Kind}
+	}
+}
+
+// Signature describes the parameters and result of a function
+type Signature struct {
+	Params   []*Type
+	Variadic bool  // Whether the last parameter can be repeated
+	Result   *Type // nil when the result is unknown
+}
@@ -0,0 +1,49 @@
+package types
+
+import (
+	"testing"
+
+	"helmtk.dev/code/htkl/runtime"
+)
+
+func TestOf(t *testing.T) {
+	val := runtime.NewValue(map[string]any{
+		"name":     "web",
+		"replicas": 3,
+		"ports":    []any{80, 443},
+		"mixed":    []any{1, "two"},
+		"tags":     []any{},
+		"debug":    nil,
+	})
+
+	want := "{debug: null, mixed: array, name: string, ports: []number, replicas: number, tags: array}"
+	if got := Of(val).String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	tests := []struct {
+		a, b *Type
+		want string
+	}{
+		{Number, Number, "number"},
+		{Null, String, "string"},
+		{String, Null, "string"},
+		{Number, String, "any"},
+		{Any, Number, "any"},
+		{ArrayOf(Number), ArrayOf(String), "array"},
+		{ArrayOf(Number), ArrayOf(Null), "[]number"},
+		{
+			ObjectOf(map[string]*Type{"a": Number, "b": String}),
+			ObjectOf(map[string]*Type{"a": Number, "c": Bool}),
+			"{a: number}",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := Join(tt.a, tt.b).String(); got != tt.want {
+			t.Errorf("Join(%s, %s) = %s, want %s", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package types
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"unicode"
+
+	"helmtk.dev/code/htkl/runtime"
+)
+
+// ValidationError describes a value that doesn't match a type
+type ValidationError struct {
+	Path    string // Path to the mismatched value, like ports[2].port
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Matches reports whether a value matches the type
+func (t *Type) Matches(v runtime.Value) bool {
+	return t.Validate(v, "") == nil
+}
+
+// Validate checks a value against the type, and returns a ValidationError
+// for the first part of it that doesn't match. Path names the value in the
+// error, and the paths of its elements and fields are appended to it.
+// Objects may have fields the type doesn't mention.
+func (t *Type) Validate(v runtime.Value, path string) error {
+	if t.Union != nil {
+		return t.validateUnion(v, path)
+	}
+
+	if t.Literal != nil {
+		if !runtime.Equal(v, t.Literal) {
+			return mismatch(t, v, path)
+		}
+		return nil
+	}
+
+	switch t.Kind {
+	case KindAny:
+		return nil
+
+	case KindNull, KindBool, KindString:
+		if kindOf(v) != t.Kind {
+			return mismatch(t, v, path)
+		}
+		return nil
+
+	case KindNumber:
+		num, ok := v.(*runtime.NumberValue)
+		if !ok || (t.Integer && num.Value != float64(int64(num.Value))) {
+			return mismatch(t, v, path)
+		}
+		return nil
+
+	case KindArray:
+		arr, ok := v.(*runtime.ArrayValue)
+		if !ok {
+			return mismatch(t, v, path)
+		}
+		if t.Elem == nil {
+			return nil
+		}
+		for i, elem := range arr.Elements {
+			if err := t.Elem.Validate(elem, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case KindObject:
+		obj, ok := v.(*runtime.ObjectValue)
+		if !ok {
+			return mismatch(t, v, path)
+		}
+		for _, name := range slices.Sorted(maps.Keys(t.Fields)) {
+			field, ok := obj.Get(name)
+			if !ok {
+				if t.Optional[name] {
+					continue
+				}
+				return &ValidationError{Path: fieldPath(path, name), Message: "required field is missing"}
+			}
+			if err := t.Fields[name].Validate(field, fieldPath(path, name)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return mismatch(t, v, path)
+	}
+}
+
+// validateUnion checks a value against each member of a union. When only
+// one member has the value's kind, its error is returned, since it's
+// likely the one that was meant.
+func (t *Type) validateUnion(v runtime.Value, path string) error {
+	var candidates []*Type
+	for _, member := range t.Union {
+		if member.Matches(v) {
+			return nil
+		}
+		if member.Kind == kindOf(v) {
+			candidates = append(candidates, member)
+		}
+	}
+	if len(candidates) == 1 && candidates[0].Literal == nil {
+		return candidates[0].Validate(v, path)
+	}
+	return mismatch(t, v, path)
+}
+
+// mismatch reports that a value doesn't match the type. Scalars of the
+// right kind that still don't match are shown, rather than their kind.
+func mismatch(t *Type, v runtime.Value, path string) error {
+	got := v.Type().String()
+	if k := kindOf(v); k == t.Kind && k != KindArray && k != KindObject {
+		got = v.String()
+		if str, ok := v.(*runtime.StringValue); ok {
+			got = strconv.Quote(str.Value)
+		}
+	}
+	return &ValidationError{Path: path, Message: fmt.Sprintf("expected %s, got %s", t, got)}
+}
+
+// kindOf returns the kind of a value
+func kindOf(v runtime.Value) Kind {
+	switch v.Type() {
+	case runtime.NullType:
+		return KindNull
+	case runtime.BoolType:
+		return KindBool
+	case runtime.NumberType:
+		return KindNumber
+	case runtime.StringType:
+		return KindString
+	case runtime.ArrayType:
+		return KindArray
+	case runtime.ObjectType:
+		return KindObject
+	default:
+		return KindAny
+	}
+}
+
+// fieldPath appends a field name to a path, quoting names that aren't
+// identifiers
+func fieldPath(path, name string) string {
+	if !isIdentifier(name) {
+		return fmt.Sprintf("%s[%s]", path, strconv.Quote(name))
+	}
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func isIdentifier(s string) bool {
+	for i, ch := range s {
+		if !unicode.IsLetter(ch) && ch != '_' && (i == 0 || !unicode.IsDigit(ch)) {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,100 @@
+package types
+
+import (
+	"testing"
+
+	"helmtk.dev/code/htkl/parser"
+	"helmtk.dev/code/htkl/runtime"
+)
+
+func TestValidate(t *testing.T) {
+	doc, err := parser.New(`
+type Protocol = "TCP" | "UDP"
+type Port = {name: string, port: int, protocol?: Protocol, "node-port"?: int}
+type Tree = {name: string, children?: [Tree]}
+`, "").Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	decls := make(map[string]*parser.TypeDecl)
+	for _, decl := range doc.Types {
+		decls[decl.Name] = decl
+	}
+	typeOf := func(name string) *Type {
+		typ, err := FromExpr(&parser.NamedType{Name: name}, func(name string) (*parser.TypeDecl, bool) {
+			decl, ok := decls[name]
+			return decl, ok
+		})
+		if err != nil {
+			t.Fatalf("converting %s: %v", name, err)
+		}
+		return typ
+	}
+
+	port := map[string]any{"name": "http", "port": 80}
+	tests := []struct {
+		typ   *Type
+		value any
+		want  string
+	}{
+		{typeOf("Port"), port, ""},
+		{ArrayOf(typeOf("Port")), []any{port, map[string]any{"name": "x", "port": "80"}}, "ports[1].port: expected int, got string"},
+		{typeOf("Port"), map[string]any{"name": "http", "port": 80.5}, "ports.port: expected int, got 80.5"},
+		{typeOf("Port"), map[string]any{"port": 80}, "ports.name: required field is missing"},
+		{typeOf("Port"), map[string]any{"name": "dns", "port": 53, "protocol": "SCTP"}, `ports.protocol: expected Protocol, got "SCTP"`},
+		{typeOf("Port"), map[string]any{"name": "dns", "port": 53, "node-port": true}, `ports["node-port"]: expected int, got bool`},
+		{typeOf("Port"), map[string]any{"name": "http", "port": 80, "extra": 1}, ""},
+		{typeOf("Tree"), map[string]any{"name": "a", "children": []any{map[string]any{"name": 1}}}, "ports.children[0].name: expected string, got number"},
+		{UnionOf(String, Int), 1.5, "ports: expected int, got 1.5"},
+		{UnionOf(String, Null), 1, "ports: expected string | null, got number"},
+	}
+
+	for _, tt := range tests {
+		err := tt.typ.Validate(runtime.NewValue(tt.value), "ports")
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestCircularType(t *testing.T) {
+	doc, err := parser.New(`
+type A = B
+type B = A | string
+type C = C
+type Tree = {children: [Tree]} | Leaf
+type Leaf = string
+`, "").Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	lookup := func(name string) (*parser.TypeDecl, bool) {
+		for _, decl := range doc.Types {
+			if decl.Name == name {
+				return decl, true
+			}
+		}
+		return nil, false
+	}
+
+	tests := map[string]string{
+		"A":    "circular type: A -> B -> A",
+		"B":    "circular type: B -> A -> B",
+		"C":    "circular type: C -> C",
+		"Tree": "",
+	}
+	for name, want := range tests {
+		_, err := FromExpr(&parser.NamedType{Name: name}, lookup)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}